@@ -0,0 +1,607 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Principal is an authenticated client, as identified by an API key, HTTP
+// Basic authentication or a JSON Web Token
+type Principal struct {
+	Name   string
+	Groups []string
+}
+
+// AccessRule specifies who may access the URIs whose paths start with
+// PathPrefix. Allowed can contain "public" (anyone, also unauthenticated),
+// "authenticated" (any authenticated client), user names, and group names
+// prefixed with "group:" (from the "groups" claim of JSON Web Tokens).
+type AccessRule struct {
+	PathPrefix string
+	Allowed    []string
+}
+
+// Authenticator authenticates requests with any of the configured methods,
+// and decides whether they may access the requested path according to Rules.
+// Paths not covered by any rule are public.
+type Authenticator struct {
+	APIKeys     map[string]string // API key -> principal name
+	Htpasswd    map[string]string // user name -> password hash
+	JWKS        map[string]crypto.PublicKey
+	JWTIssuer   string
+	JWTAudience string
+	Rules       []AccessRule
+}
+
+// newAuthenticator creates an Authenticator with the access rules in
+// rulesPath, accepting the credentials in the API key, htpasswd and JWKS
+// files given (any of which may be empty, to disable that method)
+func newAuthenticator(rulesPath string, apiKeysPath string, htpasswdPath string, jwksPath string) (*Authenticator, error) {
+	var err error
+	a := &Authenticator{}
+	if a.Rules, err = loadAccessRules(rulesPath); err != nil {
+		return nil, err
+	}
+	if apiKeysPath != "" {
+		if a.APIKeys, err = loadAPIKeys(apiKeysPath); err != nil {
+			return nil, err
+		}
+	}
+	if htpasswdPath != "" {
+		if a.Htpasswd, err = loadHtpasswd(htpasswdPath); err != nil {
+			return nil, err
+		}
+	}
+	if jwksPath != "" {
+		if a.JWKS, err = loadJWKS(jwksPath); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// errInvalidCredentials is returned when a request carries credentials
+// which could not be verified
+var errInvalidCredentials = errors.New("Invalid credentials")
+
+type principalContextKey struct{}
+
+type authenticatorContextKey struct{}
+
+// principalFromContext returns the principal which made the request with the
+// given context, or nil if the request was not authenticated
+func principalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// withAuth authenticates and authorizes requests before passing them on to
+// handler, answering 401 Unauthorized if authentication is required or the
+// credentials are invalid, and 403 Forbidden if the principal is not allowed
+// to access the path. This happens before any backend query is run.
+func withAuth(auth *Authenticator, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := auth.authenticate(r)
+		if err != nil {
+			auth.challenge(w)
+			http.Error(w, "Error: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if !auth.allowAccess(w, r.URL.Path, p) {
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authenticatorContextKey{}, auth)
+		if p != nil {
+			ctx = context.WithValue(ctx, principalContextKey{}, p)
+		}
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// allowAccess checks that p (nil for unauthenticated clients) may access the
+// URIs with path, answering 401 or 403 if not
+func (a *Authenticator) allowAccess(w http.ResponseWriter, path string, p *Principal) bool {
+	if a == nil {
+		return true
+	}
+	rule := a.ruleFor(path)
+	if rule != nil && !containsFold(rule.Allowed, "public") {
+		if p == nil {
+			a.challenge(w)
+			http.Error(w, "Error: Authentication required", http.StatusUnauthorized)
+			return false
+		}
+		if !rule.allows(p) {
+			http.Error(w, "Error: Access to this resource is not allowed for "+p.Name, http.StatusForbidden)
+			return false
+		}
+		// Make sure restricted resources don't end up in shared caches
+		w.Header().Set("Cache-Control", "private")
+	}
+	return true
+}
+
+// authenticatorFromContext returns the Authenticator of the request with
+// ctx, set by withAuth, or nil
+func authenticatorFromContext(ctx context.Context) *Authenticator {
+	auth, _ := ctx.Value(authenticatorContextKey{}).(*Authenticator)
+	return auth
+}
+
+// mayRead returns true if p (nil for unauthenticated clients) may access
+// the URIs with path, which is always the case without an Authenticator
+func (a *Authenticator) mayRead(path string, p *Principal) bool {
+	if a == nil {
+		return true
+	}
+	rule := a.ruleFor(path)
+	if rule == nil || containsFold(rule.Allowed, "public") {
+		return true
+	}
+	return p != nil && rule.allows(p)
+}
+
+// mayReadIRI returns true if the client which made the request with ctx may
+// read the resource iri, served at its path under uriHost. The access rules
+// are checked by withAuth for the requested path only, so this is used for
+// the other resources which responses mention, such as search hits. IRIs
+// outside uriHost are not covered by the rules.
+func mayReadIRI(ctx context.Context, uriHost string, iri string) bool {
+	if !strings.HasPrefix(iri, uriHost+"/") {
+		return true
+	}
+	return authenticatorFromContext(ctx).mayRead(iri[len(uriHost):], principalFromContext(ctx))
+}
+
+// hasAccessRules returns true if access rules apply to the request with ctx
+func hasAccessRules(ctx context.Context) bool {
+	auth := authenticatorFromContext(ctx)
+	return auth != nil && len(auth.Rules) > 0
+}
+
+// publicIRI returns true if anyone, also unauthenticated clients, may read
+// the resource iri (see mayReadIRI)
+func publicIRI(ctx context.Context, uriHost string, iri string) bool {
+	if !strings.HasPrefix(iri, uriHost+"/") {
+		return true
+	}
+	return authenticatorFromContext(ctx).mayRead(iri[len(uriHost):], nil)
+}
+
+// challenge adds WWW-Authenticate headers for the enabled methods. API keys
+// have no registered scheme, so they are announced with an APIKey challenge
+// naming the header to send them in, since a 401 response always needs a
+// challenge (RFC 7235).
+func (a *Authenticator) challenge(w http.ResponseWriter) {
+	if len(a.Htpasswd) > 0 {
+		w.Header().Add("WWW-Authenticate", `Basic realm="urisolve"`)
+	}
+	if len(a.JWKS) > 0 {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="urisolve"`)
+	}
+	if len(a.APIKeys) > 0 || w.Header().Get("WWW-Authenticate") == "" {
+		w.Header().Add("WWW-Authenticate", `APIKey realm="urisolve", header="X-API-Key"`)
+	}
+}
+
+// ruleFor returns the rule with the longest path prefix matching path, or nil
+// if there is none
+func (a *Authenticator) ruleFor(path string) *AccessRule {
+	var match *AccessRule
+	for i, rule := range a.Rules {
+		if strings.HasPrefix(path, rule.PathPrefix) && (match == nil || len(rule.PathPrefix) > len(match.PathPrefix)) {
+			match = &a.Rules[i]
+		}
+	}
+	return match
+}
+
+func (rule *AccessRule) allows(p *Principal) bool {
+	for _, allowed := range rule.Allowed {
+		switch {
+		case allowed == "public" || allowed == "authenticated":
+			return true
+		case strings.HasPrefix(allowed, "group:"):
+			for _, group := range p.Groups {
+				if group == allowed[len("group:"):] {
+					return true
+				}
+			}
+		case allowed == p.Name:
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the principal identified by the credentials in r, nil
+// if there are none, or errInvalidCredentials if they can not be verified
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		for validKey, name := range a.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(validKey)) == 1 {
+				return &Principal{Name: name}, nil
+			}
+		}
+		return nil, errInvalidCredentials
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		hash, ok := a.Htpasswd[user]
+		if !ok || !verifyHtpasswd(hash, password) {
+			return nil, errInvalidCredentials
+		}
+		return &Principal{Name: user}, nil
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		p, err := a.verifyJWT(authorization[7:], time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s)", errInvalidCredentials.Error(), err.Error())
+		}
+		return p, nil
+	}
+
+	return nil, nil
+}
+
+// loadAccessRules loads access rules from a file with one rule per line,
+// consisting of a path prefix followed by a comma separated list of who is
+// allowed, e.g.:
+//
+//	/cplogd/    public
+//	/preprint/  alice,group:collaborators
+//
+// Empty lines and lines starting with # are ignored.
+func loadAccessRules(path string) ([]AccessRule, error) {
+	var rules []AccessRule
+	err := readConfigLines(path, func(fields []string) error {
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "/") {
+			return errors.New("expected a path prefix starting with / and a comma separated list of who is allowed")
+		}
+		rules = append(rules, AccessRule{PathPrefix: fields[0], Allowed: splitList(fields[1])})
+		return nil
+	})
+	return rules, err
+}
+
+// loadAPIKeys loads API keys from a file with one "name key" pair per line
+func loadAPIKeys(path string) (map[string]string, error) {
+	keys := make(map[string]string)
+	err := readConfigLines(path, func(fields []string) error {
+		if len(fields) != 2 {
+			return errors.New("expected a name and an API key")
+		}
+		keys[fields[1]] = fields[0]
+		return nil
+	})
+	return keys, err
+}
+
+// loadHtpasswd loads users and password hashes from an htpasswd file, as
+// created by Apache's htpasswd tool. Supported hashes are bcrypt (-B),
+// MD5 (-m, the default) and SHA1 (-s).
+func loadHtpasswd(path string) (map[string]string, error) {
+	users := make(map[string]string)
+	err := readConfigLines(path, func(fields []string) error {
+		parts := strings.SplitN(strings.Join(fields, " "), ":", 2)
+		if len(parts) != 2 {
+			return errors.New("expected user:hash")
+		}
+		users[parts[0]] = parts[1]
+		return nil
+	})
+	return users, err
+}
+
+func verifyHtpasswd(hash string, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$apr1$"):
+		parts := strings.SplitN(hash, "$", 4)
+		if len(parts) != 4 {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(apr1Hash(password, parts[2])), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte("{SHA}"+base64.StdEncoding.EncodeToString(sum[:])), []byte(hash)) == 1
+	}
+	return false
+}
+
+// apr1Hash computes the Apache variant of the MD5 crypt password hash
+func apr1Hash(password string, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alt := md5.Sum([]byte(password + salt + password))
+	ctx := md5.New()
+	ctx.Write([]byte(password + magic + salt))
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(alt[:])
+		} else {
+			ctx.Write(alt[:i])
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write([]byte{password[0]})
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write([]byte(password))
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write([]byte(password))
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write([]byte(password))
+		}
+		final = round.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var encoded []byte
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			encoded = append(encoded, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	to64(uint32(final[0])<<16|uint32(final[6])<<8|uint32(final[12]), 4)
+	to64(uint32(final[1])<<16|uint32(final[7])<<8|uint32(final[13]), 4)
+	to64(uint32(final[2])<<16|uint32(final[8])<<8|uint32(final[14]), 4)
+	to64(uint32(final[3])<<16|uint32(final[9])<<8|uint32(final[15]), 4)
+	to64(uint32(final[4])<<16|uint32(final[10])<<8|uint32(final[5]), 4)
+	to64(uint32(final[11]), 2)
+
+	return magic + salt + "$" + string(encoded)
+}
+
+// jsonWebKey is a (public) key in a JSON Web Key Set, as specified in RFC 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS loads the RSA and EC public keys from a JSON Web Key Set file,
+// indexed by key ID
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("Could not parse JWKS file %s (%s)", path, err.Error())
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("Could not read key %q in JWKS file %s (%s)", jwk.Kid, path, err.Error())
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verifyJWT verifies the signature and claims of a compact serialized JSON
+// Web Token, signed with RS256/384/512 or ES256/384/512, against the keys in
+// the JWKS of a
+func (a *Authenticator) verifyJWT(token string, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	key, ok := a.JWKS[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims struct {
+		Subject   string          `json:"sub"`
+		Issuer    string          `json:"iss"`
+		Audience  json.RawMessage `json:"aud"`
+		ExpiresAt *float64        `json:"exp"`
+		NotBefore *float64        `json:"nbf"`
+		Groups    []string        `json:"groups"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	const leeway = time.Minute
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiry time")
+	}
+	if now.Add(-leeway).After(time.Unix(int64(*claims.ExpiresAt), 0)) {
+		return nil, errors.New("token has expired")
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if a.JWTIssuer != "" && claims.Issuer != a.JWTIssuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if a.JWTAudience != "" && !jwtAudienceContains(claims.Audience, a.JWTAudience) {
+		return nil, errors.New("token is not meant for this audience")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	return &Principal{Name: claims.Subject, Groups: claims.Groups}, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %q does not match RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("algorithm %q or signature does not match EC key", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key type")
+}
+
+// jwtAudienceContains checks the aud claim, which can be a string or a list
+// of strings, for audience
+func jwtAudienceContains(aud json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(aud, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(aud, &list) == nil {
+		for _, a := range list {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifyHtpasswd(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := []struct {
+		hash     string
+		password string
+		ok       bool
+	}{
+		{"$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0", "secret", true},
+		{"$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0", "Secret", false},
+		{"$apr1$ab$cqm3DS4DM/KOM4Qo/qwxF0", "a much longer password, over 16 chars", true},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret", true},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secre", false},
+		{string(bcryptHash), "secret", true},
+		{string(bcryptHash), "wrong", false},
+		{"secret", "secret", false},
+	}
+	for _, test := range hashes {
+		if verifyHtpasswd(test.hash, test.password) != test.ok {
+			t.Errorf("Expected verifying %q against %s to give %v", test.password, test.hash, test.ok)
+		}
+	}
+}
+
+// signJWT creates an ES256 signed JSON Web Token with the given claims
+func signJWT(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := jsonWebKey{
+		Kty: "EC",
+		Kid: "key1",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+	publicKey, err := jwk.publicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &Authenticator{
+		JWKS:        map[string]crypto.PublicKey{"key1": publicKey},
+		JWTIssuer:   "https://login.pharmb.io",
+		JWTAudience: "urisolve",
+	}
+
+	now := time.Now()
+	valid := map[string]interface{}{
+		"sub":    "alice",
+		"iss":    "https://login.pharmb.io",
+		"aud":    []string{"urisolve", "other"},
+		"exp":    now.Add(time.Hour).Unix(),
+		"groups": []string{"collaborators"},
+	}
+	withClaim := func(name string, value interface{}) map[string]interface{} {
+		claims := make(map[string]interface{})
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[name] = value
+		return claims
+	}
+
+	tokens := map[string]bool{
+		signJWT(t, key, "key1", valid):                                        true,
+		signJWT(t, otherKey, "key1", valid):                                   false,
+		signJWT(t, key, "key2", valid):                                        false,
+		signJWT(t, key, "key1", withClaim("exp", now.Add(-time.Hour).Unix())): false,
+		signJWT(t, key, "key1", withClaim("iss", "https://evil.org")):         false,
+		signJWT(t, key, "key1", withClaim("aud", "other")):                    false,
+		"not.a.token": false,
+	}
+	for token, shouldBeOk := range tokens {
+		p, err := auth.verifyJWT(token, now)
+		if (err == nil) != shouldBeOk {
+			t.Errorf("Unexpected result verifying token %s: %v", token, err)
+		}
+		if shouldBeOk && (p.Name != "alice" || len(p.Groups) != 1 || p.Groups[0] != "collaborators") {
+			t.Errorf("Unexpected principal from token: %+v", p)
+		}
+	}
+}
+
+func TestVerifyJWTSignatureRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := []byte("header.payload")
+	digest := sha256.Sum256(signed)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk := jsonWebKey{
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+	publicKey, err := jwk.publicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyJWTSignature("RS256", publicKey, signed, signature); err != nil {
+		t.Errorf("Expected a valid RS256 signature, got: %v", err)
+	}
+	if err := verifyJWTSignature("ES256", publicKey, signed, signature); err == nil {
+		t.Error("Expected an algorithm not matching the key to be rejected")
+	}
+	if err := verifyJWTSignature("RS256", publicKey, []byte("header.tampered"), signature); err == nil {
+		t.Error("Expected a signature of other content to be rejected")
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	auth := &Authenticator{
+		APIKeys:  map[string]string{"key-of-bob": "bob", "key-of-carol": "carol"},
+		Htpasswd: map[string]string{"alice": "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0"},
+		Rules: []AccessRule{
+			{"/", []string{"public"}},
+			{"/preprint/", []string{"alice", "bob"}},
+			{"/preprint/shared/", []string{"authenticated"}},
+		},
+	}
+	backendQueried := false
+	handler := withAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendQueried = true
+	}))
+
+	tests := []struct {
+		path         string
+		apiKey       string
+		user         string
+		password     string
+		expectedCode int
+	}{
+		{"/cplogd/Compound1", "", "", "", http.StatusOK},
+		{"/preprint/Compound2", "", "", "", http.StatusUnauthorized},
+		{"/preprint/Compound2", "key-of-bob", "", "", http.StatusOK},
+		{"/preprint/Compound2", "key-of-carol", "", "", http.StatusForbidden},
+		{"/preprint/Compound2", "wrong-key", "", "", http.StatusUnauthorized},
+		{"/preprint/Compound2", "", "alice", "secret", http.StatusOK},
+		{"/preprint/Compound2", "", "alice", "wrong", http.StatusUnauthorized},
+		{"/preprint/shared/Compound3", "key-of-carol", "", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		backendQueried = false
+		r := httptest.NewRequest("GET", test.path, nil)
+		if test.apiKey != "" {
+			r.Header.Set("X-API-Key", test.apiKey)
+		}
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != test.expectedCode {
+			t.Errorf("%s (key %q, user %q): expected status %d, got %d", test.path, test.apiKey, test.user, test.expectedCode, rec.Code)
+		}
+		if backendQueried != (test.expectedCode == http.StatusOK) {
+			t.Errorf("%s (key %q, user %q): handler called for a request with status %d", test.path, test.apiKey, test.user, rec.Code)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != `Basic realm="urisolve"` {
+			t.Errorf("Expected a Basic authentication challenge, got %q", rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestChallengeWithAPIKeysOnly(t *testing.T) {
+	auth := &Authenticator{
+		APIKeys: map[string]string{"key-of-bob": "bob"},
+		Rules:   []AccessRule{{"/preprint/", []string{"bob"}}},
+	}
+	handler := withAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/preprint/Compound2", nil))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != `APIKey realm="urisolve", header="X-API-Key"` {
+		t.Errorf("Expected a challenge naming the API key header, got %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// browsePageSize is the number of instances shown per page of a class
+const browsePageSize = 100
+
+// UsageCount is a class with its number of instances, or a property with the
+// number of triples using it
+type UsageCount struct {
+	IRI   string `json:"iri"`
+	Count int64  `json:"count"`
+}
+
+// Browser is implemented by sources which can list the classes and
+// properties of their dataset
+type Browser interface {
+	// Classes returns the objects of rdf:type triples, with the number of
+	// instances of each, most used first
+	Classes(ctx context.Context) ([]UsageCount, error)
+	// Instances returns (at most limit) instances of class, in a stable
+	// order, starting at offset
+	Instances(ctx context.Context, class string, offset int, limit int) ([]string, error)
+	// Properties returns the predicates used in the dataset, with the number
+	// of triples using each, most used first
+	Properties(ctx context.Context) ([]UsageCount, error)
+}
+
+// sortUsageCounts sorts counts by decreasing count, and then by IRI
+func sortUsageCounts(counts []UsageCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].IRI < counts[j].IRI
+	})
+}
+
+// hdtTypes are the classes in a set of HDT files, with their instances, as
+// read from the rdf:type triples
+type hdtTypes struct {
+	versions  []fileVersion
+	classes   []UsageCount
+	instances map[string][]string // Sorted, by class
+}
+
+// types returns the classes in the HDT files in use. They are read with a
+// "? rdf:type ?" pattern once for each version of the files, and shared by
+// the dataset statistics and the browse pages.
+func (s *HdtSource) types(ctx context.Context) (*hdtTypes, error) {
+	s.typesMu.Lock()
+	defer s.typesMu.Unlock()
+	versions := s.currentVersions()
+	if s.typeCache != nil && len(versions) > 0 && sameHdtFiles(s.typeCache.versions, versions, true) {
+		return s.typeCache, nil
+	}
+	var paths []string
+	for _, v := range versions {
+		paths = append(paths, v.Path)
+	}
+	if len(paths) == 0 {
+		paths = s.paths()
+	}
+	triples, err := s.queryHdtFiles(ctx, paths, "? "+rdfType+" ?")
+	if err != nil {
+		return nil, err
+	}
+	types := &hdtTypes{versions: versions, instances: make(map[string][]string)}
+	for _, t := range triples {
+		class := t.Obj.String()
+		types.instances[class] = append(types.instances[class], t.Subj.String())
+	}
+	for class, instances := range types.instances {
+		sort.Strings(instances)
+		types.classes = append(types.classes, UsageCount{class, int64(len(instances))})
+	}
+	sortUsageCounts(types.classes)
+	s.typeCache = types
+	return types, nil
+}
+
+// Classes counts the instances of each class in the rdf:type triples
+func (s *HdtSource) Classes(ctx context.Context) ([]UsageCount, error) {
+	types, err := s.types(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return types.classes, nil
+}
+
+// Instances lists the instances of class in the rdf:type triples
+func (s *HdtSource) Instances(ctx context.Context, class string, offset int, limit int) ([]string, error) {
+	types, err := s.types(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pageOf(types.instances[class], offset, limit), nil
+}
+
+// Properties counts the triples of each predicate in the dictionaries of the
+// HDT files, with a "? p ?" pattern for each, all counted by one hdtSearch
+// process per file, without reading the triples. With several files, the
+// counts are summed, so triples in several files are counted more than once.
+func (s *HdtSource) Properties(ctx context.Context) ([]UsageCount, error) {
+	counts := make(map[string]int64)
+	for _, path := range s.paths() {
+		var queries []string
+		_, err := readHdtDictionary(path, func(section hdtSection, term string) {
+			if section == hdtPredicates {
+				queries = append(queries, "? "+term+" ?")
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(queries) == 0 {
+			continue
+		}
+		n, err := countHdtFile(ctx, path, queries)
+		if err != nil {
+			return nil, err
+		}
+		for i, query := range queries {
+			counts[query[2:len(query)-2]] += n[i]
+		}
+	}
+	var properties []UsageCount
+	for p, n := range counts {
+		properties = append(properties, UsageCount{p, n})
+	}
+	sortUsageCounts(properties)
+	return properties, nil
+}
+
+// pageOf returns the items of list from offset, at most limit of them
+func pageOf(list []string, offset int, limit int) []string {
+	if offset >= len(list) {
+		return nil
+	}
+	list = list[offset:]
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// Classes counts the instances of each class with an aggregate query
+func (s *SparqlSource) Classes(ctx context.Context) ([]UsageCount, error) {
+	return s.usageCounts(ctx, `SELECT ?iri (COUNT(DISTINCT ?s) AS ?count) WHERE {
+  `+s.graphPattern("?s <"+rdfType+"> ?iri")+`
+} GROUP BY ?iri ORDER BY DESC(?count) ?iri`)
+}
+
+// Instances lists the instances of class, ordered by IRI
+func (s *SparqlSource) Instances(ctx context.Context, class string, offset int, limit int) ([]string, error) {
+	bindings, err := s.selectQuery(ctx, `SELECT DISTINCT ?s WHERE {
+  `+s.graphPattern("?s <"+rdfType+"> <"+class+">")+`
+} ORDER BY ?s LIMIT `+strconv.Itoa(limit)+` OFFSET `+strconv.Itoa(offset))
+	if err != nil {
+		return nil, err
+	}
+	var instances []string
+	for _, b := range bindings {
+		instances = append(instances, b["s"].Value)
+	}
+	return instances, nil
+}
+
+// Properties counts the triples of each predicate with an aggregate query
+func (s *SparqlSource) Properties(ctx context.Context) ([]UsageCount, error) {
+	return s.usageCounts(ctx, `SELECT ?iri (COUNT(*) AS ?count) WHERE {
+  `+s.graphPattern("?s ?iri ?o")+`
+} GROUP BY ?iri ORDER BY DESC(?count) ?iri`)
+}
+
+// usageCounts runs a query selecting ?iri and ?count
+func (s *SparqlSource) usageCounts(ctx context.Context, query string) ([]UsageCount, error) {
+	bindings, err := s.selectQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var counts []UsageCount
+	for _, b := range bindings {
+		n, err := strconv.ParseInt(b["count"].Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, UsageCount{b["iri"].Value, n})
+	}
+	return counts, nil
+}
+
+var browseTemplate = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{.Title}}</title>
+	<style>
+		body { font-family: arial, helvetica, sans-serif; margin: 2em; }
+		table { border-collapse: collapse; }
+		th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; }
+		td.count { text-align: right; }
+	</style>
+</head>
+<body>
+	<p><a href="/browse/classes">Classes</a> | <a href="/browse/properties">Properties</a> | <a href="/search">Search</a></p>
+	<h1>{{.Title}}</h1>
+{{- if .Counts}}
+	<table>
+		<tr><th>{{.Heading}}</th><th>Count</th></tr>
+{{- range .Counts}}
+		<tr><td><a href="{{.Link}}" title="{{.IRI}}">{{.Text}}</a></td><td class="count">{{.Count}}</td></tr>
+{{- end}}
+	</table>
+{{- end}}
+{{- if .Instances}}
+	<ol start="{{.Start}}">
+{{- range .Instances}}
+		<li><a href="{{.Link}}">{{.Text}}</a></li>
+{{- end}}
+	</ol>
+{{- end}}
+	<p>{{if .Previous}}<a href="{{.Previous}}">Previous</a>{{end}} {{if .Next}}<a href="{{.Next}}">Next</a>{{end}}</p>
+</body>
+</html>
+`))
+
+// browseItem is a class, property or instance shown on a browse page
+type browseItem struct {
+	IRI   string
+	Text  string
+	Link  string
+	Count int64
+}
+
+// BrowseHandler serves pages listing the classes (/browse/classes), the
+// instances of a class (/browse/class?iri=...&page=...) and the properties
+// (/browse/properties) of the dataset, as JSON or HTML. The lists of classes
+// and properties are computed when first requested, and then kept until
+// reset. Classes, properties and instances under URIHost which the client
+// may not read are left out (see mayReadIRI).
+type BrowseHandler struct {
+	URIHost      string
+	Browser      Browser
+	QueryTimeout time.Duration
+	Limiter      *concurrencyLimiter
+	Output       OutputOptions
+
+	mu         sync.Mutex
+	classes    []UsageCount
+	properties []UsageCount
+}
+
+func (h *BrowseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	switch r.URL.Path {
+	case "/browse/classes":
+		h.serveCounts(w, r, "Classes", "Class", &h.classes, h.Browser.Classes, func(iri string) string {
+			return "/browse/class?iri=" + url.QueryEscape(iri)
+		})
+	case "/browse/properties":
+		h.serveCounts(w, r, "Properties", "Property", &h.properties, h.Browser.Properties, func(iri string) string {
+			return iri
+		})
+	case "/browse/class":
+		h.serveInstances(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// reset forgets the classes and properties, so that they are computed again
+// when next requested, e.g. after the data source has been reloaded
+func (h *BrowseHandler) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.classes = nil
+	h.properties = nil
+}
+
+// serveCounts serves the classes or properties, computing them with compute
+// unless they are already in cached
+func (h *BrowseHandler) serveCounts(w http.ResponseWriter, r *http.Request, title string, heading string, cached *[]UsageCount,
+	compute func(ctx context.Context) ([]UsageCount, error), link func(iri string) string) {
+	h.mu.Lock()
+	counts := *cached
+	if counts == nil {
+		if !acquireBackend(w, r, h.Limiter) {
+			h.mu.Unlock()
+			return
+		}
+		ctx, cancel := withQueryTimeout(r.Context(), h.QueryTimeout)
+		var err error
+		counts, err = compute(ctx)
+		h.Limiter.release()
+		if err != nil {
+			writeBackendError(w, ctx, err)
+			cancel()
+			h.mu.Unlock()
+			return
+		}
+		cancel()
+		if counts == nil {
+			counts = []UsageCount{}
+		}
+		*cached = counts
+	}
+	h.mu.Unlock()
+
+	readable := []UsageCount{}
+	for _, c := range counts {
+		if mayReadIRI(r.Context(), h.URIHost, c.IRI) {
+			readable = append(readable, c)
+		}
+	}
+	if !prefersHTML(r) {
+		writeBrowseJSON(w, readable)
+		return
+	}
+	var items []browseItem
+	for _, c := range readable {
+		items = append(items, browseItem{c.IRI, h.displayName(c.IRI), link(c.IRI), c.Count})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	browseTemplate.Execute(w, map[string]interface{}{"Title": title, "Heading": heading, "Counts": items})
+}
+
+// serveInstances serves a page of instances of the class given by the iri
+// parameter
+func (h *BrowseHandler) serveInstances(w http.ResponseWriter, r *http.Request) {
+	class, err := rdf.NewIRI(r.URL.Query().Get("iri"))
+	if err != nil {
+		http.Error(w, "Error: Invalid class IRI ("+err.Error()+")", http.StatusBadRequest)
+		return
+	}
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			http.Error(w, "Error: Invalid page number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !acquireBackend(w, r, h.Limiter) {
+		return
+	}
+	defer h.Limiter.release()
+	ctx, cancel := withQueryTimeout(r.Context(), h.QueryTimeout)
+	defer cancel()
+	// Ask for one more instance than shown, to know if there is a next page
+	instances, err := h.Browser.Instances(ctx, class.String(), (page-1)*browsePageSize, browsePageSize+1)
+	if err != nil {
+		writeBackendError(w, ctx, err)
+		return
+	}
+	hasNext := len(instances) > browsePageSize
+	if hasNext {
+		instances = instances[:browsePageSize]
+	}
+	// Pages are counted with all instances, so that they are the same for
+	// all clients, but the instances the client may not read are left out
+	var readable []string
+	for _, instance := range instances {
+		if mayReadIRI(ctx, h.URIHost, instance) {
+			readable = append(readable, instance)
+		}
+	}
+	instances = readable
+
+	if !prefersHTML(r) {
+		if instances == nil {
+			instances = []string{}
+		}
+		writeBrowseJSON(w, instances)
+		return
+	}
+	pageLink := func(page int) string {
+		return "/browse/class?iri=" + url.QueryEscape(class.String()) + "&page=" + strconv.Itoa(page)
+	}
+	data := map[string]interface{}{
+		"Title": "Instances of " + h.displayName(class.String()),
+		"Start": (page-1)*browsePageSize + 1,
+	}
+	var items []browseItem
+	for _, instance := range instances {
+		items = append(items, browseItem{IRI: instance, Text: instance, Link: instance})
+	}
+	data["Instances"] = items
+	if page > 1 {
+		data["Previous"] = pageLink(page - 1)
+	}
+	if hasNext {
+		data["Next"] = pageLink(page + 1)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	browseTemplate.Execute(w, data)
+}
+
+// displayName returns iri abbreviated with a prefix, if possible
+func (h *BrowseHandler) displayName(iri string) string {
+	if compacted, ok := h.Output.Prefixes.compact(iri); ok {
+		return compacted
+	}
+	return iri
+}
+
+func writeBrowseJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
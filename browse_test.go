@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticBrowser is a Browser with a fixed number of instances per class
+type staticBrowser struct {
+	classes    []UsageCount
+	properties []UsageCount
+	calls      int
+}
+
+func (b *staticBrowser) Classes(ctx context.Context) ([]UsageCount, error) {
+	b.calls++
+	return b.classes, nil
+}
+
+func (b *staticBrowser) Instances(ctx context.Context, class string, offset int, limit int) ([]string, error) {
+	var instances []string
+	for _, c := range b.classes {
+		if c.IRI == class {
+			for i := 0; i < int(c.Count); i++ {
+				instances = append(instances, fmt.Sprintf("http://ex.org/Instance%03d", i))
+			}
+		}
+	}
+	return pageOf(instances, offset, limit), nil
+}
+
+func (b *staticBrowser) Properties(ctx context.Context) ([]UsageCount, error) {
+	b.calls++
+	return b.properties, nil
+}
+
+func TestBrowseHandler(t *testing.T) {
+	browser := &staticBrowser{
+		classes:    []UsageCount{{"http://ex.org/Compound", 150}, {"http://ex.org/Assay", 2}},
+		properties: []UsageCount{{"http://www.w3.org/2000/01/rdf-schema#label", 152}},
+	}
+	h := &BrowseHandler{Browser: browser, QueryTimeout: time.Second, Output: OutputOptions{Prefixes: PrefixMap{"ex": "http://ex.org/"}}}
+
+	tests := []struct {
+		path         string
+		accept       string
+		expectedCode int
+		expected     string
+	}{
+		{"/browse/classes", "", http.StatusOK, `[{"iri":"http://ex.org/Compound","count":150},{"iri":"http://ex.org/Assay","count":2}]`},
+		{"/browse/classes", "text/html", http.StatusOK, `<a href="/browse/class?iri=http%3A%2F%2Fex.org%2FCompound" title="http://ex.org/Compound">ex:Compound</a></td><td class="count">150</td>`},
+		{"/browse/properties", "", http.StatusOK, `"count":152`},
+		{"/browse/class?iri=http://ex.org/Assay", "", http.StatusOK, `["http://ex.org/Instance000","http://ex.org/Instance001"]`},
+		{"/browse/class?iri=http://ex.org/Compound", "text/html", http.StatusOK, `page=2">Next</a>`},
+		{"/browse/class?iri=http://ex.org/Compound&page=2", "text/html", http.StatusOK, `<ol start="101">`},
+		{"/browse/class?iri=http://ex.org/Compound&page=2", "", http.StatusOK, `"http://ex.org/Instance149"]`},
+		{"/browse/class?iri=http://ex.org/Compound&page=3", "", http.StatusOK, `[]`},
+		{"/browse/class?iri=", "", http.StatusBadRequest, "Invalid class IRI"},
+		{"/browse/class?iri=http://ex.org/Compound&page=0", "", http.StatusBadRequest, "Invalid page number"},
+		{"/browse/other", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("Accept", test.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != test.expectedCode {
+			t.Errorf("%s (Accept: %s): expected status %d, got %d", test.path, test.accept, test.expectedCode, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), test.expected) {
+			t.Errorf("%s (Accept: %s): expected %s in:\n%s", test.path, test.accept, test.expected, rec.Body.String())
+		}
+	}
+	if browser.calls != 2 {
+		t.Errorf("Expected the classes and properties to be computed once each, got %d calls", browser.calls)
+	}
+}
+
+func TestHdtSourceTypes(t *testing.T) {
+	calls, cleanup := fakeHdtSearch(t, "http://ex.org/b "+rdfType+" http://ex.org/Compound\n"+
+		"http://ex.org/a "+rdfType+" http://ex.org/Compound\n"+
+		"http://ex.org/c "+rdfType+" http://ex.org/Assay\n", "")
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "urisolve-hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data.hdt")
+	writeTestHdtFile(t, path, 0)
+	s := &HdtSource{FilePath: path}
+	if _, err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	classes, err := s.Classes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(classes, []UsageCount{{"http://ex.org/Compound", 2}, {"http://ex.org/Assay", 1}}) {
+		t.Errorf("Unexpected classes: %v", classes)
+	}
+	instances, _ := s.Instances(context.Background(), "http://ex.org/Compound", 0, 10)
+	if !reflect.DeepEqual(instances, []string{"http://ex.org/a", "http://ex.org/b"}) {
+		t.Errorf("Unexpected instances: %v", instances)
+	}
+	if stats, _ := s.DatasetStats(context.Background(), "http://ex.org/", allPublic); stats.Classes != 2 {
+		t.Errorf("Expected 2 classes in the statistics, got %d", stats.Classes)
+	}
+	if run, _ := ioutil.ReadFile(calls); strings.Count(string(run), rdfType) != 1 {
+		t.Errorf("Expected the rdf:type triples to be read once, got %q", string(run))
+	}
+
+	// A new version of the file is read again
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	os.Chtimes(path+hdtIndexSuffix, later, later)
+	if _, err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	s.Classes(context.Background())
+	if run, _ := ioutil.ReadFile(calls); strings.Count(string(run), rdfType) != 2 {
+		t.Errorf("Expected the rdf:type triples to be read again after a reload, got %q", string(run))
+	}
+}
+
+func TestHdtSourceProperties(t *testing.T) {
+	var counts []string
+	for i := 1; i <= 9; i++ {
+		counts = append(counts, ">> "+strconv.Itoa(i)+" results in 10 us")
+	}
+	calls, cleanup := fakeHdtSearch(t, "", strings.Join(counts, "\n")+"\n>> ")
+	defer cleanup()
+
+	s := &HdtSource{FilePath: "example_data.hdt"}
+	properties, err := s.Properties(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(properties) != 9 || properties[0].Count != 9 || properties[8].Count != 1 {
+		t.Errorf("Expected the counts of the 9 properties, got %v", properties)
+	}
+	run, _ := ioutil.ReadFile(calls)
+	if !strings.HasPrefix(string(run), "-m example_data.hdt\n? ") || strings.Count(string(run), "example_data.hdt") != 1 {
+		t.Errorf("Expected one hdtSearch process counting all properties, got %q", string(run))
+	}
+}
+
+func TestBrowseHandlerAccessRules(t *testing.T) {
+	browser := &staticBrowser{
+		classes:    []UsageCount{{"http://ex.org/Compound", 3}, {"http://ex.org/Instance001/Class", 1}},
+		properties: []UsageCount{{"http://www.w3.org/2000/01/rdf-schema#label", 4}},
+	}
+	h := &BrowseHandler{URIHost: "http://ex.org", Browser: browser, QueryTimeout: time.Second}
+	auth := &Authenticator{Rules: []AccessRule{{"/Instance001", []string{"bob"}}}}
+
+	tests := map[string]string{
+		"/browse/classes":                          `[{"iri":"http://ex.org/Compound","count":3}]`,
+		"/browse/properties":                       `[{"iri":"http://www.w3.org/2000/01/rdf-schema#label","count":4}]`,
+		"/browse/class?iri=http://ex.org/Compound": `["http://ex.org/Instance000","http://ex.org/Instance002"]`,
+	}
+	for path, expected := range tests {
+		rec := httptest.NewRecorder()
+		withAuth(auth, h).ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if strings.TrimSpace(rec.Body.String()) != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// setFlagsFromEnv sets any flag in fs that was not given on the command line
+// from the environment variable URISOLVE_OPT_<NAME>, where NAME is the flag
+// name in upper case with dashes replaced by underscores. For example,
+// -tls-cert can be configured with URISOLVE_OPT_TLS_CERT. Flags given on the
+// command line take precedence.
+//
+// The OPT_ part keeps the variables apart from those Kubernetes sets for a
+// service named urisolve, such as URISOLVE_PORT=tcp://10.0.0.1:80.
+func setFlagsFromEnv(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		value, ok := os.LookupEnv(envVarForFlag(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("Invalid value %q for %s: %s", value, envVarForFlag(f.Name), setErr.Error())
+		}
+	})
+	return err
+}
+
+// envVarPrefix is the prefix of the environment variables configuring flags
+const envVarPrefix = "URISOLVE_OPT_"
+
+// envVarForFlag returns the name of the environment variable which can be
+// used to configure the flag with the given name
+func envVarForFlag(name string) string {
+	return envVarPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// splitList splits a comma separated list, trimming whitespace around, and
+// dropping empty, items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readConfigLines calls parse with the whitespace separated fields of each
+// line in the file at path, skipping empty lines and comments
+func readConfigLines(path string, parse func(fields []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(strings.Fields(line)); err != nil {
+			return fmt.Errorf("%s:%d: %s", path, lineNo, err.Error())
+		}
+	}
+	return scanner.Err()
+}
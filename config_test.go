@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	fs := flag.NewFlagSet("urisolve", flag.ContinueOnError)
+	tlsCert := fs.String("tls-cert", "", "")
+	port := fs.String("port", "8080", "")
+	host := fs.String("host", "localhost", "")
+
+	os.Setenv("URISOLVE_OPT_TLS_CERT", "/etc/tls/tls.crt")
+	os.Setenv("URISOLVE_OPT_PORT", "8443")
+	// As set by Kubernetes for a service named urisolve
+	os.Setenv("URISOLVE_SERVICE_HOST", "10.0.0.1")
+	os.Setenv("URISOLVE_HOST", "tcp://10.0.0.1:80")
+	defer os.Unsetenv("URISOLVE_OPT_TLS_CERT")
+	defer os.Unsetenv("URISOLVE_OPT_PORT")
+	defer os.Unsetenv("URISOLVE_SERVICE_HOST")
+	defer os.Unsetenv("URISOLVE_HOST")
+
+	if err := fs.Parse([]string{"-port", "9443"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setFlagsFromEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if *tlsCert != "/etc/tls/tls.crt" {
+		t.Errorf("Expected -tls-cert to be set from the environment, got %q", *tlsCert)
+	}
+	if *port != "9443" {
+		t.Errorf("Expected -port from the command line to take precedence, got %q", *port)
+	}
+	if *host != "localhost" {
+		t.Errorf("Expected -host to keep its default value, and not be set by Kubernetes service variables, got %q", *host)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// rdfFormats maps the names (and file extensions) of RDF formats which can be
+// read to the formats of the rdf package
+var rdfFormats = map[string]rdf.Format{
+	"ntriples": rdf.NTriples,
+	"nt":       rdf.NTriples,
+	"nquads":   rdf.NQuads,
+	"nq":       rdf.NQuads,
+	"turtle":   rdf.Turtle,
+	"ttl":      rdf.Turtle,
+	"rdfxml":   rdf.RDFXML,
+	"rdf":      rdf.RDFXML,
+	"owl":      rdf.RDFXML,
+	"xml":      rdf.RDFXML,
+}
+
+// runConvert runs the convert subcommand, which converts RDF files to an HDT
+// file, with its index
+func runConvert(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	output := flags.String("o", "", "Path of the .hdt file to write (the index is written next to it, with the suffix "+hdtIndexSuffix+")")
+	format := flags.String("format", "", "Format of the input files. Can be one of: ntriples, nquads, turtle, rdfxml. If empty, it is guessed from the file extensions (which may end with .gz)")
+	baseIRI := flags.String("base", "", "Base IRI, to resolve relative IRIs against, and to describe the dataset with in the HDT header. If empty, file://<name of the output file> is used")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: urisolve convert -o output.hdt [options] input-file ...")
+		fmt.Fprintln(os.Stderr, "Converts N-Triples, N-Quads (ignoring the graphs), Turtle or RDF/XML files to an HDT file.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *output == "" || flags.NArg() == 0 {
+		log.Fatal("Both -o and at least one input file are required. Use -h to view options")
+	}
+	if *format != "" {
+		if _, ok := rdfFormats[*format]; !ok {
+			log.Fatal("Unknown format " + *format + ". Use -h to view options")
+		}
+	}
+	if *baseIRI == "" {
+		*baseIRI = "file://" + filepath.Base(*output)
+	}
+
+	start := time.Now()
+	numTriples, err := convertToHdt(flags.Args(), *format, *baseIRI, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %d triples to %s in %s\n", numTriples, *output, time.Since(start))
+}
+
+// convertToHdt reads the triples of the input files and writes them to an
+// HDT file at output, and to its index. Both are first written to temporary
+// files, which are moved in place when complete (the index first), so that a
+// server reloading the file never sees a partial one.
+func convertToHdt(inputs []string, format string, baseIRI string, output string) (int, error) {
+	builder := newHdtDatasetBuilder()
+	originalSize := int64(0)
+	for _, input := range inputs {
+		size, err := readRDFFile(input, format, baseIRI, func(q rdf.Quad) error {
+			return builder.add(q.Triple)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("Could not read %s (%s)", input, err.Error())
+		}
+		originalSize += size
+	}
+	dataset := builder.dataset()
+
+	err := writeFileAtomically(output+hdtIndexSuffix, func(w io.Writer) error {
+		return writeHdtIndex(w, dataset)
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = writeFileAtomically(output, func(w io.Writer) error {
+		return writeHdt(w, dataset, baseIRI, originalSize, time.Now())
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(dataset.triples), nil
+}
+
+// readRDFFile calls add with each quad (or triple, in the default graph) in
+// the file at path, returning the (uncompressed) size of the file. If format
+// is empty, it is guessed from the file extension.
+func readRDFFile(path string, format string, baseIRI string, add func(q rdf.Quad) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	name := path
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+		name = strings.TrimSuffix(name, ".gz")
+	}
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(name), ".")
+	}
+	rdfFormat, ok := rdfFormats[format]
+	if !ok {
+		return 0, fmt.Errorf("Unknown format, use -format to give it")
+	}
+	counter := &countingReader{Reader: r}
+
+	if rdfFormat == rdf.NQuads {
+		dec := rdf.NewQuadDecoder(counter, rdfFormat)
+		// Leave the graph of quads in the default graph nil, like sources do
+		dec.DefaultGraph = nil
+		for {
+			q, err := dec.Decode()
+			if err == io.EOF {
+				return counter.n, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			if err := add(q); err != nil {
+				return 0, err
+			}
+		}
+	}
+	dec := rdf.NewTripleDecoder(counter, rdfFormat)
+	if rdfFormat != rdf.NTriples {
+		base, err := rdf.NewIRI(baseIRI)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid base IRI (%s)", err.Error())
+		}
+		dec.SetOption(rdf.Base, base)
+	}
+	for {
+		t, err := dec.Decode()
+		if err == io.EOF {
+			return counter.n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := add(rdf.Quad{Triple: t}); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// writeFileAtomically writes a file at path with write, through a temporary
+// file in the same directory, which is renamed to path when complete
+func writeFileAtomically(path string, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("Could not write %s (%s)", path, err.Error())
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Could not write %s (%s)", path, err.Error())
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
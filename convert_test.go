@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// afterHdtHeader returns data from the dictionary on, skipping the parts of
+// an HDT file which depend on when and how it was written
+func afterHdtHeader(t *testing.T, data []byte) []byte {
+	i := bytes.Index(data, []byte("$HDT\x03"))
+	if i < 0 {
+		t.Fatal("No dictionary found")
+	}
+	return data[i:]
+}
+
+func TestConvertToHdt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve-convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "example.hdt")
+	n, err := convertToHdt([]string{"example_data.nt"}, "", "http://rdf.pharmb.io/cplogd", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 135 {
+		t.Errorf("Expected 135 triples, got %d", n)
+	}
+
+	// The dictionary, the triples and the index should be the same as in
+	// the file created by rdf2hdt from the same data
+	written, _ := ioutil.ReadFile(output)
+	expected, _ := ioutil.ReadFile("example_data.hdt")
+	if !bytes.Equal(afterHdtHeader(t, written), afterHdtHeader(t, expected)) {
+		t.Error("Expected the dictionary and triples to be the same as in example_data.hdt")
+	}
+	writtenIndex, _ := ioutil.ReadFile(output + hdtIndexSuffix)
+	expectedIndex, _ := ioutil.ReadFile("example_data.hdt" + hdtIndexSuffix)
+	if !bytes.Equal(writtenIndex, expectedIndex) {
+		t.Error("Expected the index to be the same as example_data.hdt" + hdtIndexSuffix)
+	}
+
+	if err := validateHdtFile(output, true); err != nil {
+		t.Error(err)
+	}
+	header, err := readHdtHeader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, triple := range header {
+		if triple.Subj.String() == "http://rdf.pharmb.io/cplogd" && triple.Pred.String() == voidNamespace+"triples" && triple.Obj.String() == "135" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the header to give the number of triples, got %v", header)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, ".*")); len(files) > 0 {
+		t.Errorf("Expected no temporary files to be left, got %v", files)
+	}
+}
+
+func TestConvertTurtleToHdt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve-convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "data.ttl")
+	ioutil.WriteFile(input, []byte(`@prefix ex: <http://example.org/> .
+ex:a ex:name "A"@en, "A" ; ex:knows ex:b, _:c .
+ex:b ex:knows ex:a ; ex:age 42 .
+ex:a ex:name "A" .
+`), 0644)
+	output := filepath.Join(dir, "data.hdt")
+	n, err := convertToHdt([]string{input}, "", "http://example.org/", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 {
+		t.Errorf("Expected 6 distinct triples, got %d", n)
+	}
+	terms := make(map[hdtSection][]string)
+	counts, err := readHdtDictionary(output, func(section hdtSection, term string) {
+		terms[section] = append(terms[section], term)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[hdtShared] != 2 || counts[hdtPredicates] != 3 {
+		t.Errorf("Expected 2 shared terms and 3 predicates, got %v", counts)
+	}
+	expected := []string{`"42"^^<http://www.w3.org/2001/XMLSchema#integer>`, `"A"`, `"A"@en`, "_:c"}
+	if len(terms[hdtObjects]) != len(expected) {
+		t.Fatalf("Expected the objects %v, got %v", expected, terms[hdtObjects])
+	}
+	for i := range expected {
+		if terms[hdtObjects][i] != expected[i] {
+			t.Errorf("Expected the objects %v, got %v", expected, terms[hdtObjects])
+		}
+	}
+}
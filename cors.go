@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures Cross-Origin Resource Sharing, which allows browser
+// based clients served from other origins to dereference URIs.
+type CORSOptions struct {
+	// AllowedOrigins are the origins (e.g. https://app.example.org) allowed
+	// to make requests. "*" allows any origin, and a leading "*." in the host
+	// name allows any subdomain (e.g. https://*.example.org).
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in cross-origin requests
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in cross-origin
+	// requests. "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers which clients are allowed to read
+	ExposedHeaders []string
+	// AllowCredentials allows requests with cookies or HTTP authentication,
+	// from the origins listed in AllowedOrigins (never through "*")
+	AllowCredentials bool
+	// MaxAge is how long clients may cache the result of a preflight request
+	MaxAge time.Duration
+}
+
+// withCORS adds CORS headers to the responses of handler, for requests from
+// allowed origins, and answers preflight requests itself
+func withCORS(opts CORSOptions, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !opts.originAllowed(origin) {
+			if preflight {
+				// Answer without any CORS headers, so that the browser
+				// refuses to send the actual request
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if preflight {
+			method := r.Header.Get("Access-Control-Request-Method")
+			requestHeaders := splitList(r.Header.Get("Access-Control-Request-Headers"))
+			if !containsFold(opts.AllowedMethods, method) || !opts.headersAllowed(requestHeaders) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			opts.setOriginHeaders(w, origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(opts.AllowedMethods, ", "))
+			if len(requestHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+			}
+			if opts.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		opts.setOriginHeaders(w, origin)
+		if len(opts.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// setOriginHeaders allows origin to read the response. Origins only allowed
+// through "*" get "*", so that credentials are never allowed for any website.
+func (opts CORSOptions) setOriginHeaders(w http.ResponseWriter, origin string) {
+	if !opts.originListed(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if opts.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (opts CORSOptions) originAllowed(origin string) bool {
+	return containsFold(opts.AllowedOrigins, "*") || opts.originListed(origin)
+}
+
+// originListed returns true if origin is one of the AllowedOrigins, or a
+// subdomain of one of their patterns, not counting "*"
+func (opts CORSOptions) originListed(origin string) bool {
+	for _, allowed := range opts.AllowedOrigins {
+		if allowed != "*" && strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// Allow subdomains of a pattern like https://*.example.org
+		if i := strings.Index(allowed, "://*."); i >= 0 {
+			scheme, domain := allowed[:i+3], allowed[i+4:]
+			if len(origin) > len(scheme)+len(domain) && strings.HasPrefix(strings.ToLower(origin), strings.ToLower(scheme)) && strings.HasSuffix(strings.ToLower(origin), strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (opts CORSOptions) headersAllowed(headers []string) bool {
+	if containsFold(opts.AllowedHeaders, "*") {
+		return true
+	}
+	for _, header := range headers {
+		if !containsFold(opts.AllowedHeaders, header) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold returns true if list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithCORS(t *testing.T) {
+	opts := CORSOptions{
+		AllowedOrigins: []string{"https://app.example.org", "https://*.pharmb.io"},
+		AllowedMethods: []string{"GET", "HEAD", "OPTIONS"},
+		AllowedHeaders: []string{"Accept"},
+		ExposedHeaders: []string{"Link", "ETag"},
+		MaxAge:         time.Minute,
+	}
+	handler := withCORS(opts, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<http://ex.org/s> <http://ex.org/p> <http://ex.org/o> .\n"))
+	}))
+
+	tests := []struct {
+		method         string
+		origin         string
+		requestMethod  string
+		requestHeaders string
+		expectedCode   int
+		expectedOrigin string
+		expectedExpose string
+	}{
+		{"GET", "", "", "", http.StatusOK, "", ""},
+		{"GET", "https://app.example.org", "", "", http.StatusOK, "https://app.example.org", "Link, ETag"},
+		{"GET", "https://lab.pharmb.io", "", "", http.StatusOK, "https://lab.pharmb.io", "Link, ETag"},
+		{"GET", "https://evil.org", "", "", http.StatusOK, "", ""},
+		{"OPTIONS", "https://app.example.org", "GET", "accept", http.StatusNoContent, "https://app.example.org", ""},
+		{"OPTIONS", "https://app.example.org", "DELETE", "", http.StatusNoContent, "", ""},
+		{"OPTIONS", "https://app.example.org", "GET", "X-Custom", http.StatusNoContent, "", ""},
+		{"OPTIONS", "https://evil.org", "GET", "", http.StatusNoContent, "", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/cplogd/Compound1", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if test.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", test.requestMethod)
+		}
+		if test.requestHeaders != "" {
+			req.Header.Set("Access-Control-Request-Headers", test.requestHeaders)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.expectedCode {
+			t.Errorf("%s from %q: expected status %d, got %d", test.method, test.origin, test.expectedCode, rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != test.expectedOrigin {
+			t.Errorf("%s from %q: expected allowed origin %q, got %q", test.method, test.origin, test.expectedOrigin, got)
+		}
+		if got := rec.Header().Get("Access-Control-Expose-Headers"); got != test.expectedExpose {
+			t.Errorf("%s from %q: expected exposed headers %q, got %q", test.method, test.origin, test.expectedExpose, got)
+		}
+		if test.expectedCode == http.StatusNoContent && test.expectedOrigin != "" {
+			if got := rec.Header().Get("Access-Control-Max-Age"); got != "60" {
+				t.Errorf("Expected preflight max age 60, got %q", got)
+			}
+		}
+	}
+}
+
+func TestWithCORSCredentials(t *testing.T) {
+	opts := CORSOptions{
+		AllowedOrigins:   []string{"*", "https://app.example.org"},
+		AllowedMethods:   []string{"GET"},
+		AllowCredentials: true,
+	}
+	handler := withCORS(opts, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := map[string][2]string{
+		"https://app.example.org": {"https://app.example.org", "true"},
+		"https://evil.org":        {"*", ""},
+	}
+	for origin, expected := range tests {
+		req := httptest.NewRequest("GET", "/cplogd/Compound1", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := [2]string{rec.Header().Get("Access-Control-Allow-Origin"), rec.Header().Get("Access-Control-Allow-Credentials")}; got != expected {
+			t.Errorf("%s: expected the allowed origin and credentials %q, got %q", origin, expected, got)
+		}
+	}
+}
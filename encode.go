@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// outputFormat is an RDF serialization which resources can be written in
+type outputFormat struct {
+	// MediaTypes accepted for the format, the first of which is preferred
+	MediaTypes []string
+	Write      func(w io.Writer, quads []rdf.Quad, opts *writeOptions) error
+}
+
+// OutputOptions configure how resources are written, for the formats which
+// support them
+type OutputOptions struct {
+	// JSONLDContext is used to compact JSON-LD output, if not nil
+	JSONLDContext *jsonldContext
+	// Prefixes are used to abbreviate IRIs in Turtle, TriG and HTML
+	Prefixes PrefixMap
+	// FilterLanguages drops literals in languages not accepted by the
+	// Accept-Language header of the request
+	FilterLanguages bool
+}
+
+// writeOptions are the options for writing a single response
+type writeOptions struct {
+	OutputOptions
+	// Resource is the URI of the resource being described
+	Resource string
+	// Params are the parameters of the accepted media range, e.g. profile
+	Params map[string]string
+	// Labels looks up display labels for IRIs, if not nil. It is only called
+	// by formats which show labels, to avoid the extra queries otherwise.
+	Labels func(iris []string) map[string]string
+}
+
+// labels returns display labels for iris, or nil if there is no lookup
+func (opts *writeOptions) labels(iris []string) map[string]string {
+	if opts.Labels == nil {
+		return nil
+	}
+	return opts.Labels(iris)
+}
+
+// outputFormats are the formats supported for content negotiation, in order
+// of preference when the client accepts several of them equally well
+var outputFormats = []outputFormat{
+	{[]string{"application/n-triples", "text/plain"}, writeNTriples},
+	{[]string{"text/turtle", "application/x-turtle"}, writeTurtle},
+	{[]string{"application/rdf+xml"}, writeRDFXML},
+	{[]string{"application/n-quads"}, writeNQuads},
+	{[]string{"application/trig"}, writeTriG},
+	{[]string{"application/ld+json"}, writeJSONLD},
+	{[]string{"text/html", "application/xhtml+xml"}, writeHTML},
+}
+
+// supportedMediaTypes lists the media types of all output formats
+func supportedMediaTypes() []string {
+	var mediaTypes []string
+	for _, f := range outputFormats {
+		mediaTypes = append(mediaTypes, f.MediaTypes[0])
+	}
+	return mediaTypes
+}
+
+// acceptRange is a media range from an Accept header, with its quality value
+type acceptRange struct {
+	mediaType string
+	params    map[string]string
+	q         float64
+}
+
+// parseAccept parses the media ranges in an Accept header
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		ar := acceptRange{mediaType: mediaType, params: make(map[string]string), q: 1}
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(kv[0]))
+			value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			if key == "q" {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					ar.q = q
+				}
+				continue
+			}
+			ar.params[key] = value
+		}
+		ranges = append(ranges, ar)
+	}
+	return ranges
+}
+
+// matchQuality returns the quality value with which the media ranges accept
+// mediaType, using the most specific matching range, or -1 if not matched
+func matchQuality(ranges []acceptRange, mediaType string) (float64, *acceptRange) {
+	best, bestSpecificity := -1.0, -1
+	var bestRange *acceptRange
+	for i, ar := range ranges {
+		specificity := -1
+		switch {
+		case ar.mediaType == mediaType:
+			specificity = 2
+		case ar.mediaType == "*/*":
+			specificity = 0
+		case strings.HasSuffix(ar.mediaType, "/*") && strings.HasPrefix(mediaType, ar.mediaType[:len(ar.mediaType)-1]):
+			specificity = 1
+		}
+		if specificity > bestSpecificity {
+			best, bestSpecificity, bestRange = ar.q, specificity, &ranges[i]
+		}
+	}
+	return best, bestRange
+}
+
+// negotiateFormat returns the output format best matching the Accept header,
+// together with the media type to use for the response and the parameters
+// of the matching media range, or nil if none of them are acceptable
+func negotiateFormat(accept string) (*outputFormat, string, map[string]string) {
+	if strings.TrimSpace(accept) == "" {
+		return &outputFormats[0], outputFormats[0].MediaTypes[0], nil
+	}
+	ranges := parseAccept(accept)
+
+	var best *outputFormat
+	var bestParams map[string]string
+	bestMediaType, bestQ := "", 0.0
+	for i, f := range outputFormats {
+		for _, mediaType := range f.MediaTypes {
+			q, ar := matchQuality(ranges, mediaType)
+			if q > bestQ {
+				best, bestMediaType, bestParams, bestQ = &outputFormats[i], mediaType, ar.params, q
+			}
+		}
+	}
+	return best, bestMediaType, bestParams
+}
+
+func writeNTriples(w io.Writer, quads []rdf.Quad, opts *writeOptions) error {
+	enc := rdf.NewTripleEncoder(w, rdf.NTriples)
+	for _, triple := range quadsToTriples(quads) {
+		if err := enc.Encode(triple); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// writeTurtle writes the triples in quads as Turtle, abbreviating IRIs with
+// the configured prefixes
+func writeTurtle(w io.Writer, quads []rdf.Quad, opts *writeOptions) error {
+	triples := quadsToTriples(quads)
+	sortTriples(triples)
+
+	bw := bufio.NewWriter(w)
+	writePrefixes(bw, opts.Prefixes, triples)
+	writeTurtleTriples(bw, triples, "", opts.Prefixes)
+	return bw.Flush()
+}
+
+func writeNQuads(w io.Writer, quads []rdf.Quad, opts *writeOptions) error {
+	enc := rdf.NewQuadEncoder(w, rdf.NQuads)
+	if err := enc.EncodeAll(quads); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// writeTriG writes quads in TriG, with the triples of the default graph
+// first, followed by one block for each named graph
+func writeTriG(w io.Writer, quads []rdf.Quad, opts *writeOptions) error {
+	bw := bufio.NewWriter(w)
+	writePrefixes(bw, opts.Prefixes, quadsToTriples(quads))
+	for _, graph := range groupByGraph(quads) {
+		indent := ""
+		if graph.name != nil {
+			fmt.Fprintf(bw, "%s {\n", turtleTerm(graph.name, opts.Prefixes))
+			indent = "\t"
+		}
+		sortTriples(graph.triples)
+		writeTurtleTriples(bw, graph.triples, indent, opts.Prefixes)
+		if graph.name != nil {
+			bw.WriteString("}\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// writePrefixes writes @prefix directives for the prefixes used by triples,
+// followed by an empty line
+func writePrefixes(bw *bufio.Writer, prefixes PrefixMap, triples []rdf.Triple) {
+	used := prefixes.usedBy(triples)
+	for _, prefix := range used {
+		fmt.Fprintf(bw, "@prefix %s: <%s> .\n", prefix, prefixes[prefix])
+	}
+	if len(used) > 0 {
+		bw.WriteString("\n")
+	}
+}
+
+// writeTurtleTriples writes triples, which must be sorted by subject, as
+// Turtle statements, grouping the predicates of each subject
+func writeTurtleTriples(bw *bufio.Writer, triples []rdf.Triple, indent string, prefixes PrefixMap) {
+	for i, t := range triples {
+		pred := turtleTerm(t.Pred, prefixes)
+		if t.Pred.String() == rdfType {
+			pred = "a"
+		}
+		if i > 0 && rdf.TermsEqual(t.Subj, triples[i-1].Subj) {
+			fmt.Fprintf(bw, " ;\n%s\t%s %s", indent, pred, turtleTerm(t.Obj, prefixes))
+			continue
+		}
+		if i > 0 {
+			bw.WriteString(" .\n")
+		}
+		fmt.Fprintf(bw, "%s%s %s %s", indent, turtleTerm(t.Subj, prefixes), pred, turtleTerm(t.Obj, prefixes))
+	}
+	if len(triples) > 0 {
+		bw.WriteString(" .\n")
+	}
+}
+
+// turtleTerm serializes term for Turtle or TriG, abbreviating IRIs (and
+// datatype IRIs) with prefixes where possible
+func turtleTerm(term rdf.Term, prefixes PrefixMap) string {
+	switch t := term.(type) {
+	case rdf.IRI:
+		if compacted, ok := prefixes.compact(t.String()); ok {
+			return compacted
+		}
+	case rdf.Literal:
+		serialized := t.Serialize(rdf.Turtle)
+		datatype := "<" + t.DataType.String() + ">"
+		if compacted, ok := prefixes.compact(t.DataType.String()); ok && strings.HasSuffix(serialized, "^^"+datatype) {
+			return strings.TrimSuffix(serialized, datatype) + compacted
+		}
+		return serialized
+	}
+	return term.Serialize(rdf.Turtle)
+}
+
+// graphTriples are the triples of a graph; the default graph if name is nil
+type graphTriples struct {
+	name    rdf.Context
+	triples []rdf.Triple
+}
+
+// groupByGraph groups the distinct triples of quads by graph, with the
+// default graph first, followed by the named graphs in order of appearance
+func groupByGraph(quads []rdf.Quad) []*graphTriples {
+	graphs := []*graphTriples{{}}
+	byName := make(map[string]*graphTriples)
+	seen := make(map[string]bool)
+	for _, q := range quads {
+		key := quadKey(q)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if q.Ctx == nil {
+			graphs[0].triples = append(graphs[0].triples, q.Triple)
+			continue
+		}
+		name := q.Ctx.Serialize(rdf.NQuads)
+		g, ok := byName[name]
+		if !ok {
+			g = &graphTriples{name: q.Ctx}
+			byName[name] = g
+			graphs = append(graphs, g)
+		}
+		g.triples = append(g.triples, q.Triple)
+	}
+	return graphs
+}
+
+// sortTriples sorts triples by subject, so that encoders can group them
+func sortTriples(triples []rdf.Triple) {
+	sort.SliceStable(triples, func(i, j int) bool {
+		return triples[i].Subj.Serialize(rdf.NTriples) < triples[j].Subj.Serialize(rdf.NTriples)
+	})
+}
+
+const (
+	rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xsdString    = "http://www.w3.org/2001/XMLSchema#string"
+	rdfLangStr   = "http://www.w3.org/1999/02/22-rdf-syntax-ns#langString"
+)
+
+// writeRDFXML writes the triples in quads as RDF/XML, with one
+// rdf:Description element per subject
+func writeRDFXML(w io.Writer, quads []rdf.Quad, opts *writeOptions) error {
+	triples := quadsToTriples(quads)
+	sortTriples(triples)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<rdf:RDF xmlns:rdf="` + rdfNamespace + `">` + "\n")
+	for i, t := range triples {
+		if i == 0 || !rdf.TermsEqual(t.Subj, triples[i-1].Subj) {
+			if i > 0 {
+				bw.WriteString("  </rdf:Description>\n")
+			}
+			switch subj := t.Subj.(type) {
+			case rdf.Blank:
+				fmt.Fprintf(bw, "  <rdf:Description rdf:nodeID=\"%s\">\n", xmlEscape(subj.String()))
+			default:
+				fmt.Fprintf(bw, "  <rdf:Description rdf:about=\"%s\">\n", xmlEscape(subj.String()))
+			}
+		}
+
+		namespace, localName := splitQName(t.Pred.String())
+		if localName == "" {
+			return fmt.Errorf("Predicate can not be written as RDF/XML: %s", t.Pred.String())
+		}
+		element := fmt.Sprintf(`ns:%s xmlns:ns="%s"`, localName, xmlEscape(namespace))
+		switch obj := t.Obj.(type) {
+		case rdf.IRI:
+			fmt.Fprintf(bw, "    <%s rdf:resource=\"%s\"/>\n", element, xmlEscape(obj.String()))
+		case rdf.Blank:
+			fmt.Fprintf(bw, "    <%s rdf:nodeID=\"%s\"/>\n", element, xmlEscape(obj.String()))
+		case rdf.Literal:
+			if obj.Lang() != "" {
+				element += fmt.Sprintf(` xml:lang="%s"`, xmlEscape(obj.Lang()))
+			} else if dt := obj.DataType.String(); dt != xsdString && dt != rdfLangStr {
+				element += fmt.Sprintf(` rdf:datatype="%s"`, xmlEscape(dt))
+			}
+			fmt.Fprintf(bw, "    <%s>%s</ns:%s>\n", element, xmlEscape(obj.String()), localName)
+		}
+	}
+	if len(triples) > 0 {
+		bw.WriteString("  </rdf:Description>\n")
+	}
+	bw.WriteString("</rdf:RDF>\n")
+	return bw.Flush()
+}
+
+// splitQName splits iri into a namespace and the longest possible suffix
+// which is a valid XML local name, which is empty if there is none
+func splitQName(iri string) (namespace string, localName string) {
+	i := len(iri)
+	for i > 0 && isNameChar(iri[i-1]) {
+		i--
+	}
+	for i < len(iri) && !isNameStartChar(iri[i]) {
+		i++
+	}
+	return iri[:i], iri[i:]
+}
+
+func isNameStartChar(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStartChar(c) || c == '-' || c == '.' || (c >= '0' && c <= '9')
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
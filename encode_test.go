@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// staticSource is a Source returning the same quads for any URI in it
+type staticSource []rdf.Quad
+
+func (s staticSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	var quads []rdf.Quad
+	for _, q := range s {
+		if q.Subj.String() == uri || q.Obj.String() == uri {
+			quads = append(quads, q)
+		}
+	}
+	return quads, nil
+}
+
+func mustQuad(s string, p string, o rdf.Object, g string) rdf.Quad {
+	subj, _ := rdf.NewIRI(s)
+	pred, _ := rdf.NewIRI(p)
+	q := rdf.Quad{Triple: rdf.Triple{Subj: subj, Pred: pred, Obj: o}}
+	if g != "" {
+		q.Ctx, _ = rdf.NewIRI(g)
+	}
+	return q
+}
+
+func mustIRI(s string) rdf.IRI {
+	iri, _ := rdf.NewIRI(s)
+	return iri
+}
+
+var testQuads = staticSource{
+	mustQuad("http://rdf.pharmb.io/cplogd/Compound1", "http://www.w3.org/1999/02/22-rdf-syntax-ns#type", mustIRI("http://rdf.pharmb.io/cplogd/Compound"), ""),
+	mustQuad("http://rdf.pharmb.io/cplogd/Compound1", "http://rdf.pharmb.io/cplogd/logD", rdf.NewTypedLiteral("2.5", mustIRI("http://www.w3.org/2001/XMLSchema#decimal")), "http://rdf.pharmb.io/graph/measurements"),
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	accepts := map[string]string{
+		"":            "application/n-triples",
+		"*/*":         "application/n-triples",
+		"text/turtle": "text/turtle",
+		"text/*":      "text/plain",
+		"application/rdf+xml;q=0.9, text/turtle;q=0.5": "application/rdf+xml",
+		"application/n-quads":                          "application/n-quads",
+		"application/trig, */*;q=0.1":                  "application/trig",
+		"text/plain":                                   "text/plain",
+		"image/png":                                    "",
+		"text/turtle;q=0":                              "",
+	}
+	for accept, expected := range accepts {
+		_, got, _ := negotiateFormat(accept)
+		if got != expected {
+			t.Errorf("Expected %q for Accept: %s, got %q", expected, accept, got)
+		}
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	quads := []rdf.Quad(testQuads)
+	expected := map[string]string{
+		"application/n-quads": "<http://rdf.pharmb.io/cplogd/Compound1> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://rdf.pharmb.io/cplogd/Compound>  .\n" +
+			"<http://rdf.pharmb.io/cplogd/Compound1> <http://rdf.pharmb.io/cplogd/logD> \"2.5\"^^<http://www.w3.org/2001/XMLSchema#decimal> <http://rdf.pharmb.io/graph/measurements> .\n",
+		"application/trig": "<http://rdf.pharmb.io/cplogd/Compound1> a <http://rdf.pharmb.io/cplogd/Compound> .\n" +
+			"<http://rdf.pharmb.io/graph/measurements> {\n" +
+			"\t<http://rdf.pharmb.io/cplogd/Compound1> <http://rdf.pharmb.io/cplogd/logD> 2.5 .\n" +
+			"}\n",
+		"application/rdf+xml": `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+			`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n" +
+			`  <rdf:Description rdf:about="http://rdf.pharmb.io/cplogd/Compound1">` + "\n" +
+			`    <ns:type xmlns:ns="http://www.w3.org/1999/02/22-rdf-syntax-ns#" rdf:resource="http://rdf.pharmb.io/cplogd/Compound"/>` + "\n" +
+			`    <ns:logD xmlns:ns="http://rdf.pharmb.io/cplogd/" rdf:datatype="http://www.w3.org/2001/XMLSchema#decimal">2.5</ns:logD>` + "\n" +
+			`  </rdf:Description>` + "\n" +
+			`</rdf:RDF>` + "\n",
+	}
+	for mediaType, expectedOutput := range expected {
+		var buf bytes.Buffer
+		format, _, _ := negotiateFormat(mediaType)
+		if err := format.Write(&buf, quads, &writeOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expectedOutput {
+			t.Errorf("Unexpected %s output. Expected:\n%s\nGot:\n%s", mediaType, expectedOutput, buf.String())
+		}
+	}
+}
+
+func TestURIResolverHandler(t *testing.T) {
+	h := &URIResolverHandler{"http://rdf.pharmb.io", testQuads, "Welcome", time.Second, nil, OutputOptions{}}
+	tests := []struct {
+		path                string
+		accept              string
+		expectedCode        int
+		expectedContentType string
+	}{
+		{"/", "", http.StatusOK, "text/plain; charset=utf-8"},
+		{"/cplogd/Compound1", "", http.StatusOK, "application/n-triples"},
+		{"/cplogd/Compound1", "application/n-quads", http.StatusOK, "application/n-quads"},
+		{"/cplogd/Compound1", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusOK, "text/html"},
+		{"/cplogd/Compound1", "image/png", http.StatusNotAcceptable, "text/plain; charset=utf-8"},
+		{"/cplogd/Compound2", "", http.StatusNotFound, "text/plain; charset=utf-8"},
+		{"/cplogd/Compound;1", "", http.StatusBadRequest, "text/plain; charset=utf-8"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("Accept", test.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != test.expectedCode {
+			t.Errorf("%s (Accept: %s): expected status %d, got %d", test.path, test.accept, test.expectedCode, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != test.expectedContentType {
+			t.Errorf("%s (Accept: %s): expected Content-Type %s, got %s", test.path, test.accept, test.expectedContentType, got)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// errNoSourceAvailable is returned by FailoverSource when the circuits of all
+// its sources are open
+var errNoSourceAvailable = errors.New("None of the data sources are available")
+
+// errSkipSource is returned by the queries passed to FailoverSource.try, to
+// move on to the next source without counting it as a failure
+var errSkipSource = errors.New("Skipped data source")
+
+// circuitBreaker keeps track of the failures of a source. After MaxFailures
+// consecutive failures, the circuit opens, and the source is not used for
+// Cooldown. After that, a single request is let through to try the source
+// again, which closes the circuit if it succeeds.
+type circuitBreaker struct {
+	MaxFailures int
+	Cooldown    time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	now       func() time.Time // For testing
+}
+
+func newCircuitBreaker(maxFailures int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{MaxFailures: maxFailures, Cooldown: cooldown, now: time.Now}
+}
+
+// allow returns true if the source may be used
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.MaxFailures <= 0 || b.failures < b.MaxFailures {
+		return true
+	}
+	now := b.now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	// Let this request try the source, while keeping others away until it
+	// has succeeded or failed
+	b.openUntil = now.Add(b.Cooldown)
+	return true
+}
+
+// success closes the circuit
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// failure records a failure, opening the circuit after MaxFailures in a row
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.MaxFailures > 0 && b.failures >= b.MaxFailures {
+		b.openUntil = b.now().Add(b.Cooldown)
+	}
+}
+
+// FailoverSource describes resources with the first of its sources which
+// works: if a source fails, or does not answer within Timeout (if non-zero,
+// and not for the last source), the next one is tried. Sources failing
+// repeatedly are skipped for a while (see circuitBreaker), so that they are
+// not hammered while down.
+type FailoverSource struct {
+	Sources  []NamedSource
+	Timeout  time.Duration
+	breakers []*circuitBreaker
+}
+
+// newFailoverSource creates a FailoverSource, where the circuit of a source
+// opens after maxFailures consecutive failures (never if zero), for cooldown
+func newFailoverSource(sources []NamedSource, timeout time.Duration, maxFailures int, cooldown time.Duration) *FailoverSource {
+	s := &FailoverSource{Sources: sources, Timeout: timeout}
+	for range sources {
+		s.breakers = append(s.breakers, newCircuitBreaker(maxFailures, cooldown))
+	}
+	return s
+}
+
+// SourcePicker is implemented by sources which pick one of several sources
+// to answer each request, and can tell which one did
+type SourcePicker interface {
+	// DescribeFrom is like Describe, but also returns the name of the source
+	// which the quads came from
+	DescribeFrom(ctx context.Context, uri string) ([]rdf.Quad, string, error)
+}
+
+// Describe returns the quads from the first source which works
+func (s *FailoverSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	quads, _, err := s.DescribeFrom(ctx, uri)
+	return quads, err
+}
+
+// DescribeFrom returns the quads from the first source which works, and the
+// name of that source
+func (s *FailoverSource) DescribeFrom(ctx context.Context, uri string) ([]rdf.Quad, string, error) {
+	var quads []rdf.Quad
+	var name string
+	err := s.try(ctx, func(ctx context.Context, source NamedSource) error {
+		var err error
+		quads, err = source.Source.Describe(ctx, uri)
+		name = source.Name
+		return err
+	})
+	return quads, name, err
+}
+
+// try calls query with the sources in order, until it succeeds. The last
+// error is returned if none of them succeed.
+func (s *FailoverSource) try(ctx context.Context, query func(ctx context.Context, source NamedSource) error) error {
+	err := errNoSourceAvailable
+	for i, source := range s.Sources {
+		if !s.breakers[i].allow() {
+			continue
+		}
+		queryCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.Timeout > 0 && i < len(s.Sources)-1 {
+			queryCtx, cancel = context.WithTimeout(ctx, s.Timeout)
+		}
+		queryErr := query(queryCtx, source)
+		cancel()
+		if queryErr == errSkipSource {
+			continue
+		}
+		err = queryErr
+		if err == nil {
+			s.breakers[i].success()
+			return nil
+		}
+		if ctx.Err() != nil {
+			// The request itself was cancelled or timed out, which says
+			// nothing about the source
+			return err
+		}
+		s.breakers[i].failure()
+		log.Printf("Data source %s failed, falling back to the next one: %s\n", source.Name, err.Error())
+	}
+	return err
+}
+
+// Labels looks up labels with the first source which can, and works
+func (s *FailoverSource) Labels(ctx context.Context, iris []string) ([]rdf.Triple, error) {
+	var labels []rdf.Triple
+	err := s.try(ctx, func(ctx context.Context, source NamedSource) error {
+		labeler, ok := source.Source.(Labeler)
+		if !ok {
+			return errSkipSource
+		}
+		var err error
+		labels, err = labeler.Labels(ctx, iris)
+		return err
+	})
+	return labels, err
+}
+
+// CheckReady runs the readiness checks of all sources, prefixing the names of
+// the checks with the source names. The source is ready as long as any of
+// its sources is, since requests fail over to it, while the failed checks of
+// the others are still listed (see checkNamedSources).
+func (s *FailoverSource) CheckReady(ctx context.Context) []HealthCheck {
+	return checkNamedSources(ctx, s.Sources)
+}
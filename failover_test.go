@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// countingSource counts the calls to the Source it wraps
+type countingSource struct {
+	Source
+	calls int
+}
+
+func (s *countingSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	s.calls++
+	return s.Source.Describe(ctx, uri)
+}
+
+// slowSource is a Source which does not answer before ctx is done
+type slowSource struct{}
+
+func (s slowSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	if !b.allow() {
+		t.Error("Expected the circuit to be closed after one failure")
+	}
+	b.failure()
+	if b.allow() {
+		t.Error("Expected the circuit to be open after two failures")
+	}
+	now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Error("Expected a single try after the cooldown")
+	}
+	if b.allow() {
+		t.Error("Expected no more tries while the first one is running")
+	}
+	b.success()
+	if !b.allow() {
+		t.Error("Expected the circuit to be closed after a success")
+	}
+}
+
+func TestFailoverSource(t *testing.T) {
+	primary := &countingSource{Source: failingSource{}}
+	s := newFailoverSource([]NamedSource{{"primary", primary}, {"snapshot", testQuads}}, time.Second, 2, time.Minute)
+	for i := 0; i < 3; i++ {
+		quads, name, err := s.DescribeFrom(context.Background(), testCompound)
+		if err != nil || name != "snapshot" || len(quads) != 2 {
+			t.Errorf("Expected the quads from the snapshot, got %v from %s (%v)", quads, name, err)
+		}
+	}
+	if primary.calls != 2 {
+		t.Errorf("Expected the primary source to be skipped after 2 failures, got %d calls", primary.calls)
+	}
+
+	s = newFailoverSource([]NamedSource{{"primary", slowSource{}}, {"snapshot", testQuads}}, 10*time.Millisecond, 2, time.Minute)
+	if _, name, err := s.DescribeFrom(context.Background(), testCompound); err != nil || name != "snapshot" {
+		t.Errorf("Expected a fallback after a timeout, got %s (%v)", name, err)
+	}
+
+	s = newFailoverSource([]NamedSource{{"primary", failingSource{}}}, time.Second, 1, time.Minute)
+	if _, err := s.Describe(context.Background(), testCompound); err == nil || err.Error() != "connection refused" {
+		t.Errorf("Expected the error of the only source, got %v", err)
+	}
+	if _, err := s.Describe(context.Background(), testCompound); err != errNoSourceAvailable {
+		t.Errorf("Expected no available source with an open circuit, got %v", err)
+	}
+	h := &URIResolverHandler{"http://rdf.pharmb.io", s, "", time.Second, nil, OutputOptions{}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/cplogd/Compound1", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without available sources, got %d", rec.Code)
+	}
+}
+
+func TestURIResolverHandlerSourceHeader(t *testing.T) {
+	s := newFailoverSource([]NamedSource{{"primary", failingSource{}}, {"snapshot", testQuads}}, time.Second, 5, time.Minute)
+	h := &URIResolverHandler{"http://rdf.pharmb.io", s, "", time.Second, nil, OutputOptions{}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/cplogd/Compound1", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Urisolve-Source") != "snapshot" {
+		t.Errorf("Expected the snapshot to serve the request, got status %d from %q", rec.Code, rec.Header().Get("X-Urisolve-Source"))
+	}
+}
+
+func TestFailoverSourceCheckReady(t *testing.T) {
+	s := newFailoverSource([]NamedSource{{"primary", checkedSource{}}, {"snapshot", checkedSource{ready: true}}}, time.Second, 2, time.Minute)
+	h := &ReadyzHandler{s, time.Second}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `{"name":"primary/sparql","ok":false,"error":"connection refused","optional":true}`) {
+		t.Errorf("Expected to be ready with the snapshot, listing the failed primary source, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	s = newFailoverSource([]NamedSource{{"primary", checkedSource{}}, {"snapshot", checkedSource{}}}, time.Second, 2, time.Minute)
+	rec = httptest.NewRecorder()
+	(&ReadyzHandler{s, time.Second}).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with all sources failing, got %d %s", rec.Code, rec.Body.String())
+	}
+}
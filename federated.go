@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/knakk/rdf"
+)
+
+// NamedSource is one of the sources of a FederatedSource or FailoverSource
+type NamedSource struct {
+	Name   string
+	Source Source
+}
+
+// FederatedSource describes resources by querying several sources
+// concurrently, and merging their descriptions. If OriginGraphPrefix is set,
+// each quad is put in a named graph identifying the source it came from
+// (OriginGraphPrefix followed by the member name), instead of the graph it
+// had in that source.
+type FederatedSource struct {
+	Members           []NamedSource
+	OriginGraphPrefix string
+}
+
+// PartialResultError is returned, together with the quads that could be
+// found, when some (but not all) members of a FederatedSource failed
+type PartialResultError struct {
+	Failures map[string]error // Member name -> error
+}
+
+func (e *PartialResultError) Error() string {
+	var failures []string
+	for _, m := range e.failedMembers() {
+		failures = append(failures, m+": "+e.Failures[m].Error())
+	}
+	return "Some data sources failed (" + strings.Join(failures, "; ") + ")"
+}
+
+// failedMembers returns the names of the failed members, sorted
+func (e *PartialResultError) failedMembers() []string {
+	var names []string
+	for name := range e.Failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeWarnings adds a Warning header to w for each failed member, telling
+// clients that the response is incomplete
+func (e *PartialResultError) writeWarnings(w http.ResponseWriter) {
+	for _, name := range e.failedMembers() {
+		text := "Data source " + name + " failed, the response may be incomplete"
+		w.Header().Add("Warning", `199 urisolve "`+strings.Replace(text, `"`, `\"`, -1)+`"`)
+	}
+}
+
+// Describe asks all members for the quads with uri as subject or object, and
+// returns the distinct quads. If some members fail, the quads of the others
+// are returned with a *PartialResultError. If all fail, the error of the
+// first one is returned.
+func (s *FederatedSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	results := make([][]rdf.Quad, len(s.Members))
+	errs := make([]error, len(s.Members))
+	var wg sync.WaitGroup
+	for i, m := range s.Members {
+		wg.Add(1)
+		go func(i int, m NamedSource) {
+			defer wg.Done()
+			results[i], errs[i] = m.Source.Describe(ctx, uri)
+		}(i, m)
+	}
+	wg.Wait()
+
+	partial := &PartialResultError{Failures: make(map[string]error)}
+	var quads []rdf.Quad
+	for i, m := range s.Members {
+		if errs[i] != nil {
+			log.Printf("Could not describe %s with data source %s: %s\n", uri, m.Name, errs[i].Error())
+			partial.Failures[m.Name] = errs[i]
+			continue
+		}
+		var origin rdf.Context
+		if s.OriginGraphPrefix != "" {
+			iri, err := rdf.NewIRI(s.OriginGraphPrefix + m.Name)
+			if err != nil {
+				return nil, err
+			}
+			origin = iri
+		}
+		for _, q := range results[i] {
+			if origin != nil {
+				q.Ctx = origin
+			}
+			quads = appendDistinctQuad(quads, q)
+		}
+	}
+
+	if len(partial.Failures) == len(s.Members) && len(s.Members) > 0 {
+		return nil, errs[0]
+	}
+	if len(partial.Failures) > 0 {
+		return quads, partial
+	}
+	return quads, nil
+}
+
+// appendDistinctQuad appends q to quads, unless an equal triple is already in
+// the same graph
+func appendDistinctQuad(quads []rdf.Quad, q rdf.Quad) []rdf.Quad {
+	for _, other := range quads {
+		if sameGraph(other.Ctx, q.Ctx) && rdf.TriplesEqual(other.Triple, q.Triple) {
+			return quads
+		}
+	}
+	return append(quads, q)
+}
+
+// sameGraph returns true if a and b are the same graph, where nil is the
+// default graph
+func sameGraph(a rdf.Context, b rdf.Context) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return rdf.TermsEqual(a, b)
+}
+
+// Labels looks up labels in all members which can, ignoring failures of
+// single members
+func (s *FederatedSource) Labels(ctx context.Context, iris []string) ([]rdf.Triple, error) {
+	var mu sync.Mutex
+	var labels []rdf.Triple
+	var wg sync.WaitGroup
+	for _, m := range s.Members {
+		labeler, ok := m.Source.(Labeler)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, labeler Labeler) {
+			defer wg.Done()
+			triples, err := labeler.Labels(ctx, iris)
+			if err != nil {
+				log.Printf("Could not look up labels with data source %s: %s\n", name, err.Error())
+				return
+			}
+			mu.Lock()
+			labels = append(labels, triples...)
+			mu.Unlock()
+		}(m.Name, labeler)
+	}
+	wg.Wait()
+	return labels, nil
+}
+
+// CheckReady runs the readiness checks of all members, prefixing the names
+// of the checks with the member names. Since failed members are skipped,
+// the source is ready as long as any member is (see checkNamedSources).
+func (s *FederatedSource) CheckReady(ctx context.Context) []HealthCheck {
+	return checkNamedSources(ctx, s.Members)
+}
+
+// checkNamedSources runs the readiness checks of sources, prefixing the
+// names of the checks with the source names. If any source passes all its
+// checks (sources without checks always do), the failed checks of the
+// others are made optional, so that they are reported without making the
+// combined source unavailable.
+func checkNamedSources(ctx context.Context, sources []NamedSource) []HealthCheck {
+	var checks []HealthCheck
+	anyReady := false
+	for _, m := range sources {
+		checker, ok := m.Source.(ReadinessChecker)
+		if !ok {
+			anyReady = true
+			continue
+		}
+		ready := true
+		for _, check := range checker.CheckReady(ctx) {
+			check.Name = m.Name + "/" + check.Name
+			ready = ready && check.Ok
+			checks = append(checks, check)
+		}
+		anyReady = anyReady || ready
+	}
+	if anyReady {
+		for i := range checks {
+			checks[i].Optional = !checks[i].Ok
+		}
+	}
+	return checks
+}
+
+// sourceName matches the names allowed for named sources, which are used in
+// response headers and origin graph IRIs
+var sourceName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseNamedSources parses a comma separated list of name=location
+// pairs, where locations starting with http:// or https:// are SPARQL
+// endpoints (restricted to graphs, if any), and other locations HDT files
+func parseNamedSources(list string, graphs []string) ([]NamedSource, error) {
+	var members []NamedSource
+	seen := make(map[string]bool)
+	for _, item := range splitList(list) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Invalid source %q, expected name=location", item)
+		}
+		name, location := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !sourceName.MatchString(name) {
+			return nil, fmt.Errorf("Invalid source name %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("Duplicate source name %q", name)
+		}
+		seen[name] = true
+		var source Source
+		if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+			source = &SparqlSource{location, graphs}
+		} else {
+			source = &HdtSource{FilePath: location}
+		}
+		members = append(members, NamedSource{name, source})
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("No sources given")
+	}
+	return members, nil
+}
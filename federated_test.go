@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// failingSource is a Source which always fails
+type failingSource struct{}
+
+func (s failingSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	return nil, errors.New("connection refused")
+}
+
+const testCompound = "http://rdf.pharmb.io/cplogd/Compound1"
+
+var testCuratedQuads = staticSource{
+	mustQuad(testCompound, "http://www.w3.org/1999/02/22-rdf-syntax-ns#type", mustIRI("http://rdf.pharmb.io/cplogd/Compound"), ""),
+	mustQuad(testCompound, "http://www.w3.org/2000/01/rdf-schema#comment", mustLangLiteral("Curated", "en"), ""),
+}
+
+func TestFederatedSourceDescribe(t *testing.T) {
+	s := &FederatedSource{Members: []NamedSource{{"dump", testQuads}, {"curated", testCuratedQuads}}}
+	quads, err := s.Describe(context.Background(), testCompound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rdf:type triple is in both sources, but only returned once
+	if len(quads) != 3 {
+		t.Errorf("Expected 3 distinct quads, got %d: %v", len(quads), quads)
+	}
+
+	s.OriginGraphPrefix = "http://rdf.pharmb.io/.well-known/sources/"
+	quads, err = s.Describe(context.Background(), testCompound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphs := make(map[string]int)
+	for _, q := range quads {
+		graphs[q.Ctx.String()]++
+	}
+	if len(quads) != 4 || graphs["http://rdf.pharmb.io/.well-known/sources/dump"] != 2 || graphs["http://rdf.pharmb.io/.well-known/sources/curated"] != 2 {
+		t.Errorf("Expected 2 quads in the graph of each source, got %v", graphs)
+	}
+}
+
+func TestFederatedSourcePartialFailure(t *testing.T) {
+	s := &FederatedSource{Members: []NamedSource{{"dump", testQuads}, {"curated", failingSource{}}}}
+	quads, err := s.Describe(context.Background(), testCompound)
+	partial, ok := err.(*PartialResultError)
+	if !ok {
+		t.Fatalf("Expected a partial result error, got %v", err)
+	}
+	if len(quads) != 2 || partial.Failures["curated"] == nil {
+		t.Errorf("Expected the quads of dump and a failure of curated, got %v (%v)", quads, partial)
+	}
+
+	h := &URIResolverHandler{"http://rdf.pharmb.io", s, "", time.Second, nil, OutputOptions{}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/cplogd/Compound1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+	if warning := rec.Header().Get("Warning"); !strings.HasPrefix(warning, `199 urisolve "Data source curated failed`) {
+		t.Errorf("Expected a warning about the curated source, got %q", warning)
+	}
+
+	s.Members[0].Source = failingSource{}
+	if _, err := s.Describe(context.Background(), testCompound); err == nil || err.Error() != "connection refused" {
+		t.Errorf("Expected an error when all sources fail, got %v", err)
+	}
+}
+
+func TestParseNamedSources(t *testing.T) {
+	members, err := parseNamedSources("dump=data/compounds.hdt, curated=http://localhost:3030/ds/sparql", []string{"http://ex.org/g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(members))
+	}
+	if hdt, ok := members[0].Source.(*HdtSource); !ok || hdt.FilePath != "data/compounds.hdt" {
+		t.Errorf("Expected an HDT source for dump, got %#v", members[0].Source)
+	}
+	if sparql, ok := members[1].Source.(*SparqlSource); !ok || sparql.EndpointUrl != "http://localhost:3030/ds/sparql" || len(sparql.Graphs) != 1 {
+		t.Errorf("Expected a SPARQL source for curated, got %#v", members[1].Source)
+	}
+
+	for _, invalid := range []string{"", "compounds.hdt", "a b=x.hdt", "a=x.hdt,a=y.hdt"} {
+		if _, err := parseNamedSources(invalid, nil); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+// checkedSource is a Source with a readiness check, which fails unless ready
+type checkedSource struct {
+	staticSource
+	ready bool
+}
+
+func (s checkedSource) CheckReady(ctx context.Context) []HealthCheck {
+	var err error
+	if !s.ready {
+		err = errors.New("connection refused")
+	}
+	return []HealthCheck{newHealthCheck("sparql", err)}
+}
+
+func TestFederatedSourceCheckReady(t *testing.T) {
+	tests := []struct {
+		members      []NamedSource
+		expectedCode int
+		expected     string
+	}{
+		{[]NamedSource{{"up", checkedSource{ready: true}}, {"down", checkedSource{}}}, http.StatusOK,
+			`{"status":"degraded","checks":[{"name":"up/sparql","ok":true},{"name":"down/sparql","ok":false,"error":"connection refused","optional":true}]}`},
+		{[]NamedSource{{"down", checkedSource{}}, {"other", checkedSource{}}}, http.StatusServiceUnavailable,
+			`{"status":"degraded","checks":[{"name":"down/sparql","ok":false,"error":"connection refused"},{"name":"other/sparql","ok":false,"error":"connection refused"}]}`},
+		{[]NamedSource{{"up", checkedSource{ready: true}}}, http.StatusOK,
+			`{"status":"ok","checks":[{"name":"up/sparql","ok":true}]}`},
+	}
+	for _, test := range tests {
+		h := &ReadyzHandler{&FederatedSource{Members: test.members}, time.Second}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != test.expectedCode || strings.TrimSpace(rec.Body.String()) != test.expected {
+			t.Errorf("Expected %d %s, got %d %s", test.expectedCode, test.expected, rec.Code, rec.Body.String())
+		}
+	}
+}
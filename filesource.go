@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/knakk/rdf"
+)
+
+// FileSource resolves URIs based on RDF files (Turtle, N-Triples, N-Quads or
+// RDF/XML), which are loaded into memory. It is meant for small datasets,
+// such as vocabularies, which are not worth converting to HDT.
+//
+// FilePath is a comma separated list of files, or glob patterns. The format
+// of each file is guessed from its extension (see rdfFormats). Relative IRIs
+// are resolved against the file:// IRI of the file.
+type FileSource struct {
+	FilePath string
+
+	mu       sync.RWMutex
+	store    *memoryStore
+	versions []fileVersion // The files loaded
+}
+
+// resolveRDFFiles returns the files that the comma separated list of paths
+// refers to, following symlinks and expanding glob patterns
+func resolveRDFFiles(paths string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range splitList(paths) {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern %s (%s)", pattern, err.Error())
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("No files match %s", pattern)
+			}
+		}
+		for _, match := range matches {
+			file, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No RDF files given")
+	}
+	return files, nil
+}
+
+// fileIRI returns the file:// IRI of the file at path
+func fileIRI(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(abs), nil
+}
+
+// loadRDFFiles reads files into a new, indexed memoryStore
+func loadRDFFiles(files []string) (*memoryStore, error) {
+	store := newMemoryStore()
+	for _, file := range files {
+		base, err := fileIRI(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := readRDFFile(file, "", base, store.add); err != nil {
+			return nil, fmt.Errorf("Could not read %s (%s)", file, err.Error())
+		}
+	}
+	store.index()
+	return store, nil
+}
+
+// statFiles returns the current versions of the files that FilePath refers
+// to
+func (s *FileSource) statFiles() ([]fileVersion, error) {
+	files, err := resolveRDFFiles(s.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	return statFiles(files)
+}
+
+// currentVersions returns the versions of the files loaded
+func (s *FileSource) currentVersions() []fileVersion {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.versions
+}
+
+// paths returns the paths of the files loaded
+func (s *FileSource) paths() []string {
+	var paths []string
+	for _, v := range s.currentVersions() {
+		paths = append(paths, v.Path)
+	}
+	return paths
+}
+
+// Reload loads the files that FilePath refers to, if they have changed since
+// they were loaded. The previously loaded data is kept if any of the files
+// can not be read.
+func (s *FileSource) Reload() (bool, error) {
+	versions, err := s.statFiles()
+	if err != nil {
+		return false, err
+	}
+	if s.loaded() != nil && sameHdtFiles(s.currentVersions(), versions, false) {
+		return false, nil
+	}
+	var files []string
+	for _, v := range versions {
+		files = append(files, v.Path)
+	}
+	store, err := loadRDFFiles(files)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	s.store, s.versions = store, versions
+	s.mu.Unlock()
+	return true, nil
+}
+
+// loaded returns the data loaded, or nil if the files are not loaded yet
+func (s *FileSource) loaded() *memoryStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store
+}
+
+// data returns the data loaded, or an error if the files are not loaded yet
+func (s *FileSource) data() (*memoryStore, error) {
+	store := s.loaded()
+	if store == nil {
+		return nil, fmt.Errorf("The RDF files are not loaded")
+	}
+	return store, nil
+}
+
+// Describe returns the quads with uri as subject or object, in the graphs
+// they were loaded in (the default graph, except for N-Quads files)
+func (s *FileSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	store, err := s.data()
+	if err != nil {
+		return nil, err
+	}
+	iri, err := rdf.NewIRI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return store.describe(iri), nil
+}
+
+// Labels looks up the labels of iris in the SPO index
+func (s *FileSource) Labels(ctx context.Context, iris []string) ([]rdf.Triple, error) {
+	store, err := s.data()
+	if err != nil {
+		return nil, err
+	}
+	var labels []rdf.Triple
+	for _, uri := range iris {
+		iri, err := rdf.NewIRI(uri)
+		if err != nil {
+			continue
+		}
+		for _, q := range store.match(store.spo, iri) {
+			if q.Obj.Type() == rdf.TermLiteral && isLabelPredicate(q.Pred.String()) {
+				labels = append(labels, q.Triple)
+			}
+		}
+	}
+	return labels, nil
+}
+
+// typeTriples returns the distinct rdf:type triples, from the POS index
+func (s *FileSource) typeTriples() ([]rdf.Triple, error) {
+	store, err := s.data()
+	if err != nil {
+		return nil, err
+	}
+	typeIRI, _ := rdf.NewIRI(rdfType)
+	return quadsToTriples(store.match(store.pos, typeIRI)), nil
+}
+
+// Classes counts the instances of each class in the POS index
+func (s *FileSource) Classes(ctx context.Context) ([]UsageCount, error) {
+	triples, err := s.typeTriples()
+	if err != nil {
+		return nil, err
+	}
+	instances := make(map[string]int64)
+	for _, t := range triples {
+		instances[t.Obj.String()]++
+	}
+	var classes []UsageCount
+	for class, n := range instances {
+		classes = append(classes, UsageCount{class, n})
+	}
+	sortUsageCounts(classes)
+	return classes, nil
+}
+
+// Instances lists the instances of class in the POS index
+func (s *FileSource) Instances(ctx context.Context, class string, offset int, limit int) ([]string, error) {
+	triples, err := s.typeTriples()
+	if err != nil {
+		return nil, err
+	}
+	var instances []string
+	for _, t := range triples {
+		if t.Obj.String() == class && t.Obj.Type() == rdf.TermIRI {
+			instances = append(instances, t.Subj.String())
+		}
+	}
+	sort.Strings(instances)
+	return pageOf(instances, offset, limit), nil
+}
+
+// Properties counts the triples of each predicate in the POS index
+func (s *FileSource) Properties(ctx context.Context) ([]UsageCount, error) {
+	store, err := s.data()
+	if err != nil {
+		return nil, err
+	}
+	var properties []UsageCount
+	for id := range store.pos {
+		properties = append(properties, UsageCount{store.terms[id].String(), int64(len(quadsToTriples(store.match(store.pos, store.terms[id]))))})
+	}
+	sortUsageCounts(properties)
+	return properties, nil
+}
+
+// DatasetStats counts the triples, and the keys of the indexes
+func (s *FileSource) DatasetStats(ctx context.Context, uriSpace string, public func(iri string) bool) (*DatasetStats, error) {
+	store, err := s.data()
+	if err != nil {
+		return nil, err
+	}
+	stats := newDatasetStats()
+	stats.Triples = store.triples()
+	stats.DistinctSubjects = int64(len(store.spo))
+	stats.DistinctObjects = int64(len(store.osp))
+	stats.Properties = int64(len(store.pos))
+	classes, err := s.Classes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats.Classes = int64(len(classes))
+	var subjects []string
+	for id := range store.spo {
+		if iri := store.terms[id]; iri.Type() == rdf.TermIRI && strings.HasPrefix(iri.String(), uriSpace) && public(iri.String()) {
+			subjects = append(subjects, iri.String())
+		}
+	}
+	sort.Strings(subjects)
+	if len(subjects) > maxExampleResources {
+		subjects = subjects[:maxExampleResources]
+	}
+	stats.ExampleResources = subjects
+	return stats, nil
+}
+
+// CheckReady verifies that the RDF files are loaded, and can still be read
+func (s *FileSource) CheckReady(ctx context.Context) []HealthCheck {
+	var loadErr error
+	if s.loaded() == nil {
+		loadErr = fmt.Errorf("The RDF files are not loaded")
+	}
+	checks := []HealthCheck{newHealthCheck("rdf-loaded", loadErr)}
+	for _, path := range s.paths() {
+		f, err := os.Open(path)
+		if err == nil {
+			f.Close()
+		}
+		checks = append(checks, newHealthCheck(filepath.Base(path)+"/rdf-file", err))
+	}
+	return checks
+}
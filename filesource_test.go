@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	s := &FileSource{FilePath: "example_data.nt"}
+	if _, err := s.Describe(context.Background(), testCompound); err == nil {
+		t.Error("Expected an error before the files are loaded")
+	}
+	if reloaded, err := s.Reload(); err != nil || !reloaded {
+		t.Fatalf("Expected the file to be loaded, got %v (%v)", reloaded, err)
+	}
+
+	quads, err := s.Describe(context.Background(), testCompound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quads) != 6 {
+		t.Errorf("Expected 6 quads about %s, got %d", testCompound, len(quads))
+	}
+	classes, err := s.Classes(context.Background())
+	if err != nil || len(classes) != 9 || classes[0].Count != 10 {
+		t.Errorf("Expected 9 classes, with 10 instances of the first, got %v (%v)", classes, err)
+	}
+	instances, err := s.Instances(context.Background(), "http://rdf.pharmb.io/cplogd/Compound", 8, 5)
+	if err != nil || len(instances) != 2 {
+		t.Errorf("Expected the last 2 compounds, got %v (%v)", instances, err)
+	}
+	stats, err := s.DatasetStats(context.Background(), "http://rdf.pharmb.io/cplogd/", allPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Triples != 135 || stats.DistinctSubjects != 51 || stats.DistinctObjects != 88 || stats.Properties != 9 || stats.Classes != 9 {
+		t.Errorf("Expected the same statistics as the HDT file, got %+v", stats)
+	}
+	if len(stats.ExampleResources) != maxExampleResources {
+		t.Errorf("Expected %d example resources, got %v", maxExampleResources, stats.ExampleResources)
+	}
+}
+
+func TestFileSourceNQuads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve-rdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data.nq")
+	ioutil.WriteFile(path, []byte(`<http://example.org/a> <http://www.w3.org/2000/01/rdf-schema#label> "A" <http://example.org/g1> .
+<http://example.org/a> <http://www.w3.org/2000/01/rdf-schema#label> "A" <http://example.org/g2> .
+<http://example.org/a> <http://example.org/p> <http://example.org/a> .
+`), 0644)
+
+	s := &FileSource{FilePath: filepath.Join(dir, "*.nq")}
+	if _, err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	quads, err := s.Describe(context.Background(), "http://example.org/a")
+	if err != nil || len(quads) != 3 {
+		t.Fatalf("Expected 3 quads, got %v (%v)", quads, err)
+	}
+	if quads[0].Ctx != nil || quads[1].Ctx == nil {
+		t.Errorf("Expected the graphs to be kept, got %v", quads)
+	}
+	if stats, _ := s.DatasetStats(context.Background(), "", allPublic); stats.Triples != 2 {
+		t.Errorf("Expected 2 distinct triples, got %d", stats.Triples)
+	}
+
+	if reloaded, err := s.Reload(); err != nil || reloaded {
+		t.Errorf("Expected no reload of unchanged files, got %v (%v)", reloaded, err)
+	}
+	ioutil.WriteFile(path, []byte("<http://example.org/a> <http://example.org/p> .\n"), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	if _, err := s.Reload(); err == nil {
+		t.Error("Expected an error for an invalid file")
+	}
+	if quads, _ := s.Describe(context.Background(), "http://example.org/a"); len(quads) != 3 {
+		t.Errorf("Expected the previous data to be kept, got %v", quads)
+	}
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knakk/rdf"
+	bolt "go.etcd.io/bbolt"
+)
+
+// GraphStore is implemented by sources which can be changed, as in the
+// SPARQL 1.1 Graph Store HTTP Protocol. Graphs are given by their IRI, or
+// as "" for the default graph. Resources are the triples with a given
+// subject in the default graph.
+type GraphStore interface {
+	// Graph returns (at most limit of) the triples in graph
+	Graph(ctx context.Context, graph string, limit int) ([]rdf.Triple, error)
+	// AddTriples adds triples to graph
+	AddTriples(ctx context.Context, graph string, triples []rdf.Triple) error
+	// DeleteTriples deletes triples from graph
+	DeleteTriples(ctx context.Context, graph string, triples []rdf.Triple) error
+	// ReplaceGraph replaces the triples in graph with triples, returning
+	// true if the graph was empty
+	ReplaceGraph(ctx context.Context, graph string, triples []rdf.Triple) (bool, error)
+	// DeleteGraph deletes the triples in graph, returning false if there
+	// were none
+	DeleteGraph(ctx context.Context, graph string) (bool, error)
+	// AddResourceTriples adds triples to the default graph, returning true
+	// if there were no triples with iri as subject before
+	AddResourceTriples(ctx context.Context, iri string, triples []rdf.Triple) (bool, error)
+	// ReplaceResource replaces the triples with iri as subject in the
+	// default graph with triples, returning true if there were none
+	ReplaceResource(ctx context.Context, iri string, triples []rdf.Triple) (bool, error)
+	// DeleteResource deletes the triples with iri as subject in the default
+	// graph, returning false if there were none
+	DeleteResource(ctx context.Context, iri string) (bool, error)
+}
+
+// inputFormats maps the media types accepted for RDF sent to the graph store
+// to the formats of the rdf package
+var inputFormats = map[string]rdf.Format{
+	"text/turtle":           rdf.Turtle,
+	"application/x-turtle":  rdf.Turtle,
+	"application/n-triples": rdf.NTriples,
+	"text/plain":            rdf.NTriples,
+	"application/rdf+xml":   rdf.RDFXML,
+}
+
+// SparqlGraphStore changes the data behind a SPARQL source with SPARQL 1.1
+// Update requests to UpdateEndpoint
+type SparqlGraphStore struct {
+	Source         *SparqlSource
+	UpdateEndpoint string
+}
+
+// sparqlGraphPattern returns pattern within graph (unless it is the default
+// graph)
+func sparqlGraphPattern(graph string, pattern string) string {
+	if graph == "" {
+		return pattern
+	}
+	return "GRAPH <" + graph + "> { " + pattern + " }"
+}
+
+// sparqlData returns triples as the data of an INSERT DATA request
+func sparqlData(graph string, triples []rdf.Triple) string {
+	var data []string
+	for _, t := range triples {
+		data = append(data, strings.TrimSuffix(t.Serialize(rdf.NTriples), "\n"))
+	}
+	return sparqlGraphPattern(graph, strings.Join(data, "\n  "))
+}
+
+// update runs a SPARQL Update request against the update endpoint
+func (s *SparqlGraphStore) update(ctx context.Context, update string) error {
+	fmt.Printf("Updating %s with an update of %d bytes\n", s.UpdateEndpoint, len(update))
+
+	form := url.Values{"update": {update}}
+	request, err := http.NewRequest("POST", s.UpdateEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("SPARQL update endpoint returned status %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// exists returns true if pattern has any match
+func (s *SparqlGraphStore) exists(ctx context.Context, pattern string) (bool, error) {
+	bindings, err := s.Source.selectQuery(ctx, "SELECT * WHERE { "+pattern+" } LIMIT 1")
+	return len(bindings) > 0, err
+}
+
+// Graph selects (at most limit of) the triples in graph
+func (s *SparqlGraphStore) Graph(ctx context.Context, graph string, limit int) ([]rdf.Triple, error) {
+	bindings, err := s.Source.selectQuery(ctx, "SELECT ?s ?p ?o WHERE { "+sparqlGraphPattern(graph, "?s ?p ?o")+" } LIMIT "+strconv.Itoa(limit))
+	if err != nil {
+		return nil, err
+	}
+	var triples []rdf.Triple
+	for _, b := range bindings {
+		q, err := bindingToQuad(b)
+		if err != nil {
+			return nil, err
+		}
+		triples = append(triples, q.Triple)
+	}
+	return triples, nil
+}
+
+// AddTriples adds triples with INSERT DATA
+func (s *SparqlGraphStore) AddTriples(ctx context.Context, graph string, triples []rdf.Triple) error {
+	return s.update(ctx, "INSERT DATA {\n  "+sparqlData(graph, triples)+"\n}")
+}
+
+// DeleteTriples deletes triples with DELETE DATA
+func (s *SparqlGraphStore) DeleteTriples(ctx context.Context, graph string, triples []rdf.Triple) error {
+	return s.update(ctx, "DELETE DATA {\n  "+sparqlData(graph, triples)+"\n}")
+}
+
+// dropGraph returns an update dropping graph, if it exists
+func dropGraph(graph string) string {
+	if graph == "" {
+		return "DROP SILENT DEFAULT"
+	}
+	return "DROP SILENT GRAPH <" + graph + ">"
+}
+
+// ReplaceGraph drops graph, and adds triples with INSERT DATA
+func (s *SparqlGraphStore) ReplaceGraph(ctx context.Context, graph string, triples []rdf.Triple) (bool, error) {
+	exists, err := s.exists(ctx, sparqlGraphPattern(graph, "?s ?p ?o"))
+	if err != nil {
+		return false, err
+	}
+	return !exists, s.update(ctx, dropGraph(graph)+" ;\nINSERT DATA {\n  "+sparqlData(graph, triples)+"\n}")
+}
+
+// DeleteGraph drops graph
+func (s *SparqlGraphStore) DeleteGraph(ctx context.Context, graph string) (bool, error) {
+	exists, err := s.exists(ctx, sparqlGraphPattern(graph, "?s ?p ?o"))
+	if err != nil || !exists {
+		return false, err
+	}
+	return true, s.update(ctx, dropGraph(graph))
+}
+
+// AddResourceTriples adds triples with INSERT DATA
+func (s *SparqlGraphStore) AddResourceTriples(ctx context.Context, iri string, triples []rdf.Triple) (bool, error) {
+	exists, err := s.exists(ctx, "<"+iri+"> ?p ?o")
+	if err != nil {
+		return false, err
+	}
+	return !exists, s.AddTriples(ctx, "", triples)
+}
+
+// ReplaceResource deletes the triples of iri with DELETE WHERE, and adds
+// triples with INSERT DATA
+func (s *SparqlGraphStore) ReplaceResource(ctx context.Context, iri string, triples []rdf.Triple) (bool, error) {
+	exists, err := s.exists(ctx, "<"+iri+"> ?p ?o")
+	if err != nil {
+		return false, err
+	}
+	return !exists, s.update(ctx, "DELETE WHERE { <"+iri+"> ?p ?o } ;\nINSERT DATA {\n  "+sparqlData("", triples)+"\n}")
+}
+
+// DeleteResource deletes the triples of iri with DELETE WHERE
+func (s *SparqlGraphStore) DeleteResource(ctx context.Context, iri string) (bool, error) {
+	exists, err := s.exists(ctx, "<"+iri+"> ?p ?o")
+	if err != nil || !exists {
+		return false, err
+	}
+	return true, s.update(ctx, "DELETE WHERE { <"+iri+"> ?p ?o }")
+}
+
+// storeGraphID returns the ID of graph, and false if the store has no such
+// term
+func storeGraphID(tx *bolt.Tx, graph string) (uint64, bool, error) {
+	if graph == "" {
+		return 0, true, nil
+	}
+	iri, err := rdf.NewIRI(graph)
+	if err != nil {
+		return 0, false, err
+	}
+	id, err := storeTermID(tx, iri, false)
+	return id, id != 0, err
+}
+
+// storeGraphQuads returns quads for triples in graph
+func storeGraphQuads(graph string, triples []rdf.Triple) ([]rdf.Quad, error) {
+	quads := triplesToQuads(triples)
+	if graph != "" {
+		iri, err := rdf.NewIRI(graph)
+		if err != nil {
+			return nil, err
+		}
+		for i := range quads {
+			quads[i].Ctx = iri
+		}
+	}
+	return quads, nil
+}
+
+// storeDeleteGraph deletes the quads in graph (scanning the whole SPO index,
+// since graphs are not indexed), returning the number of them
+func storeDeleteGraph(tx *bolt.Tx, graph string) (int, error) {
+	id, ok, err := storeGraphID(tx, graph)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return storeDeleteQuads(tx, storeSPOBucket, nil, func(ids [4]uint64) bool {
+		return ids[3] == id
+	})
+}
+
+// storeDeleteResource deletes the quads with iri as subject in the default
+// graph, returning the number of them
+func storeDeleteResource(tx *bolt.Tx, iri string) (int, error) {
+	subject, err := rdf.NewIRI(iri)
+	if err != nil {
+		return 0, err
+	}
+	id, err := storeTermID(tx, subject, false)
+	if err != nil || id == 0 {
+		return 0, err
+	}
+	return storeDeleteQuads(tx, storeSPOBucket, []uint64{id}, func(ids [4]uint64) bool {
+		return ids[3] == 0
+	})
+}
+
+// Graph returns (at most limit of) the triples in graph, scanning the SPO
+// index
+func (s *StoreSource) Graph(ctx context.Context, graph string, limit int) ([]rdf.Triple, error) {
+	var triples []rdf.Triple
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id, ok, err := storeGraphID(tx, graph)
+		if err != nil || !ok {
+			return err
+		}
+		storeScan(tx, storeSPOBucket, nil, func(ids [4]uint64) bool {
+			if ids[3] != id {
+				return true
+			}
+			var q rdf.Quad
+			q, err = storeQuad(tx, ids)
+			triples = append(triples, q.Triple)
+			return err == nil && len(triples) < limit
+		})
+		return err
+	})
+	return triples, err
+}
+
+// AddTriples adds triples to graph
+func (s *StoreSource) AddTriples(ctx context.Context, graph string, triples []rdf.Triple) error {
+	quads, err := storeGraphQuads(graph, triples)
+	if err != nil {
+		return err
+	}
+	_, err = s.addQuads(quads)
+	return err
+}
+
+// DeleteTriples deletes triples from graph
+func (s *StoreSource) DeleteTriples(ctx context.Context, graph string, triples []rdf.Triple) error {
+	quads, err := storeGraphQuads(graph, triples)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+	quads:
+		for _, q := range quads {
+			var ids [4]uint64
+			for i, term := range []rdf.Term{q.Subj, q.Pred, q.Obj, q.Ctx} {
+				if term == nil {
+					continue
+				}
+				if ids[i], err = storeTermID(tx, term, false); err != nil {
+					return err
+				}
+				if ids[i] == 0 {
+					continue quads
+				}
+			}
+			_, err = storeDeleteQuads(tx, storeSPOBucket, ids[:3], func(match [4]uint64) bool {
+				return match[3] == ids[3]
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReplaceGraph replaces the triples in graph, in one transaction
+func (s *StoreSource) ReplaceGraph(ctx context.Context, graph string, triples []rdf.Triple) (bool, error) {
+	quads, err := storeGraphQuads(graph, triples)
+	if err != nil {
+		return false, err
+	}
+	deleted := 0
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if deleted, err = storeDeleteGraph(tx, graph); err != nil {
+			return err
+		}
+		_, err = storeAddQuads(tx, quads)
+		return err
+	})
+	return deleted == 0, err
+}
+
+// DeleteGraph deletes the triples in graph
+func (s *StoreSource) DeleteGraph(ctx context.Context, graph string) (bool, error) {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = storeDeleteGraph(tx, graph)
+		return err
+	})
+	return deleted > 0, err
+}
+
+// AddResourceTriples adds triples, checking whether iri had any in the same
+// transaction
+func (s *StoreSource) AddResourceTriples(ctx context.Context, iri string, triples []rdf.Triple) (bool, error) {
+	subject, err := rdf.NewIRI(iri)
+	if err != nil {
+		return false, err
+	}
+	exists := false
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		id, err := storeTermID(tx, subject, false)
+		if err != nil {
+			return err
+		}
+		if id != 0 {
+			storeScan(tx, storeSPOBucket, []uint64{id}, func(ids [4]uint64) bool {
+				exists = ids[3] == 0
+				return !exists
+			})
+		}
+		_, err = storeAddQuads(tx, triplesToQuads(triples))
+		return err
+	})
+	return !exists, err
+}
+
+// ReplaceResource replaces the triples of iri, in one transaction
+func (s *StoreSource) ReplaceResource(ctx context.Context, iri string, triples []rdf.Triple) (bool, error) {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if deleted, err = storeDeleteResource(tx, iri); err != nil {
+			return err
+		}
+		_, err = storeAddQuads(tx, triplesToQuads(triples))
+		return err
+	})
+	return deleted == 0, err
+}
+
+// DeleteResource deletes the triples of iri
+func (s *StoreSource) DeleteResource(ctx context.Context, iri string) (bool, error) {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = storeDeleteResource(tx, iri)
+		return err
+	})
+	return deleted > 0, err
+}
+
+// GraphStoreHandler serves the SPARQL 1.1 Graph Store HTTP Protocol for
+// Store: at /graph-store, graphs are given with the graph (or default) query
+// parameter, and can be read with GET, replaced with PUT, added to with POST
+// and deleted with DELETE. Resources (the triples with a URI as subject in
+// the default graph) can also be replaced, added to and deleted at the URI
+// itself (see withGraphStore).
+//
+// Changes are only allowed for authenticated clients, who are also in
+// Writers (as in access rules, e.g. "authenticated" or "group:curators").
+// Since graphs are not covered by the access rules for resources, only
+// Writers may read them too, and graphs with more than MaxGraphTriples
+// triples are refused.
+// RDF sent is parsed, and rejected unless it is valid. Afterwards, the
+// OnChange functions are called, to recompute anything computed from the
+// previous data.
+type GraphStoreHandler struct {
+	URIHost         string
+	Store           GraphStore
+	Auth            *Authenticator
+	Writers         []string
+	MaxUploadSize   int64
+	MaxGraphTriples int
+	QueryTimeout    time.Duration
+	Limiter         *concurrencyLimiter
+	Output          OutputOptions
+	OnChange        []func()
+}
+
+func (h *GraphStoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	graph := query.Get("graph")
+	_, isDefault := query["default"]
+	if (graph == "") == !isDefault {
+		http.Error(w, "Error: Give either the graph IRI with the graph parameter, or the default parameter for the default graph", http.StatusBadRequest)
+		return
+	}
+	if graph != "" && !absoluteIRI(graph) {
+		http.Error(w, "Error: Invalid graph IRI", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		if !h.allowWrite(w, r, "read whole graphs") {
+			return
+		}
+		h.serveGraph(w, r, graph)
+	case "PUT", "POST":
+		triples, ok := h.readTriples(w, r, graph)
+		if !ok {
+			return
+		}
+		h.change(w, r, func(ctx context.Context) (int, error) {
+			if r.Method == "POST" {
+				return http.StatusNoContent, h.Store.AddTriples(ctx, graph, triples)
+			}
+			created, err := h.Store.ReplaceGraph(ctx, graph, triples)
+			if created {
+				return http.StatusCreated, err
+			}
+			return http.StatusNoContent, err
+		})
+	case "DELETE":
+		if !h.allowWrite(w, r, "change data") {
+			return
+		}
+		h.change(w, r, func(ctx context.Context) (int, error) {
+			deleted, err := h.Store.DeleteGraph(ctx, graph)
+			if !deleted {
+				return http.StatusNotFound, err
+			}
+			return http.StatusNoContent, err
+		})
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		http.Error(w, "Error: Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// absoluteIRI returns true if s is a valid, absolute IRI
+func absoluteIRI(s string) bool {
+	if _, err := rdf.NewIRI(s); err != nil {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs()
+}
+
+// serveGraph writes the triples in graph, in the negotiated format
+func (h *GraphStoreHandler) serveGraph(w http.ResponseWriter, r *http.Request, graph string) {
+	w.Header().Add("Vary", "Accept")
+	format, mediaType, params := negotiateFormat(r.Header.Get("Accept"))
+	if format == nil {
+		http.Error(w, "Error: None of the requested formats are supported. Supported formats are: "+strings.Join(supportedMediaTypes(), ", "), http.StatusNotAcceptable)
+		return
+	}
+	if !acquireBackend(w, r, h.Limiter) {
+		return
+	}
+	defer h.Limiter.release()
+	ctx, cancel := withQueryTimeout(r.Context(), h.QueryTimeout)
+	defer cancel()
+	// Ask for one more triple than allowed, to know if there are too many
+	triples, err := h.Store.Graph(ctx, graph, h.MaxGraphTriples+1)
+	if err != nil {
+		writeBackendError(w, ctx, err)
+		return
+	}
+	if len(triples) == 0 {
+		http.Error(w, "Error: The graph is empty, or does not exist", http.StatusNotFound)
+		return
+	}
+	if len(triples) > h.MaxGraphTriples {
+		http.Error(w, "Error: The graph has more than "+strconv.Itoa(h.MaxGraphTriples)+" triples, which is too many to return at once", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	if err := format.Write(w, triplesToQuads(triples), &writeOptions{OutputOptions: h.Output, Resource: graph, Params: params}); err != nil {
+		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveResource changes the resource iri: PUT replaces its triples, POST
+// adds to them, and DELETE deletes them. The RDF sent may only have iri as
+// subject. If the resource is a member of a container (see ContainerHandler),
+// it is added to the container when created with PUT or POST, and removed
+// from it when deleted, while RDF sent with ldp:contains triples is refused.
+func (h *GraphStoreHandler) serveResource(w http.ResponseWriter, r *http.Request, iri string, container string) {
+	var containment []rdf.Triple
+	if container != "" {
+		containment = []rdf.Triple{ldpContains(container, iri)}
+	}
+	switch r.Method {
+	case "PUT", "POST":
+		triples, ok := h.readResourceTriples(w, r, iri)
+		if !ok || (container != "" && hasContainment(w, triples)) {
+			return
+		}
+		h.change(w, r, func(ctx context.Context) (int, error) {
+			var created bool
+			var err error
+			if r.Method == "POST" {
+				created, err = h.Store.AddResourceTriples(ctx, iri, triples)
+			} else {
+				created, err = h.Store.ReplaceResource(ctx, iri, triples)
+			}
+			if err != nil || !created {
+				return http.StatusNoContent, err
+			}
+			if containment != nil {
+				err = h.Store.AddTriples(ctx, "", containment)
+			}
+			return http.StatusCreated, err
+		})
+	case "DELETE":
+		if !h.allowWrite(w, r, "change data") {
+			return
+		}
+		h.change(w, r, func(ctx context.Context) (int, error) {
+			deleted, err := h.Store.DeleteResource(ctx, iri)
+			if err != nil || !deleted {
+				return http.StatusNotFound, err
+			}
+			if containment != nil {
+				err = h.Store.DeleteTriples(ctx, "", containment)
+			}
+			return http.StatusNoContent, err
+		})
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		http.Error(w, "Error: Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// allowWrite checks that the client is one of the Writers, who may change
+// data and read whole graphs (action, as in "change data"), answering 401 or
+// 403 if not
+func (h *GraphStoreHandler) allowWrite(w http.ResponseWriter, r *http.Request, action string) bool {
+	p := principalFromContext(r.Context())
+	if p == nil {
+		if h.Auth != nil {
+			h.Auth.challenge(w)
+		}
+		http.Error(w, "Error: Authentication required to "+action, http.StatusUnauthorized)
+		return false
+	}
+	if !(&AccessRule{Allowed: h.Writers}).allows(p) {
+		http.Error(w, "Error: "+p.Name+" is not allowed to "+action, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// readTriples checks that the client may change data, and parses the RDF in
+// the request body, resolving relative IRIs against base. It answers with
+// an error, and returns false, if the client may not change data, or the RDF
+// can't be parsed.
+func (h *GraphStoreHandler) readTriples(w http.ResponseWriter, r *http.Request, base string) ([]rdf.Triple, bool) {
+	if !h.allowWrite(w, r, "change data") {
+		return nil, false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, ok := inputFormats[mediaType]
+	if err != nil || !ok {
+		http.Error(w, "Error: Unsupported content type. Supported types are: "+strings.Join(acceptedInputTypes(), ", "), http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	// The body is read before decoding, since the decoders don't report all
+	// read errors
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.MaxUploadSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error: The RDF sent is larger than %d bytes", h.MaxUploadSize), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	dec := rdf.NewTripleDecoder(bytes.NewReader(body), format)
+	if base != "" && format != rdf.NTriples {
+		if baseIRI, err := rdf.NewIRI(base); err == nil {
+			dec.SetOption(rdf.Base, baseIRI)
+		}
+	}
+	var triples []rdf.Triple
+	for {
+		t, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Error: Invalid RDF ("+err.Error()+")", http.StatusBadRequest)
+			return nil, false
+		}
+		triples = append(triples, t)
+	}
+	return triples, true
+}
+
+// readResourceTriples reads the triples sent for the resource iri, as
+// readTriples, and answers 422 if any of them has another subject
+func (h *GraphStoreHandler) readResourceTriples(w http.ResponseWriter, r *http.Request, iri string) ([]rdf.Triple, bool) {
+	triples, ok := h.readTriples(w, r, iri)
+	if !ok {
+		return nil, false
+	}
+	for _, t := range triples {
+		if t.Subj.Type() != rdf.TermIRI || t.Subj.String() != iri {
+			http.Error(w, "Error: Only triples with <"+iri+"> as subject can be sent to it, not with "+t.Subj.Serialize(rdf.NTriples), http.StatusUnprocessableEntity)
+			return nil, false
+		}
+	}
+	return triples, true
+}
+
+// change runs a change with the limiter and query timeout, answers with the
+// status it returns, and calls the OnChange functions if it succeeded
+func (h *GraphStoreHandler) change(w http.ResponseWriter, r *http.Request, change func(ctx context.Context) (int, error)) {
+	if !acquireBackend(w, r, h.Limiter) {
+		return
+	}
+	defer h.Limiter.release()
+	ctx, cancel := withQueryTimeout(r.Context(), h.QueryTimeout)
+	defer cancel()
+	status, err := change(ctx)
+	if err != nil {
+		writeBackendError(w, ctx, err)
+		return
+	}
+	if status == http.StatusNotFound {
+		http.Error(w, "Error: Could not find any triples to delete", http.StatusNotFound)
+		return
+	}
+	for _, f := range h.OnChange {
+		f()
+	}
+	w.WriteHeader(status)
+}
+
+// withGraphStore lets store handle requests changing resources, and
+// resources handle all other requests
+func withGraphStore(store *GraphStoreHandler, resources http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || r.URL.Path == "/" {
+			resources.ServeHTTP(w, r)
+			return
+		}
+		iri := store.URIHost + r.URL.Path
+		if !validUri(iri) {
+			http.Error(w, "Error: Invalid URI (invalid characters in URI)", http.StatusBadRequest)
+			return
+		}
+		store.serveResource(w, r, iri, "")
+	})
+}
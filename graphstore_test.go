@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// graphStoreRequest returns a request to the graph store, authenticated as
+// user unless user is ""
+func graphStoreRequest(method, target, contentType, body, user string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if user != "" {
+		p := &Principal{Name: user, Groups: []string{"curators"}}
+		r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p))
+	}
+	return r
+}
+
+func TestGraphStoreHandler(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	changes := 0
+	h := &GraphStoreHandler{URIHost: "http://example.org", Store: s, Writers: []string{"group:curators"},
+		MaxUploadSize: 1024, MaxGraphTriples: 3, OnChange: []func(){func() { changes++ }}}
+	graph := "/graph-store?graph=http%3A%2F%2Fexample.org%2Fg"
+	turtle := "@prefix ex: <http://example.org/> .\nex:a ex:p ex:b, ex:c .\n"
+
+	requests := []struct {
+		r      *http.Request
+		status int
+	}{
+		{graphStoreRequest("PUT", graph, "text/turtle", turtle, ""), http.StatusUnauthorized},
+		{graphStoreRequest("GET", graph, "", "", ""), http.StatusUnauthorized},
+		{graphStoreRequest("GET", graph, "", "", "alice"), http.StatusNotFound},
+		{graphStoreRequest("PUT", graph, "text/turtle", turtle, "alice"), http.StatusCreated},
+		{graphStoreRequest("PUT", graph, "text/turtle", turtle, "alice"), http.StatusNoContent},
+		{graphStoreRequest("POST", graph, "application/n-triples", "<http://example.org/d> <http://example.org/p> \"D\" .\n", "alice"), http.StatusNoContent},
+		{graphStoreRequest("GET", graph, "", "", ""), http.StatusUnauthorized},
+		{graphStoreRequest("GET", graph, "", "", "alice"), http.StatusOK},
+		{graphStoreRequest("POST", graph, "application/n-triples", "<http://example.org/e> <http://example.org/p> \"E\" .\n", "alice"), http.StatusNoContent},
+		{graphStoreRequest("GET", graph, "", "", "alice"), http.StatusForbidden},
+		{graphStoreRequest("PUT", graph, "text/turtle", "ex:a ex:p ex:b .", "alice"), http.StatusBadRequest},
+		{graphStoreRequest("PUT", graph, "application/json", "{}", "alice"), http.StatusUnsupportedMediaType},
+		{graphStoreRequest("PUT", graph, "text/turtle", strings.Repeat("#", 2048), "alice"), http.StatusRequestEntityTooLarge},
+		{graphStoreRequest("PUT", "/graph-store?graph=g&default", "text/turtle", turtle, "alice"), http.StatusBadRequest},
+		{graphStoreRequest("PUT", "/graph-store", "text/turtle", turtle, "alice"), http.StatusBadRequest},
+		{graphStoreRequest("PATCH", graph, "text/turtle", turtle, "alice"), http.StatusMethodNotAllowed},
+		{graphStoreRequest("DELETE", graph, "", "", "alice"), http.StatusNoContent},
+		{graphStoreRequest("DELETE", graph, "", "", "alice"), http.StatusNotFound},
+		{graphStoreRequest("PUT", "/graph-store?default", "text/turtle", turtle, "alice"), http.StatusCreated},
+	}
+	for _, test := range requests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, test.r)
+		if w.Code != test.status {
+			t.Errorf("Expected status %d for %s %s, got %d (%s)", test.status, test.r.Method, test.r.URL, w.Code, w.Body.String())
+		}
+		if test.r.Method == "GET" && w.Code == http.StatusOK && !strings.Contains(w.Body.String(), "http://example.org/d") {
+			t.Errorf("Expected the added triple in the graph, got %s", w.Body.String())
+		}
+	}
+	if changes != 6 {
+		t.Errorf("Expected the OnChange functions to be called for 6 changes, got %d", changes)
+	}
+	if quads, _ := s.Describe(context.Background(), "http://example.org/a"); len(quads) != 2 || quads[0].Ctx != nil {
+		t.Errorf("Expected 2 triples in the default graph, got %v", quads)
+	}
+
+	w := httptest.NewRecorder()
+	h.Writers = []string{"bob"}
+	h.ServeHTTP(w, graphStoreRequest("DELETE", "/graph-store?default", "", "", "alice"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a client not in the writers, got %d", w.Code)
+	}
+}
+
+func TestGraphStoreResources(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	h := &GraphStoreHandler{URIHost: "http://example.org", Store: s, Writers: []string{"authenticated"}, MaxUploadSize: 1024}
+	resolved := 0
+	handler := withGraphStore(h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolved++
+	}))
+
+	requests := []struct {
+		r      *http.Request
+		status int
+	}{
+		{graphStoreRequest("PUT", "/a", "text/turtle", "<> <http://example.org/p> <http://example.org/b> .", "alice"), http.StatusCreated},
+		{graphStoreRequest("PUT", "/a", "text/turtle", "<> <http://example.org/p> <http://example.org/c> .", "alice"), http.StatusNoContent},
+		{graphStoreRequest("POST", "/a", "text/turtle", "<> <http://example.org/p> <http://example.org/d> .", "alice"), http.StatusNoContent},
+		{graphStoreRequest("PUT", "/a", "text/turtle", "<http://example.org/b> <http://example.org/p> <http://example.org/c> .", "alice"), http.StatusUnprocessableEntity},
+		{graphStoreRequest("GET", "/a", "", "", ""), http.StatusOK},
+		{graphStoreRequest("DELETE", "/b", "", "", "alice"), http.StatusNotFound},
+	}
+	for _, test := range requests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, test.r)
+		if w.Code != test.status {
+			t.Errorf("Expected status %d for %s %s, got %d (%s)", test.status, test.r.Method, test.r.URL, w.Code, w.Body.String())
+		}
+	}
+	if resolved != 1 {
+		t.Errorf("Expected GET requests to be resolved as before, got %d", resolved)
+	}
+	quads, _ := s.Describe(context.Background(), "http://example.org/a")
+	if len(quads) != 2 || quads[0].Obj.String() != "http://example.org/c" {
+		t.Errorf("Expected the replaced and added triples, got %v", quads)
+	}
+}
+
+func TestSparqlGraphStore(t *testing.T) {
+	var updates, queries []string
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if update := r.PostFormValue("update"); update != "" {
+			updates = append(updates, update)
+			return
+		}
+		queries = append(queries, r.FormValue("query"))
+		w.Header().Set("Content-Type", "application/sparql-results+json")
+		w.Write([]byte(`{"head": {"vars": ["s"]}, "results": {"bindings": []}}`))
+	}))
+	defer endpoint.Close()
+
+	h := &GraphStoreHandler{URIHost: "http://example.org", Store: &SparqlGraphStore{&SparqlSource{endpoint.URL, nil}, endpoint.URL},
+		Writers: []string{"authenticated"}, MaxUploadSize: 1024, MaxGraphTriples: 1000}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("PUT", "/graph-store?graph=http%3A%2F%2Fexample.org%2Fg", "text/turtle", "<http://example.org/a> <http://example.org/p> \"A\"@en .", "alice"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the graph to be created, got %d (%s)", w.Code, w.Body.String())
+	}
+	if len(updates) != 1 || !strings.Contains(updates[0], "DROP SILENT GRAPH <http://example.org/g>") ||
+		!strings.Contains(updates[0], `GRAPH <http://example.org/g> { <http://example.org/a> <http://example.org/p> "A"@en .`) {
+		t.Errorf("Expected the graph to be replaced in one update, got %v", updates)
+	}
+
+	queries = nil
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("GET", "/graph-store?default", "", "", "alice"))
+	if len(queries) != 1 || !strings.HasSuffix(queries[0], "LIMIT 1001") {
+		t.Errorf("Expected the triples of the graph to be limited, got %v", queries)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/knakk/rdf"
+)
+
+// HdtSource resolves URIs based on information in (RDF)HDT dataset files,
+// using the hdtSearch command from the C++ HDT tools. You can find more info
+// about HDT at http://www.rdfhdt.org
+//
+// FilePath is a comma separated list of HDT files, which are queried as one
+// merged dataset. Each item may also be a glob pattern, a symlink to an HDT
+// file, or a directory, in which case the most recently modified .hdt file
+// in it is used. The files are only looked up again when reloaded (see
+// Reload).
+type HdtSource struct {
+	FilePath string
+
+	mu       sync.RWMutex
+	versions []fileVersion // The files in use, once loaded
+
+	typesMu   sync.Mutex
+	typeCache *hdtTypes // The classes in the files in use, once read
+}
+
+// paths returns the paths of the HDT files in use, or (if not loaded yet)
+// those that FilePath currently refers to
+func (s *HdtSource) paths() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.versions) == 0 {
+		if files, err := resolveHdtFiles(s.FilePath); err == nil {
+			return files
+		}
+		return splitList(s.FilePath)
+	}
+	var paths []string
+	for _, v := range s.versions {
+		paths = append(paths, v.Path)
+	}
+	return paths
+}
+
+// currentVersions returns the versions of the HDT files in use
+func (s *HdtSource) currentVersions() []fileVersion {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.versions
+}
+
+// Describe returns the triples with uri as subject or object, in the default
+// graph (HDT files have no named graphs)
+func (s *HdtSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	// Use the same files for both queries, even if they are reloaded in
+	// between
+	paths := s.paths()
+	var triples []rdf.Triple
+	newTriples, err := s.queryHdtFiles(ctx, paths, uri+" ? ?")
+	if err != nil {
+		return nil, err
+	}
+	triples = append(triples, newTriples...)
+	newTriples, err = s.queryHdtFiles(ctx, paths, "? ? "+uri)
+	if err != nil {
+		return nil, err
+	}
+	triples = append(triples, newTriples...)
+	return triplesToQuads(triples), nil
+}
+
+// runHdtQuery runs queries against the HDT files using the hdtSearch
+// command. The hdtSearch processes are killed if ctx is cancelled before they
+// finish.
+func (s *HdtSource) runHdtQuery(ctx context.Context, queries ...string) ([]rdf.Triple, error) {
+	return s.queryHdtFiles(ctx, s.paths(), queries...)
+}
+
+// queryHdtFiles runs queries against all the HDT files at paths
+// concurrently, and returns the distinct triples found in any of them
+func (s *HdtSource) queryHdtFiles(ctx context.Context, paths []string, queries ...string) ([]rdf.Triple, error) {
+	if len(paths) == 1 {
+		return s.queryHdtFile(ctx, paths[0], queries...)
+	}
+	results := make([][]rdf.Triple, len(paths))
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			results[i], errs[i] = s.queryHdtFile(ctx, path, queries...)
+		}(i, path)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	var triples []rdf.Triple
+	for i, result := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for _, t := range result {
+			key := t.Serialize(rdf.NTriples)
+			if !seen[key] {
+				seen[key] = true
+				triples = append(triples, t)
+			}
+		}
+	}
+	return triples, nil
+}
+
+// queryHdtFile runs queries against the HDT file at path. A single query is
+// given with -q, while several are written to the standard input of one
+// hdtSearch process, which runs them one after another, as typed in its
+// interactive mode.
+func (s *HdtSource) queryHdtFile(ctx context.Context, path string, queries ...string) ([]rdf.Triple, error) {
+	var triples []rdf.Triple
+
+	Cmd := exec.CommandContext(ctx, "hdtSearch", "-q", queries[0], path)
+	if len(queries) > 1 {
+		Cmd = exec.CommandContext(ctx, "hdtSearch", path)
+		Cmd.Stdin = strings.NewReader(strings.Join(queries, "\n") + "\nexit\n")
+	}
+	hdtOut, err := Cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(hdtOut), "\n")
+	for _, line := range lines {
+		for _, l := range strings.Split(line, "\r") {
+			// Skip the prompt of the interactive mode
+			l = strings.TrimPrefix(l, ">> ")
+			if len(l) >= 4 && l[0:4] == "http" {
+				triple, err := s.strToTriple(l)
+				if err != nil {
+					return nil, err
+				}
+				triples = append(triples, triple)
+			}
+		}
+	}
+
+	return triples, nil
+}
+
+// countHdtFile counts the results of queries in the HDT file at path, with
+// one hdtSearch process which only measures the queries (-m), reporting the
+// number of results of each on its standard error
+func countHdtFile(ctx context.Context, path string, queries []string) ([]int64, error) {
+	Cmd := exec.CommandContext(ctx, "hdtSearch", "-m", path)
+	Cmd.Stdin = strings.NewReader(strings.Join(queries, "\n") + "\nexit\n")
+	var stderr bytes.Buffer
+	Cmd.Stderr = &stderr
+	if err := Cmd.Run(); err != nil {
+		return nil, err
+	}
+	var counts []int64
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		// Skip the prompts of the interactive mode
+		line = strings.TrimLeft(line, "> ")
+		if i := strings.Index(line, " results in "); i > 0 {
+			if n, err := strconv.ParseInt(line[:i], 10, 64); err == nil {
+				counts = append(counts, n)
+			}
+		}
+	}
+	if len(counts) != len(queries) {
+		return nil, fmt.Errorf("Could not read the number of results from hdtSearch (%d numbers for %d queries)", len(counts), len(queries))
+	}
+	return counts, nil
+}
+
+// strToTriple parses a triple in the output of hdtSearch, where IRIs are
+// written without angle brackets, and literals (which may contain spaces)
+// in N-Triples style
+func (s *HdtSource) strToTriple(line string) (rdf.Triple, error) {
+	terms := strings.SplitN(line, " ", 3)
+	if len(terms) < 3 {
+		return rdf.Triple{}, fmt.Errorf("Could not parse hdtSearch output: %s", line)
+	}
+	sRaw, pRaw, oRaw := terms[0], terms[1], strings.TrimSpace(terms[2])
+
+	subj, err := hdtTerm(sRaw)
+	if err != nil {
+		return rdf.Triple{}, fmt.Errorf("Could not convert subject to IRI: %s (%s)", sRaw, err.Error())
+	}
+	pred, err := rdf.NewIRI(pRaw)
+	if err != nil {
+		return rdf.Triple{}, fmt.Errorf("Could not convert predicate to IRI: %s (%s)", pRaw, err.Error())
+	}
+	obj, err := hdtTerm(oRaw)
+	if err != nil {
+		return rdf.Triple{}, fmt.Errorf("Could not convert object to RDF term: %s (%s)", oRaw, err.Error())
+	}
+	subject, ok := subj.(rdf.Subject)
+	if !ok {
+		return rdf.Triple{}, fmt.Errorf("Invalid subject in hdtSearch output: %s", sRaw)
+	}
+	return rdf.Triple{Subj: subject, Pred: pred, Obj: obj.(rdf.Object)}, nil
+}
+
+// hdtTerm parses an RDF term as written by hdtSearch: a literal, with an
+// optional language tag or datatype, a blank node, or an IRI
+func hdtTerm(raw string) (rdf.Term, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := strings.LastIndex(raw, `"`)
+		if end == 0 {
+			return nil, fmt.Errorf("unterminated literal")
+		}
+		value, suffix := raw[1:end], raw[end+1:]
+		switch {
+		case strings.HasPrefix(suffix, "@"):
+			return rdf.NewLangLiteral(value, suffix[1:])
+		case strings.HasPrefix(suffix, "^^"):
+			dt, err := rdf.NewIRI(strings.TrimSuffix(strings.TrimPrefix(suffix[2:], "<"), ">"))
+			if err != nil {
+				return nil, err
+			}
+			return rdf.NewTypedLiteral(value, dt), nil
+		}
+		return rdf.NewLiteral(value)
+	case strings.HasPrefix(raw, "_:"):
+		return rdf.NewBlank(raw[2:])
+	}
+	return rdf.NewIRI(raw)
+}
+
+// readHdtHeader reads the header of an HDT file, which is stored as
+// N-Triples after the global control information, and usually contains
+// statistics and other metadata about the dataset
+func readHdtHeader(path string) ([]rdf.Triple, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	if _, _, err := readHdtControlInfo(r); err != nil {
+		return nil, fmt.Errorf("Could not read HDT control information from %s (%s)", path, err.Error())
+	}
+	format, properties, err := readHdtControlInfo(r)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read HDT header information from %s (%s)", path, err.Error())
+	}
+	if format != "ntriples" {
+		return nil, fmt.Errorf("Unsupported HDT header format in %s: %s", path, format)
+	}
+	length, err := strconv.ParseInt(properties["length"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid HDT header length in %s (%s)", path, err.Error())
+	}
+	// Check the length before allocating for it, in case the file is
+	// truncated or corrupt
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > info.Size() {
+		return nil, fmt.Errorf("Invalid HDT header length in %s: %d bytes, in a file of %d bytes", path, length, info.Size())
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("Could not read HDT header from %s (%s)", path, err.Error())
+	}
+
+	triples, err := rdf.NewTripleDecoder(bytes.NewReader(data), rdf.NTriples).DecodeAll()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse HDT header of %s (%s)", path, err.Error())
+	}
+	return triples, nil
+}
+
+// readHdtControlInfo reads an HDT control information section: the $HDT
+// cookie, a type byte, a format and a list of key=value; properties (both
+// null terminated), and a CRC16 checksum, which is not verified
+func readHdtControlInfo(r *bufio.Reader) (string, map[string]string, error) {
+	cookie := make([]byte, 5)
+	if _, err := io.ReadFull(r, cookie); err != nil {
+		return "", nil, err
+	}
+	if string(cookie[:4]) != "$HDT" {
+		return "", nil, fmt.Errorf("missing $HDT cookie")
+	}
+	format, err := r.ReadString(0)
+	if err != nil {
+		return "", nil, err
+	}
+	rawProperties, err := r.ReadString(0)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := io.ReadFull(r, make([]byte, 2)); err != nil {
+		return "", nil, err
+	}
+
+	properties := make(map[string]string)
+	for _, property := range strings.Split(strings.TrimSuffix(rawProperties, "\x00"), ";") {
+		if kv := strings.SplitN(property, "=", 2); len(kv) == 2 {
+			properties[kv[0]] = kv[1]
+		}
+	}
+	return strings.TrimSuffix(format, "\x00"), properties, nil
+}
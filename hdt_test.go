@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+// fakeHdtSearch puts a stand-in for hdtSearch first in the PATH, which
+// prints output, and errOutput on its standard error, and appends its
+// arguments and standard input to the calls file returned. The function
+// returned removes it again.
+func fakeHdtSearch(t *testing.T, output string, errOutput string) (string, func()) {
+	dir, err := ioutil.TempDir("", "hdtsearch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\ncat >> " + calls + "\ncat " + filepath.Join(dir, "output") +
+		"\ncat " + filepath.Join(dir, "errors") + " >&2\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "output"), []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "errors"), []byte(errOutput), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "hdtSearch"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return calls, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStrToTriple(t *testing.T) {
+	s := &HdtSource{}
+	lines := map[string]string{
+		`http://rdf.pharmb.io/cplogd/Compound1 http://www.w3.org/1999/02/22-rdf-syntax-ns#type http://rdf.pharmb.io/cplogd/Compound`: `<http://rdf.pharmb.io/cplogd/Compound1> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://rdf.pharmb.io/cplogd/Compound> .`,
+		`http://rdf.pharmb.io/cplogd/Compound1 http://www.w3.org/2000/01/rdf-schema#label "Compound 1"@en`:                           `<http://rdf.pharmb.io/cplogd/Compound1> <http://www.w3.org/2000/01/rdf-schema#label> "Compound 1"@en .`,
+		`http://rdf.pharmb.io/cplogd/Compound1 http://rdf.pharmb.io/cplogd/logD "2.5"^^<http://www.w3.org/2001/XMLSchema#decimal>`:   `<http://rdf.pharmb.io/cplogd/Compound1> <http://rdf.pharmb.io/cplogd/logD> "2.5"^^<http://www.w3.org/2001/XMLSchema#decimal> .`,
+		`http://rdf.pharmb.io/cplogd/Compound1 http://rdf.pharmb.io/cplogd/source _:b1`:                                              `<http://rdf.pharmb.io/cplogd/Compound1> <http://rdf.pharmb.io/cplogd/source> _:b1 .`,
+	}
+	for line, expected := range lines {
+		triple, err := s.strToTriple(line)
+		if err != nil {
+			t.Errorf("Could not parse %s: %v", line, err)
+			continue
+		}
+		if got := triple.Serialize(rdf.NTriples); got != expected+"\n" {
+			t.Errorf("Expected %s to be parsed as %s, got %s", line, expected, got)
+		}
+	}
+	if _, err := s.strToTriple("http://rdf.pharmb.io/cplogd/Compound1"); err == nil {
+		t.Error("Expected an error for a line without a triple")
+	}
+}
+
+func TestReadHdtHeaderInvalidLength(t *testing.T) {
+	data, err := ioutil.ReadFile("example_data.hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "urisolve-hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, length := range []string{"length=-1;", "length=999999999999;"} {
+		path := filepath.Join(dir, "corrupt.hdt")
+		ioutil.WriteFile(path, bytes.Replace(data, []byte("length=1787;"), []byte(length), 1), 0644)
+		if _, err := readHdtHeader(path); err == nil {
+			t.Errorf("Expected an error for a header with %s", length)
+		}
+	}
+}
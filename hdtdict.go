@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// hdtSection is a section of the dictionary of an HDT file
+type hdtSection int
+
+// The sections of a "four section" HDT dictionary, in the order they are
+// stored. The shared section holds the terms used both as subject and object.
+const (
+	hdtShared hdtSection = iota
+	hdtSubjects
+	hdtPredicates
+	hdtObjects
+)
+
+const hdtDictionaryFour = "<http://purl.org/HDT/hdt#dictionaryFour>"
+
+// hdtDictionaryCounts are the number of terms in each dictionary section
+type hdtDictionaryCounts [4]int64
+
+// distinctSubjects returns the number of distinct subjects in the dataset
+func (c hdtDictionaryCounts) distinctSubjects() int64 {
+	return c[hdtShared] + c[hdtSubjects]
+}
+
+// distinctObjects returns the number of distinct objects in the dataset
+func (c hdtDictionaryCounts) distinctObjects() int64 {
+	return c[hdtShared] + c[hdtObjects]
+}
+
+// readHdtDictionary reads the dictionary of the HDT file at path, and returns
+// the number of terms in each section. If visit is not nil, it is called
+// with each term, in the form used by hdtSearch (IRIs without angle
+// brackets). Only plain front coded sections are supported, which is what
+// the HDT tools write by default.
+func readHdtDictionary(path string, visit func(section hdtSection, term string)) (hdtDictionaryCounts, error) {
+	var counts hdtDictionaryCounts
+	f, err := os.Open(path)
+	if err != nil {
+		return counts, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	// Skip the global control information and the header
+	if _, _, err := readHdtControlInfo(r); err != nil {
+		return counts, fmt.Errorf("Could not read HDT control information from %s (%s)", path, err.Error())
+	}
+	_, properties, err := readHdtControlInfo(r)
+	if err != nil {
+		return counts, fmt.Errorf("Could not read HDT header information from %s (%s)", path, err.Error())
+	}
+	headerLength, err := strconv.ParseInt(properties["length"], 10, 64)
+	if err != nil {
+		return counts, fmt.Errorf("Invalid HDT header length in %s (%s)", path, err.Error())
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, headerLength); err != nil {
+		return counts, fmt.Errorf("Could not read HDT header from %s (%s)", path, err.Error())
+	}
+
+	format, _, err := readHdtControlInfo(r)
+	if err != nil {
+		return counts, fmt.Errorf("Could not read HDT dictionary information from %s (%s)", path, err.Error())
+	}
+	if format != hdtDictionaryFour {
+		return counts, fmt.Errorf("Unsupported HDT dictionary format in %s: %s", path, format)
+	}
+	for section := hdtShared; section <= hdtObjects; section++ {
+		var visitSection func(term string)
+		if visit != nil {
+			section := section
+			visitSection = func(term string) {
+				visit(section, term)
+			}
+		}
+		counts[section], err = readPFCSection(r, visitSection)
+		if err != nil {
+			return counts, fmt.Errorf("Could not read HDT dictionary section %d from %s (%s)", section, path, err.Error())
+		}
+	}
+	return counts, nil
+}
+
+// readPFCSection reads a plain front coded dictionary section, calling visit
+// (if not nil) with each of its strings, and returns the number of strings.
+// The strings are stored in blocks, where the first string of each block is
+// stored in full, and the following ones as the length of the prefix shared
+// with the previous string, followed by the rest of the string.
+func readPFCSection(r *bufio.Reader, visit func(term string)) (int64, error) {
+	sectionType, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if sectionType != 2 {
+		return 0, fmt.Errorf("unsupported section type %d", sectionType)
+	}
+	numStrings, err := readVByte(r)
+	if err != nil {
+		return 0, err
+	}
+	numBytes, err := readVByte(r)
+	if err != nil {
+		return 0, err
+	}
+	blockSize, err := readVByte(r)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.ReadByte(); err != nil { // CRC8
+		return 0, err
+	}
+
+	// Skip the block pointers, which are only needed for random access
+	if _, err := r.ReadByte(); err != nil { // Sequence type
+		return 0, err
+	}
+	bits, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	entries, err := readVByte(r)
+	if err != nil {
+		return 0, err
+	}
+	pointerBytes := (int64(bits)*int64(entries) + 7) / 8
+	if _, err := io.CopyN(ioutil.Discard, r, 1+pointerBytes+4); err != nil { // CRC8, data, CRC32
+		return 0, err
+	}
+
+	if visit == nil {
+		_, err := io.CopyN(ioutil.Discard, r, int64(numBytes)+4) // Data, CRC32
+		return int64(numStrings), err
+	}
+	data := make([]byte, numBytes+4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, err
+	}
+	data = data[:numBytes]
+
+	var previous []byte
+	for i := uint64(0); i < numStrings; i++ {
+		var term []byte
+		if blockSize == 0 || i%blockSize == 0 {
+			end := bytes.IndexByte(data, 0)
+			if end < 0 {
+				return 0, fmt.Errorf("unterminated string")
+			}
+			term, data = data[:end], data[end+1:]
+		} else {
+			shared, n := decodeVByte(data)
+			if n == 0 || shared > uint64(len(previous)) {
+				return 0, fmt.Errorf("invalid shared prefix length")
+			}
+			data = data[n:]
+			end := bytes.IndexByte(data, 0)
+			if end < 0 {
+				return 0, fmt.Errorf("unterminated string")
+			}
+			term = append(append([]byte{}, previous[:shared]...), data[:end]...)
+			data = data[end+1:]
+		}
+		visit(string(term))
+		previous = term
+	}
+	return int64(numStrings), nil
+}
+
+// readVByte reads a variable length integer, stored in groups of 7 bits,
+// least significant first, with the high bit set in the last byte
+func readVByte(r *bufio.Reader) (uint64, error) {
+	var value uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 != 0 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("variable length integer too long")
+}
+
+// decodeVByte decodes a variable length integer (see readVByte) from the
+// start of data, returning it and the number of bytes read (0 if invalid)
+func decodeVByte(data []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		value |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i]&0x80 != 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
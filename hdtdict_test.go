@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestReadHdtDictionary(t *testing.T) {
+	var terms [4][]string
+	counts, err := readHdtDictionary("example_data.hdt", func(section hdtSection, term string) {
+		terms[section] = append(terms[section], term)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header of the example data states 51 distinct subjects, 88
+	// distinct objects and 9 properties
+	if counts.distinctSubjects() != 51 || counts.distinctObjects() != 88 || counts[hdtPredicates] != 9 {
+		t.Errorf("Unexpected dictionary counts: %v", counts)
+	}
+	for section, sectionTerms := range terms {
+		if int64(len(sectionTerms)) != counts[section] {
+			t.Errorf("Expected %d terms in section %d, got %d", counts[section], section, len(sectionTerms))
+		}
+	}
+	if terms[hdtSubjects][0] != "http://rdf.pharmb.io/cplogd/Compound1" {
+		t.Errorf("Expected Compound1 to be the first subject, got %s", terms[hdtSubjects][0])
+	}
+	if terms[hdtObjects][0] != `"-0.294"^^<x:float>` {
+		t.Errorf("Expected the first object to be a literal, got %s", terms[hdtObjects][0])
+	}
+}
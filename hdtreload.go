@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hdtIndexSuffix is appended to the path of an HDT file to get the path of
+// the index file, which hdtSearch creates (or reads) next to it
+const hdtIndexSuffix = ".index.v1-1"
+
+// fileVersion identifies a version of a data file, and of its index file for
+// HDT files (where the index size is -1 if there is no index)
+type fileVersion struct {
+	Path         string
+	Size         int64
+	ModTime      time.Time
+	IndexSize    int64
+	IndexModTime time.Time
+}
+
+// resolveHdtFile returns the HDT file that path refers to: path itself, or
+// the file a symlink at path points to, or (if path is a directory) the most
+// recently modified .hdt file in it
+func resolveHdtFile(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return resolved, nil
+	}
+
+	files, err := ioutil.ReadDir(resolved)
+	if err != nil {
+		return "", err
+	}
+	var newest os.FileInfo
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".hdt") {
+			continue
+		}
+		if newest == nil || f.ModTime().After(newest.ModTime()) {
+			newest = f
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("No .hdt files in %s", resolved)
+	}
+	return filepath.Join(resolved, newest.Name()), nil
+}
+
+// resolveHdtFiles returns the HDT files that the comma separated list of
+// paths refers to, where each item may be a glob pattern, or a path to
+// resolve with resolveHdtFile
+func resolveHdtFiles(paths string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range splitList(paths) {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern %s (%s)", pattern, err.Error())
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("No files match %s", pattern)
+			}
+		}
+		for _, match := range matches {
+			if strings.HasSuffix(match, hdtIndexSuffix) {
+				continue
+			}
+			file, err := resolveHdtFile(match)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No HDT files given")
+	}
+	return files, nil
+}
+
+// statFiles returns the current versions of files, with their HDT index
+// files, if any
+func statFiles(files []string) ([]fileVersion, error) {
+	var versions []fileVersion
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		version := fileVersion{file, info.Size(), info.ModTime(), -1, time.Time{}}
+		if indexInfo, err := os.Stat(file + hdtIndexSuffix); err == nil {
+			version.IndexSize, version.IndexModTime = indexInfo.Size(), indexInfo.ModTime()
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// statFiles returns the current versions of the HDT files that FilePath
+// refers to (see resolveHdtFiles)
+func (s *HdtSource) statFiles() ([]fileVersion, error) {
+	files, err := resolveHdtFiles(s.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	return statFiles(files)
+}
+
+// sameHdtFiles returns true if a and b are the same versions of the same
+// files. If ignoreNewIndexes, files which did not have an index in a are
+// considered the same if only their index has changed.
+func sameHdtFiles(a []fileVersion, b []fileVersion, ignoreNewIndexes bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if !ignoreNewIndexes || a[i].IndexSize >= 0 || a[i].Path != b[i].Path || a[i].Size != b[i].Size || !a[i].ModTime.Equal(b[i].ModTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateHdtFile makes sure that the HDT file at path (and its index, if
+// requireIndex) can be used, reading the whole dictionary of the HDT file to
+// catch files which are not completely written. An index older than the HDT
+// file is left from a previous version of it (e.g. when a new file is copied
+// over the old one), so the file is not used until its new index is written.
+func validateHdtFile(path string, requireIndex bool) error {
+	if err := checkHdtFile(path); err != nil {
+		return err
+	}
+	if requireIndex {
+		if err := checkHdtFile(path + hdtIndexSuffix); err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		indexInfo, err := os.Stat(path + hdtIndexSuffix)
+		if err != nil {
+			return err
+		}
+		if indexInfo.ModTime().Before(info.ModTime()) {
+			return fmt.Errorf("The index %s is older than the HDT file, so it is for a previous version of it. Create a new index with hdtSearch", path+hdtIndexSuffix)
+		}
+	}
+	_, err := readHdtDictionary(path, nil)
+	return err
+}
+
+// Reload switches to the HDT files that FilePath refers to, if any of them
+// have changed since they were loaded, and all can be validated. Queries
+// already running keep using the previous files. It returns true if the
+// files were switched.
+//
+// When first loaded, the index files may be missing (hdtSearch then creates
+// them), but new versions of the files are only switched to once their
+// indexes are in place, since creating them can take long.
+func (s *HdtSource) Reload() (bool, error) {
+	versions, err := s.statFiles()
+	if err != nil {
+		return false, err
+	}
+	current := s.currentVersions()
+	if sameHdtFiles(current, versions, true) {
+		// Keep track of indexes created by hdtSearch for the files in use
+		s.mu.Lock()
+		s.versions = versions
+		s.mu.Unlock()
+		return false, nil
+	}
+	loaded := make(map[fileVersion]bool)
+	for _, v := range current {
+		loaded[v] = true
+	}
+	for _, v := range versions {
+		if loaded[v] {
+			continue
+		}
+		if err := validateHdtFile(v.Path, len(current) > 0); err != nil {
+			return false, fmt.Errorf("Invalid HDT file %s (%s)", v.Path, err.Error())
+		}
+	}
+
+	s.mu.Lock()
+	s.versions = versions
+	s.mu.Unlock()
+	return true, nil
+}
+
+// ReloadableSource is implemented by sources reading their data from files,
+// which can switch to new versions of them
+type ReloadableSource interface {
+	// Reload switches to the current versions of the files, if they have
+	// changed, returning true if it did
+	Reload() (bool, error)
+	// statFiles returns the current versions of the files
+	statFiles() ([]fileVersion, error)
+	// currentVersions returns the versions of the files in use
+	currentVersions() []fileVersion
+	// paths returns the paths of the files in use
+	paths() []string
+}
+
+// Reloader reloads a source, either when asked to (at /admin/reload, by the
+// authenticated clients in Admins, or with SIGHUP), or when its files have
+// changed. After the source has switched to new files, the OnReload functions
+// are called, to rebuild anything computed from the previous ones.
+type Reloader struct {
+	Source   ReloadableSource
+	OnReload []func()
+	Auth     *Authenticator
+	Admins   []string
+
+	mu sync.Mutex // Only one reload at a time
+}
+
+// allowAdmin checks that the client may reload the files, answering 401 or
+// 403 if not. Without an Authenticator, nobody may, and the files can only be
+// reloaded with SIGHUP.
+func (r *Reloader) allowAdmin(w http.ResponseWriter, req *http.Request) bool {
+	if r.Auth == nil {
+		http.Error(w, "Error: Reloading over HTTP requires authentication (see -auth-rules and -admin-users), send SIGHUP to the process instead", http.StatusForbidden)
+		return false
+	}
+	p := principalFromContext(req.Context())
+	if p == nil {
+		r.Auth.challenge(w)
+		http.Error(w, "Error: Authentication required to reload the data files", http.StatusUnauthorized)
+		return false
+	}
+	if !(&AccessRule{Allowed: r.Admins}).allows(p) {
+		http.Error(w, "Error: Reloading the data files is not allowed for "+p.Name, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// reload reloads the source, and calls the OnReload functions if it
+// switched to new files
+func (r *Reloader) reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reloaded, err := r.Source.Reload()
+	if err != nil || !reloaded {
+		return reloaded, err
+	}
+	log.Println("Switched to the data files: " + strings.Join(r.Source.paths(), ", "))
+	for _, f := range r.OnReload {
+		f()
+	}
+	return true, nil
+}
+
+// watch checks the files for changes every interval, and reloads them
+// when they have changed, and then stayed the same for one interval (so that
+// files being copied or written are not loaded half way)
+func (r *Reloader) watch(interval time.Duration) {
+	var previous, failed []fileVersion
+	for range time.Tick(interval) {
+		versions, err := r.Source.statFiles()
+		if err != nil {
+			continue
+		}
+		if sameHdtFiles(versions, previous, false) && !sameHdtFiles(versions, failed, false) && !sameHdtFiles(r.Source.currentVersions(), versions, true) {
+			if _, err := r.reload(); err != nil {
+				// Don't try the same files again until they change
+				failed = versions
+				log.Println("Could not reload the data files, keeping the current ones: " + err.Error())
+			}
+		}
+		previous = versions
+	}
+}
+
+// reloadOnSIGHUP reloads the files whenever the process gets SIGHUP
+func (r *Reloader) reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		log.Println("Received SIGHUP, reloading the data files ...")
+		if _, err := r.reload(); err != nil {
+			log.Println("Could not reload the data files, keeping the current ones: " + err.Error())
+		}
+	}
+}
+
+// ServeHTTP reloads the files on POST requests (at /admin/reload) from
+// clients in Admins, reporting the files in use as JSON
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Error: Use POST to reload the data files", http.StatusMethodNotAllowed)
+		return
+	}
+	if !r.allowAdmin(w, req) {
+		return
+	}
+	reloaded, err := r.reload()
+	if err != nil {
+		http.Error(w, "Error: Could not reload the data files, keeping the current ones ("+err.Error()+")", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Reloaded bool     `json:"reloaded"`
+		Files    []string `json:"files"`
+	}{reloaded, r.Source.paths()})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestHdtFile writes a copy of example_data.hdt (truncated to size
+// bytes, if positive) and an index file to path
+func writeTestHdtFile(t *testing.T, path string, size int) {
+	data, err := ioutil.ReadFile("example_data.hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size > 0 {
+		data = data[:size]
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+hdtIndexSuffix, []byte("$HDT index"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHdtSourceReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve-hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first.hdt")
+	second := filepath.Join(dir, "second.hdt")
+	broken := filepath.Join(dir, "broken.hdt")
+	writeTestHdtFile(t, first, 0)
+	writeTestHdtFile(t, second, 0)
+	writeTestHdtFile(t, broken, 500)
+	link := filepath.Join(dir, "current.hdt")
+	if err := os.Symlink(first, link); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &HdtSource{FilePath: link}
+	if reloaded, err := s.Reload(); err != nil || !reloaded || !strings.HasSuffix(s.paths()[0], "first.hdt") {
+		t.Fatalf("Expected first.hdt to be loaded, got %s (%v)", s.paths()[0], err)
+	}
+	if reloaded, err := s.Reload(); err != nil || reloaded {
+		t.Errorf("Expected no reload of an unchanged file, got %v (%v)", reloaded, err)
+	}
+
+	switchLink := func(target string) {
+		os.Remove(link)
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	switchLink(broken)
+	if _, err := s.Reload(); err == nil || !strings.HasSuffix(s.paths()[0], "first.hdt") {
+		t.Errorf("Expected the broken file to be rejected, got %s (%v)", s.paths()[0], err)
+	}
+
+	// A new file with the index of the previous one is not used
+	stale := filepath.Join(dir, "stale.hdt")
+	writeTestHdtFile(t, stale, 0)
+	os.Chtimes(stale+hdtIndexSuffix, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	switchLink(stale)
+	if _, err := s.Reload(); err == nil || !strings.Contains(err.Error(), "older than the HDT file") {
+		t.Errorf("Expected the file with an old index to be rejected, got %s (%v)", s.paths()[0], err)
+	}
+
+	switchLink(second)
+	resets := 0
+	reloader := &Reloader{Source: s, OnReload: []func(){func() { resets++ }}}
+	rec := httptest.NewRecorder()
+	reloader.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to not be allowed, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	reloader.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/reload", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected reloading over HTTP not to be allowed without authentication, got %d", rec.Code)
+	}
+
+	reloader.Auth = &Authenticator{APIKeys: map[string]string{"key-of-alice": "alice", "key-of-bob": "bob"}}
+	reloader.Admins = []string{"alice"}
+	handler := withAuth(reloader.Auth, reloader)
+	for key, status := range map[string]int{"": http.StatusUnauthorized, "key-of-bob": http.StatusForbidden} {
+		r := httptest.NewRequest("POST", "/admin/reload", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != status {
+			t.Errorf("Expected %d for key %q, got %d", status, key, rec.Code)
+		}
+	}
+	if resets != 0 || !strings.HasSuffix(s.paths()[0], "first.hdt") {
+		t.Errorf("Expected no reload for clients other than the admins")
+	}
+	r := httptest.NewRequest("POST", "/admin/reload", nil)
+	r.Header.Set("X-API-Key", "key-of-alice")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"reloaded":true`) || !strings.HasSuffix(s.paths()[0], "second.hdt") {
+		t.Errorf("Expected a switch to second.hdt, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if resets != 1 {
+		t.Errorf("Expected the OnReload functions to be called once, got %d", resets)
+	}
+}
+
+func TestResolveHdtFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve-hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := resolveHdtFile(dir); err == nil {
+		t.Error("Expected an error for a directory without HDT files")
+	}
+
+	older := filepath.Join(dir, "2020-01-01.hdt")
+	newer := filepath.Join(dir, "2020-01-02.hdt")
+	writeTestHdtFile(t, older, 0)
+	writeTestHdtFile(t, newer, 0)
+	os.Chtimes(older, time.Now(), time.Now().Add(-time.Hour))
+	path, err := resolveHdtFile(dir)
+	if err != nil || path != newer {
+		t.Errorf("Expected the newest file %s, got %s (%v)", newer, path, err)
+	}
+}
+
+func TestResolveHdtFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve-hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.hdt")
+	b := filepath.Join(dir, "b.hdt")
+	writeTestHdtFile(t, a, 0)
+	writeTestHdtFile(t, b, 0)
+
+	files, err := resolveHdtFiles(filepath.Join(dir, "*") + ", " + a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{a, b}) {
+		t.Errorf("Expected %v, got %v", []string{a, b}, files)
+	}
+	if _, err := resolveHdtFiles(filepath.Join(dir, "*.missing")); err == nil {
+		t.Error("Expected an error for a pattern without matches")
+	}
+
+	s := &HdtSource{FilePath: filepath.Join(dir, "*.hdt")}
+	if _, err := s.Reload(); err != nil || len(s.paths()) != 2 {
+		t.Errorf("Expected two files to be loaded, got %v (%v)", s.paths(), err)
+	}
+	index, err := newHdtSearchIndex(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := newHdtSearchIndex(&HdtSource{FilePath: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.literals) != len(single.literals) {
+		t.Errorf("Expected the literals of identical files to be indexed once, got %d instead of %d", len(index.literals), len(single.literals))
+	}
+}
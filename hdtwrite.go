@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+const (
+	hdtNamespace       = "http://purl.org/HDT/hdt#"
+	hdtFormatV1        = "<http://purl.org/HDT/hdt#HDTv1>"
+	hdtTriplesBitmap   = "<http://purl.org/HDT/hdt#triplesBitmap>"
+	hdtIndexFoQ        = "<http://purl.org/HDT/hdt#indexFoQ>"
+	hdtPFCBlockSize    = 16
+	hdtOrderSPO        = 1
+	dctermsFormat      = "http://purl.org/dc/terms/format"
+	dctermsIssued      = "http://purl.org/dc/terms/issued"
+	hdtControlGlobal   = 1
+	hdtControlHeader   = 2
+	hdtControlDict     = 3
+	hdtControlTriples  = 4
+	hdtControlIndex    = 5
+	hdtSequenceLog     = 1
+	hdtBitmapPlain     = 1
+	hdtSectionPFC      = 2
+	hdtMaxTemporaryIDs = 1<<31 - 1
+)
+
+// hdtDataset is an RDF graph encoded for an HDT file: the terms of each
+// section of a "four section" dictionary, sorted, and the distinct triples,
+// as IDs in those sections, sorted by subject, predicate and object.
+// Subjects and objects in the shared section have the same ID, 1 to the
+// number of shared terms; the other subjects and objects are numbered from
+// there. Predicates are numbered from 1.
+type hdtDataset struct {
+	sections [4][]string
+	triples  [][3]uint64
+}
+
+// hdtTermString returns term in the form it is stored in HDT dictionaries:
+// IRIs without angle brackets, blank nodes with their _: prefix, and
+// literals with their (unescaped) value in double quotes, followed by the
+// language tag or datatype, if any
+func hdtTermString(term rdf.Term) string {
+	switch t := term.(type) {
+	case rdf.Literal:
+		value := `"` + t.String() + `"`
+		if t.Lang() != "" {
+			return value + "@" + t.Lang()
+		}
+		if dt := t.DataType.String(); dt != xsdString && dt != rdfLangStr {
+			return value + "^^<" + dt + ">"
+		}
+		return value
+	case rdf.Blank:
+		return term.Serialize(rdf.NTriples)
+	}
+	return term.String()
+}
+
+// hdtDatasetBuilder collects triples for an hdtDataset
+type hdtDatasetBuilder struct {
+	ids     map[string]int32 // Term -> temporary ID
+	terms   []string         // Temporary ID -> term
+	roles   []uint8          // Temporary ID -> how the term is used
+	triples [][3]int32       // Triples of temporary IDs
+}
+
+// Roles of the terms in hdtDatasetBuilder
+const (
+	roleSubject uint8 = 1 << iota
+	rolePredicate
+	roleObject
+)
+
+func newHdtDatasetBuilder() *hdtDatasetBuilder {
+	return &hdtDatasetBuilder{ids: make(map[string]int32)}
+}
+
+// id returns the temporary ID of term, with role added to its roles
+func (b *hdtDatasetBuilder) id(term rdf.Term, role uint8) (int32, error) {
+	s := hdtTermString(term)
+	id, ok := b.ids[s]
+	if !ok {
+		if len(b.terms) >= hdtMaxTemporaryIDs {
+			return 0, fmt.Errorf("Too many distinct terms")
+		}
+		id = int32(len(b.terms))
+		b.ids[s] = id
+		b.terms = append(b.terms, s)
+		b.roles = append(b.roles, 0)
+	}
+	b.roles[id] |= role
+	return id, nil
+}
+
+// add adds t to the dataset
+func (b *hdtDatasetBuilder) add(t rdf.Triple) error {
+	s, err := b.id(t.Subj, roleSubject)
+	if err != nil {
+		return err
+	}
+	p, err := b.id(t.Pred, rolePredicate)
+	if err != nil {
+		return err
+	}
+	o, err := b.id(t.Obj, roleObject)
+	if err != nil {
+		return err
+	}
+	b.triples = append(b.triples, [3]int32{s, p, o})
+	return nil
+}
+
+// dataset sorts the terms into dictionary sections, and the triples in SPO
+// order, without duplicates
+func (b *hdtDatasetBuilder) dataset() *hdtDataset {
+	var d hdtDataset
+	for id, term := range b.terms {
+		role := b.roles[id]
+		if role&roleSubject != 0 && role&roleObject != 0 {
+			d.sections[hdtShared] = append(d.sections[hdtShared], term)
+		} else if role&roleSubject != 0 {
+			d.sections[hdtSubjects] = append(d.sections[hdtSubjects], term)
+		} else if role&roleObject != 0 {
+			d.sections[hdtObjects] = append(d.sections[hdtObjects], term)
+		}
+		if role&rolePredicate != 0 {
+			d.sections[hdtPredicates] = append(d.sections[hdtPredicates], term)
+		}
+	}
+	for _, section := range d.sections {
+		sort.Strings(section)
+	}
+
+	// Map the temporary IDs to the IDs in the dictionary
+	subjectIDs := make(map[string]uint64)
+	objectIDs := make(map[string]uint64)
+	predicateIDs := make(map[string]uint64)
+	numShared := uint64(len(d.sections[hdtShared]))
+	for i, term := range d.sections[hdtShared] {
+		subjectIDs[term] = uint64(i) + 1
+		objectIDs[term] = uint64(i) + 1
+	}
+	for i, term := range d.sections[hdtSubjects] {
+		subjectIDs[term] = numShared + uint64(i) + 1
+	}
+	for i, term := range d.sections[hdtObjects] {
+		objectIDs[term] = numShared + uint64(i) + 1
+	}
+	for i, term := range d.sections[hdtPredicates] {
+		predicateIDs[term] = uint64(i) + 1
+	}
+
+	d.triples = make([][3]uint64, len(b.triples))
+	for i, t := range b.triples {
+		d.triples[i] = [3]uint64{subjectIDs[b.terms[t[0]]], predicateIDs[b.terms[t[1]]], objectIDs[b.terms[t[2]]]}
+	}
+	sort.Slice(d.triples, func(i, j int) bool {
+		a, b := d.triples[i], d.triples[j]
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	distinct := d.triples[:0]
+	for i, t := range d.triples {
+		if i == 0 || t != d.triples[i-1] {
+			distinct = append(distinct, t)
+		}
+	}
+	d.triples = distinct
+	return &d
+}
+
+// sizeStrings returns the total size of the terms in the dictionary
+func (d *hdtDataset) sizeStrings() int {
+	size := 0
+	for _, section := range d.sections {
+		for _, term := range section {
+			size += len(term)
+		}
+	}
+	return size
+}
+
+// hdtBitmapTriples are the triples of an hdtDataset in the "bitmap triples"
+// layout: arrayY has the predicates of each distinct (subject, predicate)
+// pair, and bitmapY marks the last pair of each subject. arrayZ has the
+// objects of each triple, and bitmapZ marks the last object of each pair.
+type hdtBitmapTriples struct {
+	arrayY, arrayZ   []uint64
+	bitmapY, bitmapZ []bool
+}
+
+func (d *hdtDataset) bitmapTriples() *hdtBitmapTriples {
+	bt := &hdtBitmapTriples{}
+	for i, t := range d.triples {
+		if i == 0 || t[0] != d.triples[i-1][0] || t[1] != d.triples[i-1][1] {
+			if i > 0 {
+				bt.bitmapY = append(bt.bitmapY, t[0] != d.triples[i-1][0])
+				bt.bitmapZ[len(bt.bitmapZ)-1] = true
+			}
+			bt.arrayY = append(bt.arrayY, t[1])
+		}
+		bt.arrayZ = append(bt.arrayZ, t[2])
+		bt.bitmapZ = append(bt.bitmapZ, false)
+	}
+	if len(d.triples) > 0 {
+		bt.bitmapY = append(bt.bitmapY, true)
+		bt.bitmapZ[len(bt.bitmapZ)-1] = true
+	}
+	return bt
+}
+
+// writeHdt writes d as an HDT file to w, with a header describing the
+// dataset with baseIRI as subject
+func writeHdt(w io.Writer, d *hdtDataset, baseIRI string, originalSize int64, issued time.Time) error {
+	var body bytes.Buffer
+	writeHdtControlInfo(&body, hdtControlDict, hdtDictionaryFour, fmt.Sprintf("mapping=1;sizeStrings=%d;", d.sizeStrings()))
+	for _, section := range d.sections {
+		writePFCSection(&body, section, hdtPFCBlockSize)
+	}
+	bt := d.bitmapTriples()
+	writeHdtControlInfo(&body, hdtControlTriples, hdtTriplesBitmap, fmt.Sprintf("order=%d;", hdtOrderSPO))
+	writeHdtBitmap(&body, bt.bitmapY)
+	writeHdtBitmap(&body, bt.bitmapZ)
+	writeHdtLogSequence(&body, bitsFor(maxValue(bt.arrayY)), bt.arrayY)
+	writeHdtLogSequence(&body, bitsFor(uint64(len(bt.arrayZ))), bt.arrayZ)
+
+	header := d.header(baseIRI, originalSize, int64(body.Len()), issued)
+	out := bufio.NewWriter(w)
+	writeHdtControlInfo(out, hdtControlGlobal, hdtFormatV1, "")
+	writeHdtControlInfo(out, hdtControlHeader, "ntriples", fmt.Sprintf("length=%d;", len(header)))
+	out.WriteString(header)
+	out.Write(body.Bytes())
+	return out.Flush()
+}
+
+// header returns the HDT header of d, as N-Triples
+func (d *hdtDataset) header(baseIRI string, originalSize int64, hdtSize int64, issued time.Time) string {
+	var buf bytes.Buffer
+	add := func(subj string, pred string, obj string) {
+		fmt.Fprintf(&buf, "%s <%s> %s .\n", subj, pred, obj)
+	}
+	iri := func(s string) string {
+		return "<" + s + ">"
+	}
+	literal := func(n interface{}) string {
+		return strconv.Quote(fmt.Sprint(n))
+	}
+	base := iri(baseIRI)
+	add(base, rdfType, iri(hdtNamespace+"Dataset"))
+	add(base, rdfType, iri(voidNamespace+"Dataset"))
+	add(base, voidNamespace+"triples", literal(len(d.triples)))
+	add(base, voidNamespace+"properties", literal(len(d.sections[hdtPredicates])))
+	add(base, voidNamespace+"distinctSubjects", literal(len(d.sections[hdtShared])+len(d.sections[hdtSubjects])))
+	add(base, voidNamespace+"distinctObjects", literal(len(d.sections[hdtShared])+len(d.sections[hdtObjects])))
+	add(base, hdtNamespace+"statisticalInformation", "_:statistics")
+	add(base, hdtNamespace+"publicationInformation", "_:publicationInformation")
+	add(base, hdtNamespace+"formatInformation", "_:format")
+	add("_:format", hdtNamespace+"dictionary", "_:dictionary")
+	add("_:format", hdtNamespace+"triples", "_:triples")
+	add("_:dictionary", dctermsFormat, hdtDictionaryFour)
+	add("_:dictionary", hdtNamespace+"dictionarynumSharedSubjectObject", literal(len(d.sections[hdtShared])))
+	add("_:dictionary", hdtNamespace+"dictionarymapping", literal(1))
+	add("_:dictionary", hdtNamespace+"dictionarysizeStrings", literal(d.sizeStrings()))
+	add("_:dictionary", hdtNamespace+"dictionaryblockSize", literal(hdtPFCBlockSize))
+	add("_:triples", dctermsFormat, hdtTriplesBitmap)
+	add("_:triples", hdtNamespace+"triplesnumTriples", literal(len(d.triples)))
+	add("_:triples", hdtNamespace+"triplesOrder", literal("SPO"))
+	add("_:statistics", hdtNamespace+"originalSize", literal(originalSize))
+	add("_:statistics", hdtNamespace+"hdtSize", literal(hdtSize))
+	add("_:publicationInformation", dctermsIssued, literal(issued.Format("2006-01-02T15:04:05-0700")))
+	return buf.String()
+}
+
+// writeHdtIndex writes the index which hdtSearch uses to look up triples by
+// object or predicate: for each object, the positions in arrayY of the
+// pairs having it, ordered by predicate, and for each predicate, the
+// positions in arrayY where it is used, and the number of them
+func writeHdtIndex(w io.Writer, d *hdtDataset) error {
+	bt := d.bitmapTriples()
+
+	// The position in arrayY of the pair of each triple
+	pairOf := make([]uint64, len(bt.arrayZ))
+	pair := uint64(0)
+	for i := range bt.arrayZ {
+		pairOf[i] = pair
+		if bt.bitmapZ[i] {
+			pair++
+		}
+	}
+	numObjects := len(d.sections[hdtShared]) + len(d.sections[hdtObjects])
+	byObject := make([][]uint64, numObjects+1)
+	for i, o := range bt.arrayZ {
+		byObject[o] = append(byObject[o], pairOf[i])
+	}
+	var objectIndex []uint64
+	var objectBitmap []bool
+	for _, pairs := range byObject {
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return bt.arrayY[pairs[i]] < bt.arrayY[pairs[j]]
+		})
+		for i, p := range pairs {
+			objectIndex = append(objectIndex, p)
+			objectBitmap = append(objectBitmap, i == len(pairs)-1)
+		}
+	}
+
+	byPredicate := make([][]uint64, len(d.sections[hdtPredicates])+1)
+	for i, p := range bt.arrayY {
+		byPredicate[p] = append(byPredicate[p], uint64(i))
+	}
+	var predicateIndex, predicateCounts []uint64
+	var predicateBitmap []bool
+	for _, pairs := range byPredicate[1:] {
+		for i, p := range pairs {
+			predicateIndex = append(predicateIndex, p)
+			predicateBitmap = append(predicateBitmap, i == len(pairs)-1)
+		}
+		predicateCounts = append(predicateCounts, uint64(len(pairs)))
+	}
+
+	out := bufio.NewWriter(w)
+	writeHdtControlInfo(out, hdtControlIndex, hdtIndexFoQ, fmt.Sprintf("numTriples=%d;order=%d;", len(d.triples), hdtOrderSPO))
+	writeHdtBitmap(out, objectBitmap)
+	writeHdtLogSequence(out, bitsFor(uint64(len(bt.arrayY))), objectIndex)
+	writeHdtBitmap(out, predicateBitmap)
+	writeHdtLogSequence(out, bitsFor(uint64(len(bt.arrayY))), predicateIndex)
+	writeHdtLogSequence(out, bitsFor(maxValue(predicateCounts)), predicateCounts)
+	return out.Flush()
+}
+
+// writeHdtControlInfo writes an HDT control information section (see
+// readHdtControlInfo)
+func writeHdtControlInfo(w io.Writer, controlType byte, format string, properties string) {
+	var buf bytes.Buffer
+	buf.WriteString("$HDT")
+	buf.WriteByte(controlType)
+	buf.WriteString(format)
+	buf.WriteByte(0)
+	buf.WriteString(properties)
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.LittleEndian, crc16(buf.Bytes()))
+	w.Write(buf.Bytes())
+}
+
+// writePFCSection writes a plain front coded dictionary section with terms,
+// in blocks of blockSize (see readPFCSection)
+func writePFCSection(w io.Writer, terms []string, blockSize int) {
+	var data bytes.Buffer
+	var blocks []uint64
+	for i, term := range terms {
+		if i%blockSize == 0 {
+			blocks = append(blocks, uint64(data.Len()))
+			data.WriteString(term)
+		} else {
+			previous := terms[i-1]
+			shared := 0
+			for shared < len(term) && shared < len(previous) && term[shared] == previous[shared] {
+				shared++
+			}
+			data.Write(appendVByte(nil, uint64(shared)))
+			data.WriteString(term[shared:])
+		}
+		data.WriteByte(0)
+	}
+	blocks = append(blocks, uint64(data.Len()))
+
+	preamble := []byte{hdtSectionPFC}
+	preamble = appendVByte(preamble, uint64(len(terms)))
+	preamble = appendVByte(preamble, uint64(data.Len()))
+	preamble = appendVByte(preamble, uint64(blockSize))
+	w.Write(append(preamble, crc8(preamble)))
+	writeHdtLogSequence(w, bitsFor(uint64(data.Len())), blocks)
+	writeWithCRC32(w, data.Bytes())
+}
+
+// writeHdtBitmap writes a bitmap, with the bits in little endian order
+func writeHdtBitmap(w io.Writer, bits []bool) {
+	preamble := appendVByte([]byte{hdtBitmapPlain}, uint64(len(bits)))
+	w.Write(append(preamble, crc8(preamble)))
+	data := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	writeWithCRC32(w, data)
+}
+
+// writeHdtLogSequence writes values as a sequence of numBits bit integers,
+// packed in little endian order
+func writeHdtLogSequence(w io.Writer, numBits uint, values []uint64) {
+	preamble := appendVByte([]byte{hdtSequenceLog, byte(numBits)}, uint64(len(values)))
+	w.Write(append(preamble, crc8(preamble)))
+	data := make([]byte, (uint64(numBits)*uint64(len(values))+7)/8)
+	for i, v := range values {
+		bit := uint64(i) * uint64(numBits)
+		for b := uint(0); b < numBits; b++ {
+			if v&(1<<b) != 0 {
+				data[(bit+uint64(b))/8] |= 1 << ((bit + uint64(b)) % 8)
+			}
+		}
+	}
+	writeWithCRC32(w, data)
+}
+
+// writeWithCRC32 writes data followed by its CRC32-C checksum
+func writeWithCRC32(w io.Writer, data []byte) {
+	w.Write(data)
+	binary.Write(w, binary.LittleEndian, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+}
+
+// appendVByte appends v as a variable length integer (see readVByte)
+func appendVByte(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v&0x7f))
+		v >>= 7
+	}
+	return append(buf, byte(v)|0x80)
+}
+
+// bitsFor returns the number of bits needed to store n
+func bitsFor(n uint64) uint {
+	bits := uint(0)
+	for ; n > 0; n >>= 1 {
+		bits++
+	}
+	return bits
+}
+
+func maxValue(values []uint64) uint64 {
+	max := uint64(0)
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// crc8 computes the CRC-8 (polynomial 0x07) checksum used by HDT
+func crc8(data []byte) byte {
+	crc := byte(0)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16 computes the CRC-16 (ANSI, reflected) checksum used by HDT
+func crc16(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
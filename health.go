@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HealthCheck is the outcome of a single check of a data source, as reported
+// by the readiness endpoint. Failed Optional checks are reported, but do not
+// make the source unavailable.
+type HealthCheck struct {
+	Name     string `json:"name"`
+	Ok       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// ReadinessChecker is implemented by sources which can verify that they are
+// able to answer queries.
+type ReadinessChecker interface {
+	CheckReady(ctx context.Context) []HealthCheck
+}
+
+// healthStatus is the JSON document written by the health endpoints
+type healthStatus struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthzHandler reports that the process is alive and serving HTTP. It does
+// not look at the data source, so that a slow or unavailable backend does not
+// get the pod restarted (use ReadyzHandler for that).
+type HealthzHandler struct{}
+
+func (h *HealthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeHealthStatus(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// ReadyzHandler reports whether the data source behind Checker is available,
+// returning 503 Service Unavailable if any of the checks which are not
+// optional fail, or do not finish within Timeout. If only optional checks
+// fail, the status is degraded, but 200 OK is returned.
+type ReadyzHandler struct {
+	Checker ReadinessChecker
+	Timeout time.Duration
+}
+
+func (h *ReadyzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.Timeout)
+	defer cancel()
+
+	status := healthStatus{Status: "ok", Checks: h.Checker.CheckReady(ctx)}
+	code := http.StatusOK
+	for _, check := range status.Checks {
+		if !check.Ok {
+			status.Status = "degraded"
+			if !check.Optional {
+				code = http.StatusServiceUnavailable
+			}
+		}
+	}
+	writeHealthStatus(w, code, status)
+}
+
+func writeHealthStatus(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// newHealthCheck creates a HealthCheck with the given name, which is ok if err
+// is nil
+func newHealthCheck(name string, err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Name: name, Ok: false, Error: err.Error()}
+	}
+	return HealthCheck{Name: name, Ok: true}
+}
+
+// CheckReady verifies that the SPARQL endpoint answers an empty ASK query
+func (s *SparqlSource) CheckReady(ctx context.Context) []HealthCheck {
+	return []HealthCheck{newHealthCheck("sparql-endpoint", checkSparqlEndpoint(ctx, s.EndpointUrl))}
+}
+
+func checkSparqlEndpoint(ctx context.Context, endpointUrl string) error {
+	request, err := http.NewRequest("POST", endpointUrl, strings.NewReader("query=ASK {}"))
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("SPARQL endpoint returned status %s", response.Status)
+	}
+	return nil
+}
+
+// CheckReady verifies that the HDT files can be opened, that their index
+// files exist next to them, and that the hdtSearch command is available. With
+// several files, the names of their checks are prefixed with the file names.
+func (s *HdtSource) CheckReady(ctx context.Context) []HealthCheck {
+	var checks []HealthCheck
+	paths := s.paths()
+	for _, path := range paths {
+		prefix := ""
+		if len(paths) > 1 {
+			prefix = filepath.Base(path) + "/"
+		}
+		checks = append(checks,
+			newHealthCheck(prefix+"hdt-file", checkHdtFile(path)),
+			newHealthCheck(prefix+"hdt-index", checkHdtFile(path+hdtIndexSuffix)))
+	}
+	_, lookErr := exec.LookPath("hdtSearch")
+	return append(checks, newHealthCheck("hdtsearch", lookErr))
+}
+
+// checkHdtFile makes sure that the file at path can be read, and starts with
+// the "$HDT" cookie used by both HDT files and their index files
+func checkHdtFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cookie := make([]byte, 4)
+	if _, err := io.ReadFull(f, cookie); err != nil {
+		return fmt.Errorf("Could not read HDT cookie from %s (%s)", path, err.Error())
+	}
+	if string(cookie) != "$HDT" {
+		return fmt.Errorf("Not an HDT file: %s", path)
+	}
+	return nil
+}
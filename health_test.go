@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyzHandlerSparql(t *testing.T) {
+	endpointUp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("query") != "ASK {}" {
+			t.Errorf("Expected an empty ASK query, got: %s", r.Form.Get("query"))
+		}
+		w.Write([]byte(`{"boolean": true}`))
+	}))
+	defer endpointUp.Close()
+
+	endpointDown := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer endpointDown.Close()
+
+	endpoints := map[string]int{
+		endpointUp.URL:   http.StatusOK,
+		endpointDown.URL: http.StatusServiceUnavailable,
+	}
+	for endpointUrl, expectedCode := range endpoints {
+		h := &ReadyzHandler{&SparqlSource{endpointUrl, nil}, time.Second}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != expectedCode {
+			t.Errorf("Expected status %d for endpoint %s, got %d: %s", expectedCode, endpointUrl, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestCheckHdtFile(t *testing.T) {
+	paths := map[string]bool{
+		"example_data.hdt":            true,
+		"example_data.hdt.index.v1-1": true,
+		"README.md":                   false,
+		"nonexisting.hdt":             false,
+	}
+	for path, shouldBeOk := range paths {
+		err := checkHdtFile(path)
+		if (err == nil) != shouldBeOk {
+			t.Errorf("Unexpected result of checking HDT file %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"html/template"
+	"io"
+
+	"github.com/knakk/rdf"
+)
+
+// htmlTerm is an RDF term prepared for display in HTML
+type htmlTerm struct {
+	Text  string // Compact display text
+	Link  string // The IRI linked to, if any
+	Title string // Full form of the term, shown on hover
+	Note  string // Language or datatype of literals
+}
+
+// htmlStatement is a row in the HTML view of a resource
+type htmlStatement struct {
+	Subj  htmlTerm
+	Pred  htmlTerm
+	Obj   htmlTerm
+	Graph htmlTerm
+}
+
+// htmlPage is the data of the HTML view of a resource
+type htmlPage struct {
+	Resource    htmlTerm
+	Outgoing    []htmlStatement
+	Incoming    []htmlStatement
+	NamedGraphs bool
+}
+
+var htmlTemplate = template.Must(template.New("resource").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{.Resource.Text}}</title>
+	<style>
+		body { font-family: arial, helvetica, sans-serif; margin: 2em; }
+		table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+		th, td { text-align: left; vertical-align: top; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; }
+		h1 small { display: block; font-size: 0.5em; font-weight: normal; color: #666; }
+		.note { color: #888; font-size: 0.85em; }
+		form { float: right; }
+	</style>
+</head>
+<body>
+	<form action="/search"><input type="search" name="q" placeholder="Search"></form>
+	<h1>{{.Resource.Text}}<small>{{.Resource.Title}}</small></h1>
+{{define "term"}}{{if .Link}}<a href="{{.Link}}" title="{{.Title}}">{{.Text}}</a>{{else}}<span title="{{.Title}}">{{.Text}}</span>{{end}}{{if .Note}} <span class="note">{{.Note}}</span>{{end}}{{end}}
+{{- if .Outgoing}}
+	<table>
+		<tr><th>Property</th><th>Value</th>{{if .NamedGraphs}}<th>Graph</th>{{end}}</tr>
+{{- range .Outgoing}}
+		<tr><td>{{template "term" .Pred}}</td><td>{{template "term" .Obj}}</td>{{if $.NamedGraphs}}<td>{{template "term" .Graph}}</td>{{end}}</tr>
+{{- end}}
+	</table>
+{{- end}}
+{{- if .Incoming}}
+	<h2>Referenced by</h2>
+	<table>
+		<tr><th>Resource</th><th>Property</th>{{if .NamedGraphs}}<th>Graph</th>{{end}}</tr>
+{{- range .Incoming}}
+		<tr><td>{{template "term" .Subj}}</td><td>{{template "term" .Pred}}</td>{{if $.NamedGraphs}}<td>{{template "term" .Graph}}</td>{{end}}</tr>
+{{- end}}
+	</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// writeHTML writes an HTML page describing the requested resource, with its
+// properties, followed by the resources referencing it. Resources are shown
+// by their labels, looked up in a single batch.
+func writeHTML(w io.Writer, quads []rdf.Quad, opts *writeOptions) error {
+	labels := opts.labels(labeledIRIs(quads))
+	newHTMLTerm := func(term rdf.Term) htmlTerm {
+		return newHTMLTerm(term, opts.Prefixes, labels)
+	}
+
+	resource, _ := rdf.NewIRI(opts.Resource)
+	page := htmlPage{Resource: newHTMLTerm(resource)}
+	seen := make(map[string]bool)
+	for _, q := range quads {
+		key := quadKey(q)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		s := htmlStatement{
+			Subj: newHTMLTerm(q.Subj),
+			Pred: newHTMLTerm(q.Pred),
+			Obj:  newHTMLTerm(q.Obj),
+		}
+		if q.Ctx != nil {
+			s.Graph = newHTMLTerm(q.Ctx)
+			page.NamedGraphs = true
+		}
+		if q.Subj.String() == opts.Resource {
+			page.Outgoing = append(page.Outgoing, s)
+		} else {
+			page.Incoming = append(page.Incoming, s)
+		}
+	}
+	return htmlTemplate.Execute(w, page)
+}
+
+// newHTMLTerm prepares term for display. IRIs are shown by their label, or
+// else by their local name, with the prefixed name (if any) and the full IRI
+// shown on hover.
+func newHTMLTerm(term rdf.Term, prefixes PrefixMap, labels map[string]string) htmlTerm {
+	switch t := term.(type) {
+	case rdf.IRI:
+		text, ok := labels[t.String()]
+		if !ok {
+			text = localName(t)
+		}
+		title := t.String()
+		if compacted, ok := prefixes.compact(t.String()); ok {
+			title = compacted + " (" + title + ")"
+		}
+		return htmlTerm{Text: text, Link: t.String(), Title: title}
+	case rdf.Literal:
+		note := ""
+		if t.Lang() != "" {
+			note = "@" + t.Lang()
+		} else if dt := t.DataType.String(); dt != xsdString {
+			note, _ = prefixes.compact(dt)
+			if note == "" {
+				note = dt
+			}
+		}
+		return htmlTerm{Text: t.String(), Note: note}
+	}
+	return htmlTerm{Text: term.Serialize(rdf.Turtle), Title: term.Serialize(rdf.Turtle)}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteHTML(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &writeOptions{
+		OutputOptions: OutputOptions{Prefixes: PrefixMap{"cplogd": "http://rdf.pharmb.io/cplogd/", "rdfs": "http://www.w3.org/2000/01/rdf-schema#"}},
+		Resource:      "http://rdf.pharmb.io/cplogd/Compound1",
+		Labels: func(iris []string) map[string]string {
+			return map[string]string{"http://rdf.pharmb.io/cplogd/Compound1": "Compound 1"}
+		},
+	}
+	if err := writeHTML(&buf, testCompoundQuads, opts); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+	expected := []string{
+		`<title>Compound 1</title>`,
+		`<a href="http://www.w3.org/2000/01/rdf-schema#label" title="rdfs:label (http://www.w3.org/2000/01/rdf-schema#label)">label</a>`,
+		`<span title="">Compound 1</span> <span class="note">@en</span>`,
+		`<h2>Referenced by</h2>`,
+		`<a href="http://rdf.pharmb.io/cplogd/Assay1" title="cplogd:Assay1 (http://rdf.pharmb.io/cplogd/Assay1)">Assay1</a>`,
+	}
+	for _, s := range expected {
+		if !strings.Contains(page, s) {
+			t.Errorf("Expected the HTML page to contain %s, got:\n%s", s, page)
+		}
+	}
+	if strings.Contains(page, "<th>Graph</th>") {
+		t.Error("Expected no graph column without named graphs")
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// JSON-LD profiles which can be requested with the profile parameter of the
+// application/ld+json media type
+const (
+	jsonldExpanded  = "http://www.w3.org/ns/json-ld#expanded"
+	jsonldCompacted = "http://www.w3.org/ns/json-ld#compacted"
+	jsonldFlattened = "http://www.w3.org/ns/json-ld#flattened"
+	jsonldFramed    = "http://www.w3.org/ns/json-ld#framed"
+
+	rdfType = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+)
+
+// jsonldContext is a JSON-LD context, used to compact IRIs and values
+type jsonldContext struct {
+	raw      interface{} // The context as loaded, written as is to the output
+	vocab    string
+	language string
+	terms    map[string]jsonldTerm
+	iriTerms map[string][]string // IRI -> terms defined for it
+}
+
+// jsonldTerm is a term definition in a JSON-LD context, with IRIs expanded
+type jsonldTerm struct {
+	id        string
+	typ       string
+	language  string
+	container string
+}
+
+// loadJSONLDContext loads a JSON-LD context from a local file, containing
+// either a JSON-LD document with an @context, or just the context object
+func loadJSONLDContext(path string) (*jsonldContext, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Could not parse JSON-LD context %s (%s)", path, err.Error())
+	}
+	if inner, ok := doc["@context"]; ok {
+		if doc, ok = inner.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("Unsupported JSON-LD context in %s: @context must be an object", path)
+		}
+	}
+	ctx, err := newJSONLDContext(doc)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JSON-LD context in %s (%s)", path, err.Error())
+	}
+	return ctx, nil
+}
+
+// newJSONLDContext creates a jsonldContext from the object of an @context
+func newJSONLDContext(obj map[string]interface{}) (*jsonldContext, error) {
+	ctx := &jsonldContext{
+		raw:      obj,
+		terms:    make(map[string]jsonldTerm),
+		iriTerms: make(map[string][]string),
+	}
+	ctx.vocab, _ = obj["@vocab"].(string)
+	ctx.language, _ = obj["@language"].(string)
+
+	// First collect the plain IRI mappings, which can be used as prefixes
+	// in the other definitions
+	for term, def := range obj {
+		if id, ok := def.(string); ok && !strings.HasPrefix(term, "@") {
+			ctx.terms[term] = jsonldTerm{id: id}
+		}
+	}
+	for term, def := range obj {
+		if strings.HasPrefix(term, "@") {
+			continue
+		}
+		var t jsonldTerm
+		switch def := def.(type) {
+		case string:
+			t.id = ctx.expandIRI(def)
+		case map[string]interface{}:
+			id, _ := def["@id"].(string)
+			if id == "" {
+				id = ctx.expandIRI(term)
+			}
+			t.id = ctx.expandIRI(id)
+			if typ, ok := def["@type"].(string); ok {
+				if typ == "@id" || typ == "@vocab" {
+					t.typ = typ
+				} else {
+					t.typ = ctx.expandIRI(typ)
+				}
+			}
+			t.language, _ = def["@language"].(string)
+			t.container, _ = def["@container"].(string)
+		default:
+			return nil, fmt.Errorf("unsupported definition of term %q", term)
+		}
+		ctx.terms[term] = t
+	}
+	for term, t := range ctx.terms {
+		ctx.iriTerms[t.id] = append(ctx.iriTerms[t.id], term)
+	}
+	return ctx, nil
+}
+
+// expandIRI expands a term or compact IRI to a full IRI
+func (ctx *jsonldContext) expandIRI(s string) string {
+	if t, ok := ctx.terms[s]; ok && t.id != s {
+		return ctx.expandIRI(t.id)
+	}
+	if i := strings.Index(s, ":"); i > 0 && !strings.HasPrefix(s[i:], "://") {
+		if t, ok := ctx.terms[s[:i]]; ok {
+			return t.id + s[i+1:]
+		}
+	}
+	return s
+}
+
+// compactIRI compacts iri to a term, a compact IRI using a prefix, or, if
+// vocab is true, relative to @vocab. Terms with a container other than @set
+// are not used, as they would change the meaning of the values.
+func (ctx *jsonldContext) compactIRI(iri string, vocab bool) string {
+	if ctx == nil {
+		return iri
+	}
+	if vocab {
+		var terms []string
+		for _, term := range ctx.iriTerms[iri] {
+			if c := ctx.terms[term].container; c == "" || c == "@set" {
+				terms = append(terms, term)
+			}
+		}
+		if len(terms) > 0 {
+			return shortest(terms)
+		}
+		if ctx.vocab != "" && strings.HasPrefix(iri, ctx.vocab) && len(iri) > len(ctx.vocab) {
+			suffix := iri[len(ctx.vocab):]
+			if _, ok := ctx.terms[suffix]; !ok && !strings.Contains(suffix, ":") {
+				return suffix
+			}
+		}
+	}
+	best := iri
+	for term, t := range ctx.terms {
+		if t.typ != "" || t.language != "" || !strings.HasPrefix(iri, t.id) || len(iri) == len(t.id) {
+			continue
+		}
+		if !strings.HasSuffix(t.id, "/") && !strings.HasSuffix(t.id, "#") && !strings.HasSuffix(t.id, ":") {
+			continue
+		}
+		if candidate := term + ":" + iri[len(t.id):]; len(candidate) < len(best) || (len(candidate) == len(best) && candidate < best) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+func shortest(terms []string) string {
+	best := terms[0]
+	for _, term := range terms[1:] {
+		if len(term) < len(best) || (len(term) == len(best) && term < best) {
+			best = term
+		}
+	}
+	return best
+}
+
+// jsonldNode is a node object being built from triples
+type jsonldNode struct {
+	id      string
+	types   []string
+	props   map[string][]rdf.Object
+	order   []string // Properties, in order of appearance
+	reverse map[string][]string
+}
+
+// jsonldGraph indexes the triples of a graph as node objects by subject
+type jsonldGraph struct {
+	nodes map[string]*jsonldNode
+	order []string
+}
+
+func newJSONLDGraph(triples []rdf.Triple) *jsonldGraph {
+	g := &jsonldGraph{nodes: make(map[string]*jsonldNode)}
+	for _, t := range triples {
+		n := g.node(termID(t.Subj))
+		pred := t.Pred.String()
+		if obj, ok := t.Obj.(rdf.IRI); ok && pred == rdfType {
+			n.types = append(n.types, obj.String())
+			continue
+		}
+		if _, ok := n.props[pred]; !ok {
+			n.order = append(n.order, pred)
+		}
+		n.props[pred] = append(n.props[pred], t.Obj)
+		if t.Obj.Type() != rdf.TermLiteral {
+			o := g.node(termID(t.Obj))
+			o.reverse[pred] = append(o.reverse[pred], n.id)
+		}
+	}
+	return g
+}
+
+func (g *jsonldGraph) node(id string) *jsonldNode {
+	n, ok := g.nodes[id]
+	if !ok {
+		n = &jsonldNode{id: id, props: make(map[string][]rdf.Object), reverse: make(map[string][]string)}
+		g.nodes[id] = n
+		g.order = append(g.order, id)
+	}
+	return n
+}
+
+// termID returns the @id of an IRI or blank node
+func termID(t rdf.Term) string {
+	if b, ok := t.(rdf.Blank); ok {
+		return "_:" + b.String()
+	}
+	return t.String()
+}
+
+// hasContent returns true if n has any properties or types of its own
+func (n *jsonldNode) hasContent() bool {
+	return len(n.types) > 0 || len(n.props) > 0
+}
+
+// expanded returns the node in expanded form
+func (n *jsonldNode) expanded() map[string]interface{} {
+	obj := map[string]interface{}{"@id": n.id}
+	if len(n.types) > 0 {
+		obj["@type"] = n.types
+	}
+	for _, pred := range n.order {
+		var values []interface{}
+		for _, o := range n.props[pred] {
+			values = append(values, expandedValue(o))
+		}
+		obj[pred] = values
+	}
+	return obj
+}
+
+func expandedValue(o rdf.Object) map[string]interface{} {
+	lit, ok := o.(rdf.Literal)
+	if !ok {
+		return map[string]interface{}{"@id": termID(o)}
+	}
+	value := map[string]interface{}{"@value": lit.String()}
+	if lit.Lang() != "" {
+		value["@language"] = lit.Lang()
+	} else if dt := lit.DataType.String(); dt != xsdString {
+		value["@type"] = dt
+	}
+	return value
+}
+
+// compactor compacts node objects with a context, embedding referenced nodes
+// when framing
+type compactor struct {
+	ctx   *jsonldContext
+	graph *jsonldGraph
+	embed bool
+}
+
+// compacted returns the node in compacted form. When embedding, nodes which
+// are referenced and have properties of their own are embedded, unless they
+// are already being written further up (in ancestors).
+func (c *compactor) compacted(n *jsonldNode, ancestors map[string]bool) map[string]interface{} {
+	obj := map[string]interface{}{"@id": c.ctx.compactIRI(n.id, false)}
+	if len(n.types) > 0 {
+		var types []string
+		for _, typ := range n.types {
+			types = append(types, c.ctx.compactIRI(typ, true))
+		}
+		obj["@type"] = singleOrList(types, len(types) > 1)
+	}
+
+	if c.embed {
+		ancestors[n.id] = true
+		defer delete(ancestors, n.id)
+	}
+	for _, pred := range n.order {
+		key, def := c.termFor(pred, n.props[pred])
+		var values []interface{}
+		for _, o := range n.props[pred] {
+			values = append(values, c.compactedValue(o, def, ancestors))
+		}
+		obj[key] = singleOrList(values, def.container == "@set")
+	}
+	return obj
+}
+
+// termFor selects the key to use for pred, preferring terms whose type or
+// language coercion matches all the values. Terms with a container other
+// than @set are skipped, as plain triples have no list, language map or
+// index to put in them.
+func (c *compactor) termFor(pred string, values []rdf.Object) (string, jsonldTerm) {
+	if c.ctx == nil {
+		return pred, jsonldTerm{}
+	}
+	var plain string
+	for _, term := range c.ctx.iriTerms[pred] {
+		def := c.ctx.terms[term]
+		if def.container != "" && def.container != "@set" {
+			continue
+		}
+		if def.typ == "" && def.language == "" {
+			if plain == "" || len(term) < len(plain) {
+				plain = term
+			}
+			continue
+		}
+		matchesAll := true
+		for _, o := range values {
+			if !termMatches(def, o) {
+				matchesAll = false
+			}
+		}
+		if matchesAll {
+			return term, def
+		}
+	}
+	if plain != "" {
+		return plain, c.ctx.terms[plain]
+	}
+	return c.ctx.compactIRI(pred, true), jsonldTerm{}
+}
+
+func termMatches(def jsonldTerm, o rdf.Object) bool {
+	lit, isLiteral := o.(rdf.Literal)
+	switch {
+	case def.typ == "@id" || def.typ == "@vocab":
+		return !isLiteral
+	case def.typ != "":
+		return isLiteral && lit.Lang() == "" && lit.DataType.String() == def.typ
+	case def.language != "":
+		return isLiteral && lit.Lang() == def.language
+	}
+	return true
+}
+
+func (c *compactor) compactedValue(o rdf.Object, def jsonldTerm, ancestors map[string]bool) interface{} {
+	lit, ok := o.(rdf.Literal)
+	if !ok {
+		id := termID(o)
+		if n, ok := c.graph.nodes[id]; ok && c.embed && n.hasContent() && !ancestors[id] {
+			return c.compacted(n, ancestors)
+		}
+		if def.typ == "@id" {
+			return c.ctx.compactIRI(id, false)
+		}
+		if def.typ == "@vocab" {
+			return c.ctx.compactIRI(id, true)
+		}
+		return map[string]interface{}{"@id": c.ctx.compactIRI(id, false)}
+	}
+
+	if (def.typ != "" && def.typ == lit.DataType.String() && lit.Lang() == "") || (def.language != "" && def.language == lit.Lang()) {
+		return lit.String()
+	}
+	if def.typ == "" && def.language == "" {
+		defaultLanguage := ""
+		if c.ctx != nil {
+			defaultLanguage = c.ctx.language
+		}
+		if lit.Lang() == defaultLanguage && (lit.Lang() != "" || lit.DataType.String() == xsdString) {
+			return lit.String()
+		}
+	}
+	value := map[string]interface{}{"@value": lit.String()}
+	if lit.Lang() != "" {
+		value["@language"] = lit.Lang()
+	} else if dt := lit.DataType.String(); dt != xsdString {
+		value["@type"] = c.ctx.compactIRI(dt, true)
+	}
+	return value
+}
+
+// singleOrList returns the only item of a list of one, unless asList is set
+func singleOrList(list interface{}, asList bool) interface{} {
+	if asList {
+		return list
+	}
+	switch l := list.(type) {
+	case []string:
+		if len(l) == 1 {
+			return l[0]
+		}
+	case []interface{}:
+		if len(l) == 1 {
+			return l[0]
+		}
+	}
+	return list
+}
+
+// jsonldProfile returns the requested JSON-LD profile from the (space
+// separated) profile parameter, defaulting to compacted if there is a
+// context to compact with, and expanded if not
+func jsonldProfile(profileParam string, ctx *jsonldContext) string {
+	for _, profile := range strings.Fields(profileParam) {
+		switch profile {
+		case jsonldExpanded, jsonldCompacted, jsonldFlattened, jsonldFramed:
+			return profile
+		}
+	}
+	if ctx != nil {
+		return jsonldCompacted
+	}
+	return jsonldExpanded
+}
+
+// writeJSONLD writes quads as JSON-LD, in the form given by the profile
+// parameter of the accepted media type. Named graphs are kept as nodes with
+// an @graph, except in the framed form, which has the described resource as
+// its root object, with the nodes it links to embedded, and the nodes
+// linking to it under @reverse.
+func writeJSONLD(w io.Writer, quads []rdf.Quad, opts *writeOptions) error {
+	ctx := opts.JSONLDContext
+	profile := jsonldProfile(opts.Params["profile"], ctx)
+
+	var doc interface{}
+	switch profile {
+	case jsonldExpanded:
+		var nodes []interface{}
+		for _, graph := range groupByGraph(quads) {
+			g := newJSONLDGraph(graph.triples)
+			var graphNodes []interface{}
+			for _, id := range g.order {
+				if n := g.nodes[id]; n.hasContent() {
+					graphNodes = append(graphNodes, n.expanded())
+				}
+			}
+			if graph.name == nil {
+				nodes = append(nodes, graphNodes...)
+			} else if len(graphNodes) > 0 {
+				nodes = append(nodes, map[string]interface{}{"@id": termID(graph.name), "@graph": graphNodes})
+			}
+		}
+		if nodes == nil {
+			nodes = []interface{}{}
+		}
+		doc = nodes
+	case jsonldFramed:
+		g := newJSONLDGraph(quadsToTriples(quads))
+		c := &compactor{ctx: ctx, graph: g, embed: true}
+		root, ok := g.nodes[opts.Resource]
+		if !ok {
+			root = g.node(opts.Resource)
+		}
+		obj := c.compacted(root, map[string]bool{})
+		reverse := make(map[string]interface{})
+		for pred, subjects := range root.reverse {
+			key, _ := c.termFor(pred, nil)
+			var values []interface{}
+			for _, s := range subjects {
+				if s != root.id {
+					values = append(values, c.compacted(g.nodes[s], map[string]bool{root.id: true}))
+				}
+			}
+			if len(values) > 0 {
+				reverse[key] = singleOrList(values, false)
+			}
+		}
+		if len(reverse) > 0 {
+			obj["@reverse"] = reverse
+		}
+		if ctx != nil {
+			obj["@context"] = ctx.raw
+		}
+		doc = obj
+	default: // Compacted and flattened
+		var nodes []interface{}
+		for _, graph := range groupByGraph(quads) {
+			g := newJSONLDGraph(graph.triples)
+			c := &compactor{ctx: ctx, graph: g}
+			var graphNodes []interface{}
+			for _, id := range g.order {
+				if n := g.nodes[id]; n.hasContent() {
+					graphNodes = append(graphNodes, c.compacted(n, nil))
+				}
+			}
+			if graph.name == nil {
+				nodes = append(nodes, graphNodes...)
+			} else if len(graphNodes) > 0 {
+				nodes = append(nodes, map[string]interface{}{"@id": ctx.compactIRI(termID(graph.name), false), "@graph": graphNodes})
+			}
+		}
+		obj := map[string]interface{}{"@graph": nodes}
+		if nodes == nil {
+			obj["@graph"] = []interface{}{}
+		}
+		if ctx != nil {
+			obj["@context"] = ctx.raw
+		}
+		doc = obj
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+const testJSONLDContext = `{
+  "@context": {
+    "cplogd": "http://rdf.pharmb.io/cplogd/",
+    "xsd": "http://www.w3.org/2001/XMLSchema#",
+    "logD": {"@id": "cplogd:logD", "@type": "xsd:decimal"},
+    "measurement": {"@id": "cplogd:hasMeasurement", "@type": "@id"},
+    "label": "http://www.w3.org/2000/01/rdf-schema#label",
+    "@language": "en"
+  }
+}`
+
+var testCompoundQuads = []rdf.Quad{
+	mustQuad("http://rdf.pharmb.io/cplogd/Compound1", "http://www.w3.org/1999/02/22-rdf-syntax-ns#type", mustIRI("http://rdf.pharmb.io/cplogd/Compound"), ""),
+	mustQuad("http://rdf.pharmb.io/cplogd/Compound1", "http://www.w3.org/2000/01/rdf-schema#label", mustLangLiteral("Compound 1", "en"), ""),
+	mustQuad("http://rdf.pharmb.io/cplogd/Compound1", "http://rdf.pharmb.io/cplogd/hasMeasurement", mustIRI("http://rdf.pharmb.io/cplogd/Measurement1"), ""),
+	mustQuad("http://rdf.pharmb.io/cplogd/Measurement1", "http://rdf.pharmb.io/cplogd/logD", rdf.NewTypedLiteral("2.5", mustIRI("http://www.w3.org/2001/XMLSchema#decimal")), ""),
+	mustQuad("http://rdf.pharmb.io/cplogd/Assay1", "http://rdf.pharmb.io/cplogd/tested", mustIRI("http://rdf.pharmb.io/cplogd/Compound1"), ""),
+}
+
+// loadTestContext writes the test JSON-LD context to a file, and loads it
+func loadTestContext(t *testing.T) *jsonldContext {
+	dir, err := ioutil.TempDir("", "urisolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "context.jsonld")
+	if err := ioutil.WriteFile(path, []byte(testJSONLDContext), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := loadJSONLDContext(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestJSONLDContextCompactIRI(t *testing.T) {
+	ctx := loadTestContext(t)
+	iris := []struct {
+		iri      string
+		vocab    bool
+		expected string
+	}{
+		{"http://rdf.pharmb.io/cplogd/Compound1", false, "cplogd:Compound1"},
+		{"http://www.w3.org/2000/01/rdf-schema#label", true, "label"},
+		{"http://www.w3.org/2000/01/rdf-schema#label", false, "http://www.w3.org/2000/01/rdf-schema#label"},
+		{"http://example.org/other", true, "http://example.org/other"},
+	}
+	for _, test := range iris {
+		if got := ctx.compactIRI(test.iri, test.vocab); got != test.expected {
+			t.Errorf("Expected %s to be compacted to %s, got %s", test.iri, test.expected, got)
+		}
+	}
+}
+
+func TestWriteJSONLD(t *testing.T) {
+	ctx := loadTestContext(t)
+	var contextObj map[string]interface{}
+	json.Unmarshal([]byte(testJSONLDContext), &contextObj)
+
+	tests := []struct {
+		profile  string
+		ctx      *jsonldContext
+		expected string
+	}{
+		{"", nil, `[
+			{"@id": "http://rdf.pharmb.io/cplogd/Compound1",
+			 "@type": ["http://rdf.pharmb.io/cplogd/Compound"],
+			 "http://www.w3.org/2000/01/rdf-schema#label": [{"@value": "Compound 1", "@language": "en"}],
+			 "http://rdf.pharmb.io/cplogd/hasMeasurement": [{"@id": "http://rdf.pharmb.io/cplogd/Measurement1"}]},
+			{"@id": "http://rdf.pharmb.io/cplogd/Measurement1",
+			 "http://rdf.pharmb.io/cplogd/logD": [{"@value": "2.5", "@type": "http://www.w3.org/2001/XMLSchema#decimal"}]},
+			{"@id": "http://rdf.pharmb.io/cplogd/Assay1",
+			 "http://rdf.pharmb.io/cplogd/tested": [{"@id": "http://rdf.pharmb.io/cplogd/Compound1"}]}
+		]`},
+		{jsonldCompacted, ctx, `{
+			"@context": ` + string(mustMarshal(contextObj["@context"])) + `,
+			"@graph": [
+				{"@id": "cplogd:Compound1", "@type": "cplogd:Compound", "label": "Compound 1", "measurement": "cplogd:Measurement1"},
+				{"@id": "cplogd:Measurement1", "logD": "2.5"},
+				{"@id": "cplogd:Assay1", "cplogd:tested": {"@id": "cplogd:Compound1"}}
+			]
+		}`},
+		{jsonldFramed, ctx, `{
+			"@context": ` + string(mustMarshal(contextObj["@context"])) + `,
+			"@id": "cplogd:Compound1",
+			"@type": "cplogd:Compound",
+			"label": "Compound 1",
+			"measurement": {"@id": "cplogd:Measurement1", "logD": "2.5"},
+			"@reverse": {"cplogd:tested": {"@id": "cplogd:Assay1", "cplogd:tested": {"@id": "cplogd:Compound1"}}}
+		}`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		opts := &writeOptions{
+			OutputOptions: OutputOptions{JSONLDContext: test.ctx},
+			Resource:      "http://rdf.pharmb.io/cplogd/Compound1",
+			Params:        map[string]string{"profile": test.profile},
+		}
+		if err := writeJSONLD(&buf, testCompoundQuads, opts); err != nil {
+			t.Fatal(err)
+		}
+		var got, expected interface{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("Invalid JSON written for profile %q: %v", test.profile, err)
+		}
+		if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Unexpected JSON-LD for profile %q. Expected:\n%s\nGot:\n%s", test.profile, test.expected, buf.String())
+		}
+	}
+}
+
+func TestWriteJSONLDNamedGraphs(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSONLD(&buf, testQuads, &writeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1]["@id"] != "http://rdf.pharmb.io/graph/measurements" || got[1]["@graph"] == nil {
+		t.Errorf("Expected the default graph node followed by a named graph, got:\n%s", buf.String())
+	}
+}
+
+func TestWriteJSONLDContainerTerms(t *testing.T) {
+	var contextObj map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"ex": "http://example.org/",
+		"members": {"@id": "ex:member", "@container": "@list"},
+		"labels": {"@id": "http://www.w3.org/2000/01/rdf-schema#label", "@container": "@language"},
+		"tagIndex": {"@id": "ex:tag", "@container": "@index"},
+		"tags": {"@id": "ex:tag", "@container": "@set"}
+	}`), &contextObj)
+	ctx, err := newJSONLDContext(contextObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quads := []rdf.Quad{
+		mustQuad("http://example.org/a", "http://example.org/member", mustIRI("http://example.org/b"), ""),
+		mustQuad("http://example.org/a", "http://www.w3.org/2000/01/rdf-schema#label", mustLangLiteral("A", "en"), ""),
+		mustQuad("http://example.org/a", "http://example.org/tag", rdf.NewTypedLiteral("x", mustIRI(xsdString)), ""),
+	}
+	var buf bytes.Buffer
+	opts := &writeOptions{
+		OutputOptions: OutputOptions{JSONLDContext: ctx},
+		Resource:      "http://example.org/a",
+		Params:        map[string]string{},
+	}
+	if err := writeJSONLD(&buf, quads, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expanding the keys with the context must give back the predicates,
+	// with the values as written rather than put in lists, language maps
+	// or indexes
+	var doc struct {
+		Graph []map[string]interface{} `json:"@graph"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Graph) != 1 {
+		t.Fatalf("Expected one node, got:\n%s", buf.String())
+	}
+	got := doc.Graph[0]
+	expected := map[string]interface{}{
+		"ex:member": map[string]interface{}{"@id": "ex:b"},
+		"http://www.w3.org/2000/01/rdf-schema#label": map[string]interface{}{"@value": "A", "@language": "en"},
+		"tags": []interface{}{"x"},
+	}
+	for key, value := range expected {
+		if !reflect.DeepEqual(got[key], value) {
+			t.Errorf("Expected %s to be %v, got %v in:\n%s", key, value, got[key], buf.String())
+		}
+	}
+	for key := range got {
+		if term, ok := ctx.terms[key]; ok && term.container != "" && term.container != "@set" {
+			t.Errorf("Expected the %s container term not to be used, got:\n%s", term.container, buf.String())
+		}
+	}
+}
+
+func mustLangLiteral(s string, lang string) rdf.Literal {
+	lit, _ := rdf.NewLangLiteral(s, lang)
+	return lit
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
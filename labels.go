@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// labelPredicates are the properties used to look up labels of resources,
+// in order of preference
+var labelPredicates = []string{
+	"http://www.w3.org/2000/01/rdf-schema#label",
+	"http://www.w3.org/2004/02/skos/core#prefLabel",
+	"http://purl.org/dc/elements/1.1/title",
+	"http://schema.org/name",
+}
+
+// maxLabeledIRIs is the maximum number of IRIs labels are looked up for in a
+// single request
+const maxLabeledIRIs = 200
+
+// Labeler is implemented by sources which can look up the labels of
+// resources, for showing them in HTML views
+type Labeler interface {
+	// Labels returns the triples with any of the iris as subject, one of the
+	// labelPredicates as predicate, and a literal object
+	Labels(ctx context.Context, iris []string) ([]rdf.Triple, error)
+}
+
+// Labels looks up the labels of all the iris with a single query
+func (s *SparqlSource) Labels(ctx context.Context, iris []string) ([]rdf.Triple, error) {
+	bindings, err := s.selectQuery(ctx, s.labelsQuery(iris))
+	if err != nil {
+		return nil, err
+	}
+	var triples []rdf.Triple
+	for _, b := range bindings {
+		q, err := bindingToQuad(b)
+		if err != nil {
+			return nil, err
+		}
+		triples = append(triples, q.Triple)
+	}
+	return triples, nil
+}
+
+// labelsQuery returns a SELECT query for the labels of iris, in the default
+// graph and the named graphs (or only in s.Graphs, if set)
+func (s *SparqlSource) labelsQuery(iris []string) string {
+	values := func(variable string, iris []string) string {
+		return "VALUES ?" + variable + " { <" + strings.Join(iris, "> <") + "> }"
+	}
+	pattern := "{ ?s ?p ?o } UNION { GRAPH ?g { ?s ?p ?o } }"
+	if len(s.Graphs) > 0 {
+		pattern = "GRAPH ?g { ?s ?p ?o }\n  " + values("g", s.Graphs)
+	}
+	return `SELECT DISTINCT ?s ?p ?o WHERE {
+  ` + values("s", iris) + `
+  ` + values("p", labelPredicates) + `
+  ` + pattern + `
+  FILTER(isLiteral(?o))
+}`
+}
+
+// Labels looks up the labels of iris, in one batch of queries, run by a
+// single hdtSearch process for each HDT file
+func (s *HdtSource) Labels(ctx context.Context, iris []string) ([]rdf.Triple, error) {
+	if len(iris) == 0 {
+		return nil, nil
+	}
+	var queries []string
+	for _, iri := range iris {
+		queries = append(queries, iri+" ? ?")
+	}
+	triples, err := s.runHdtQuery(ctx, queries...)
+	if err != nil {
+		return nil, err
+	}
+	var labels []rdf.Triple
+	for _, t := range triples {
+		if t.Obj.Type() == rdf.TermLiteral && isLabelPredicate(t.Pred.String()) {
+			labels = append(labels, t)
+		}
+	}
+	return labels, nil
+}
+
+func isLabelPredicate(iri string) bool {
+	for _, p := range labelPredicates {
+		if p == iri {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupLabels returns display labels for (at most maxLabeledIRIs of) iris,
+// in the languages preferred by acceptLanguage. Labels are only looked up for
+// the resources under uriHost which the client may read (see mayReadIRI).
+// Since labels are not essential, failed lookups are only logged.
+func lookupLabels(ctx context.Context, labeler Labeler, uriHost string, iris []string, acceptLanguage string) map[string]string {
+	var readable []string
+	for _, iri := range iris {
+		if mayReadIRI(ctx, uriHost, iri) {
+			readable = append(readable, iri)
+		}
+	}
+	iris = readable
+	if len(iris) > maxLabeledIRIs {
+		iris = iris[:maxLabeledIRIs]
+	}
+	labels, err := labeler.Labels(ctx, iris)
+	if err != nil {
+		log.Println("Could not look up labels: " + err.Error())
+		return nil
+	}
+	return selectLabels(labels, acceptLanguage)
+}
+
+// labeledIRIs returns the distinct IRIs in quads, in order of appearance
+func labeledIRIs(quads []rdf.Quad) []string {
+	var iris []string
+	seen := make(map[string]bool)
+	for _, q := range quads {
+		for _, term := range []rdf.Term{q.Subj, q.Pred, q.Obj} {
+			if term.Type() == rdf.TermIRI && !seen[term.String()] {
+				seen[term.String()] = true
+				iris = append(iris, term.String())
+			}
+		}
+	}
+	return iris
+}
+
+// selectLabels picks one label for each subject of labels, preferring the
+// languages asked for in the Accept-Language header, and then the label
+// predicates in order. Labels without language tag are used when none in an
+// accepted language are found, as are labels in any language if there are
+// no other.
+func selectLabels(labels []rdf.Triple, acceptLanguage string) map[string]string {
+	ranges := parseAcceptLanguage(acceptLanguage)
+	score := func(t rdf.Triple) float64 {
+		lit := t.Obj.(rdf.Literal)
+		switch {
+		case lit.Lang() == "":
+			return 0.0005
+		case len(ranges) == 0:
+			// Without preferences, prefer English over other languages
+			if languageQuality([]languageRange{{"en", 1}}, lit.Lang()) > 0 {
+				return 0.001
+			}
+		default:
+			if q := languageQuality(ranges, lit.Lang()); q > 0 {
+				return q
+			}
+		}
+		return 0
+	}
+	predicateRank := func(t rdf.Triple) int {
+		for i, p := range labelPredicates {
+			if p == t.Pred.String() {
+				return i
+			}
+		}
+		return len(labelPredicates)
+	}
+
+	best := make(map[string]rdf.Triple)
+	for _, t := range labels {
+		if _, ok := t.Obj.(rdf.Literal); !ok {
+			continue
+		}
+		subj := t.Subj.String()
+		current, ok := best[subj]
+		if !ok || score(t) > score(current) || (score(t) == score(current) && predicateRank(t) < predicateRank(current)) {
+			best[subj] = t
+		}
+	}
+	selected := make(map[string]string)
+	for subj, t := range best {
+		selected[subj] = t.Obj.String()
+	}
+	return selected
+}
+
+// localName returns the part of iri after the last '/' or '#', for showing
+// resources without a label, or the whole IRI if that part is empty
+func localName(iri rdf.IRI) string {
+	if _, suffix := iri.Split(); suffix != "" {
+		return suffix
+	}
+	return iri.String()
+}
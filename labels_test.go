@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+func TestSelectLabels(t *testing.T) {
+	compound := "http://rdf.pharmb.io/cplogd/Compound1"
+	assay := "http://rdf.pharmb.io/cplogd/Assay1"
+	labels := []rdf.Triple{
+		mustQuad(compound, "http://schema.org/name", mustLangLiteral("Compound one", "en"), "").Triple,
+		mustQuad(compound, "http://www.w3.org/2000/01/rdf-schema#label", mustLangLiteral("Compound 1", "en-GB"), "").Triple,
+		mustQuad(compound, "http://www.w3.org/2000/01/rdf-schema#label", mustLangLiteral("Förening 1", "sv"), "").Triple,
+		mustQuad(assay, "http://purl.org/dc/elements/1.1/title", rdf.NewTypedLiteral("Assay 1", mustIRI(xsdString)), "").Triple,
+		mustQuad(assay, "http://www.w3.org/2000/01/rdf-schema#label", mustLangLiteral("Test 1", "sv"), "").Triple,
+	}
+	tests := map[string]map[string]string{
+		"":                {compound: "Compound 1", assay: "Assay 1"},
+		"sv, en;q=0.5":    {compound: "Förening 1", assay: "Test 1"},
+		"en-US, en;q=0.8": {compound: "Compound 1", assay: "Assay 1"},
+		"de":              {compound: "Compound 1", assay: "Assay 1"},
+	}
+	for acceptLanguage, expected := range tests {
+		if got := selectLabels(labels, acceptLanguage); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Accept-Language %q: expected labels %v, got %v", acceptLanguage, expected, got)
+		}
+	}
+}
+
+func TestLocalName(t *testing.T) {
+	iris := map[string]string{
+		"http://rdf.pharmb.io/cplogd/Compound1":      "Compound1",
+		"http://www.w3.org/2000/01/rdf-schema#label": "label",
+		"http://rdf.pharmb.io/":                      "http://rdf.pharmb.io/",
+	}
+	for iri, expected := range iris {
+		if got := localName(mustIRI(iri)); got != expected {
+			t.Errorf("Expected local name %s for %s, got %s", expected, iri, got)
+		}
+	}
+}
+
+// recordingLabeler records the IRIs it is asked for labels of
+type recordingLabeler struct {
+	iris []string
+}
+
+func (l *recordingLabeler) Labels(ctx context.Context, iris []string) ([]rdf.Triple, error) {
+	l.iris = append(l.iris, iris...)
+	return nil, nil
+}
+
+func TestLookupLabelsAccessRules(t *testing.T) {
+	auth := &Authenticator{Rules: []AccessRule{{"/preprint/", []string{"alice"}}}}
+	iris := []string{"http://example.org/cplogd/Compound1", "http://example.org/preprint/Compound2", "http://other.org/preprint/Compound3"}
+	tests := map[string][]string{
+		"":      {iris[0], iris[2]},
+		"alice": iris,
+		"bob":   {iris[0], iris[2]},
+	}
+	for user, expected := range tests {
+		labeler := &recordingLabeler{}
+		handler := withAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lookupLabels(r.Context(), labeler, "http://example.org", iris, "")
+		}))
+		r := httptest.NewRequest("GET", "/cplogd/Compound1", nil)
+		if user != "" {
+			auth.APIKeys = map[string]string{"key": user}
+			r.Header.Set("X-API-Key", "key")
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if !reflect.DeepEqual(labeler.iris, expected) {
+			t.Errorf("Expected labels to be looked up for %v as %q, got %v", expected, user, labeler.iris)
+		}
+	}
+}
+
+func TestHdtSourceLabelsBatch(t *testing.T) {
+	calls, cleanup := fakeHdtSearch(t, ">> http://example.org/a http://www.w3.org/2000/01/rdf-schema#label \"A\"@en\n"+
+		"http://example.org/b http://example.org/p http://example.org/a\n", "")
+	defer cleanup()
+
+	s := &HdtSource{FilePath: "example_data.hdt"}
+	labels, err := s.Labels(context.Background(), []string{"http://example.org/a", "http://example.org/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 || labels[0].Subj.String() != "http://example.org/a" {
+		t.Errorf("Expected the label of a, got %v", labels)
+	}
+	run, _ := ioutil.ReadFile(calls)
+	expected := "example_data.hdt\nhttp://example.org/a ? ?\nhttp://example.org/b ? ?\nexit\n"
+	if string(run) != expected {
+		t.Errorf("Expected one hdtSearch process for both IRIs, got %q", string(run))
+	}
+}
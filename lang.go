@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// languageRange is a language range from an Accept-Language header
+type languageRange struct {
+	tag string
+	q   float64
+}
+
+// parseAcceptLanguage parses an Accept-Language header, returning the
+// language ranges ordered by decreasing quality value
+func parseAcceptLanguage(header string) []languageRange {
+	var ranges []languageRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		lr := languageRange{tag: tag, q: 1}
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					lr.q = q
+				}
+			}
+		}
+		ranges = append(ranges, lr)
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// languageQuality returns the quality value with which the language ranges
+// accept the language tag lang, 0 if not accepted. A range matches a tag
+// which is equal to it, or starts with it followed by "-", so that "en"
+// matches "en-GB". The most specific matching range is used.
+func languageQuality(ranges []languageRange, lang string) float64 {
+	lang = strings.ToLower(lang)
+	best, bestLength := 0.0, -1
+	for _, lr := range ranges {
+		length := -1
+		switch {
+		case lr.tag == lang || strings.HasPrefix(lang, lr.tag+"-"):
+			length = len(lr.tag)
+		case lr.tag == "*":
+			length = 0
+		}
+		if length > bestLength {
+			best, bestLength = lr.q, length
+		}
+	}
+	return best
+}
+
+// filterLanguages removes the quads with language-tagged literals in
+// languages not accepted by ranges. Untagged literals are always kept.
+func filterLanguages(quads []rdf.Quad, ranges []languageRange) []rdf.Quad {
+	var filtered []rdf.Quad
+	for _, q := range quads {
+		if lit, ok := q.Obj.(rdf.Literal); ok && lit.Lang() != "" && languageQuality(ranges, lit.Lang()) <= 0 {
+			continue
+		}
+		filtered = append(filtered, q)
+	}
+	return filtered
+}
+
+// requestedLanguages returns the language ranges to filter literals with for
+// a request: those in the lang query parameter (a comma separated list) if
+// given, or else those in the Accept-Language header if fromHeader is set.
+// It returns nil if literals should not be filtered.
+func requestedLanguages(langParam string, acceptLanguage string, fromHeader bool) []languageRange {
+	if langParam != "" {
+		return parseAcceptLanguage(langParam)
+	}
+	if fromHeader {
+		return parseAcceptLanguage(acceptLanguage)
+	}
+	return nil
+}
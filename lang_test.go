@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+func TestLanguageQuality(t *testing.T) {
+	ranges := parseAcceptLanguage("sv;q=0.5, en, *;q=0.1")
+	tests := map[string]float64{
+		"en":    1,
+		"EN-gb": 1,
+		"sv":    0.5,
+		"de":    0.1,
+	}
+	for lang, expected := range tests {
+		if got := languageQuality(ranges, lang); got != expected {
+			t.Errorf("Expected quality %v for %s, got %v", expected, lang, got)
+		}
+	}
+	if got := languageQuality(parseAcceptLanguage("en"), "eng"); got != 0 {
+		t.Errorf("Expected en not to match eng, got quality %v", got)
+	}
+}
+
+func TestFilterLanguages(t *testing.T) {
+	compound := "http://rdf.pharmb.io/cplogd/Compound1"
+	label := "http://www.w3.org/2000/01/rdf-schema#label"
+	source := staticSource{
+		mustQuad(compound, label, mustLangLiteral("Compound 1", "en"), ""),
+		mustQuad(compound, label, mustLangLiteral("Förening 1", "sv"), ""),
+		mustQuad(compound, label, mustLangLiteral("Verbindung 1", "de"), ""),
+		mustQuad(compound, "http://rdf.pharmb.io/cplogd/logD", rdf.NewTypedLiteral("2.5", mustIRI("http://www.w3.org/2001/XMLSchema#decimal")), ""),
+	}
+
+	tests := []struct {
+		filterLanguages bool
+		query           string
+		acceptLanguage  string
+		expected        []string
+		notExpected     []string
+	}{
+		{false, "", "sv", []string{"@en", "@sv", "@de", "2.5"}, nil},
+		{true, "", "sv", []string{"@sv", "2.5"}, []string{"@en", "@de"}},
+		{true, "", "", []string{"@en", "@sv", "@de"}, nil},
+		{false, "?lang=de,en", "sv", []string{"@en", "@de", "2.5"}, []string{"@sv"}},
+	}
+	for _, test := range tests {
+		h := &URIResolverHandler{"http://rdf.pharmb.io", source, "", time.Second, nil, OutputOptions{FilterLanguages: test.filterLanguages}}
+		r := httptest.NewRequest("GET", "/cplogd/Compound1"+test.query, nil)
+		r.Header.Set("Accept-Language", test.acceptLanguage)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		body := rec.Body.String()
+		for _, s := range test.expected {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s (filter: %v, Accept-Language: %s): expected %s in:\n%s", test.query, test.filterLanguages, test.acceptLanguage, s, body)
+			}
+		}
+		for _, s := range test.notExpected {
+			if strings.Contains(body, s) {
+				t.Errorf("%s (filter: %v, Accept-Language: %s): expected no %s in:\n%s", test.query, test.filterLanguages, test.acceptLanguage, s, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+const (
+	ldpNamespace      = "http://www.w3.org/ns/ldp#"
+	ldpResource       = ldpNamespace + "Resource"
+	ldpBasicContainer = ldpNamespace + "BasicContainer"
+	ldpContainsIRI    = ldpNamespace + "contains"
+)
+
+// ldpContains returns the triple saying that container contains member
+func ldpContains(container string, member string) rdf.Triple {
+	c, _ := rdf.NewIRI(container)
+	p, _ := rdf.NewIRI(ldpContainsIRI)
+	m, _ := rdf.NewIRI(member)
+	return rdf.Triple{Subj: c, Pred: p, Obj: m}
+}
+
+// hasContainment returns true if any of triples is an ldp:contains triple,
+// which only the server may add or remove, answering 409 Conflict if so
+func hasContainment(w http.ResponseWriter, triples []rdf.Triple) bool {
+	for _, t := range triples {
+		if t.Pred.String() == ldpContainsIRI {
+			http.Error(w, "Error: The ldp:contains triples are managed by the server, create or delete members instead", http.StatusConflict)
+			return true
+		}
+	}
+	return false
+}
+
+// ContainerHandler serves the resources under the path prefixes in
+// Containers as Linked Data Platform (LDP) Basic Containers: GET on a
+// container (e.g. /curation/) lists its members with ldp:contains, and POST
+// creates a new member, with an IRI minted under the container (e.g.
+// /curation/3f2a9c0d41b7e865), from the RDF sent. Members are added to and
+// removed from their container when created or deleted through Store (which
+// is nil if the data can't be changed). Since the ldp:contains triples are
+// managed this way, containers can not be replaced or deleted, and RDF sent
+// with ldp:contains triples is refused, with 409 Conflict.
+//
+// All other requests are passed on to Resources, after adding the LDP
+// headers: a Link header with the type of the resource, Allow, and for
+// containers Accept-Post.
+type ContainerHandler struct {
+	URIHost      string
+	Containers   []string
+	Source       Source
+	Store        *GraphStoreHandler
+	QueryTimeout time.Duration
+	Limiter      *concurrencyLimiter
+	Output       OutputOptions
+	Resources    http.Handler
+}
+
+// containerOf returns the container which path is, or which it is a member
+// of, and whether path is the container itself
+func (h *ContainerHandler) containerOf(path string) (string, bool) {
+	for _, container := range h.Containers {
+		prefix := strings.TrimSuffix(container, "/") + "/"
+		if path == container {
+			return container, true
+		}
+		member := strings.TrimPrefix(path, prefix)
+		if len(member) < len(path) && member != "" && !strings.Contains(member, "/") {
+			return container, false
+		}
+	}
+	return "", false
+}
+
+func (h *ContainerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		h.Resources.ServeHTTP(w, r)
+		return
+	}
+	iri := h.URIHost + r.URL.Path
+	if !validUri(iri) {
+		http.Error(w, "Error: Invalid URI (invalid characters in URI)", http.StatusBadRequest)
+		return
+	}
+	container, isContainer := h.containerOf(r.URL.Path)
+
+	w.Header().Add("Link", "<"+ldpResource+">; rel=\"type\"")
+	allowed := "GET, HEAD, OPTIONS"
+	if isContainer {
+		w.Header().Add("Link", "<"+ldpBasicContainer+">; rel=\"type\"")
+		if h.Store != nil {
+			allowed += ", POST"
+			w.Header().Set("Accept-Post", strings.Join(acceptedInputTypes(), ", "))
+		}
+	} else if h.Store != nil {
+		allowed += ", PUT, POST, DELETE"
+	}
+	w.Header().Set("Allow", allowed)
+
+	switch {
+	case r.Method == "OPTIONS":
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method != "GET" && r.Method != "HEAD" && h.Store == nil:
+		http.Error(w, "Error: Method not allowed", http.StatusMethodNotAllowed)
+	case isContainer && (r.Method == "GET" || r.Method == "HEAD"):
+		h.serveContainer(w, r, iri)
+	case isContainer && r.Method == "POST":
+		h.createMember(w, r, iri)
+	case isContainer && (r.Method == "PUT" || r.Method == "DELETE"):
+		http.Error(w, "Error: Containers can not be replaced or deleted, since their ldp:contains triples are managed by the server", http.StatusConflict)
+	case r.Method == "GET" || r.Method == "HEAD":
+		h.Resources.ServeHTTP(w, r)
+	case container != "" && !isContainer:
+		h.Store.serveResource(w, r, iri, h.URIHost+container)
+	default:
+		h.Store.serveResource(w, r, iri, "")
+	}
+}
+
+// serveContainer writes the description of container, including the
+// ldp:contains triples listing its members, in the negotiated format. Empty
+// containers are described too, by their type.
+func (h *ContainerHandler) serveContainer(w http.ResponseWriter, r *http.Request, container string) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Accept-Language")
+	format, mediaType, params := negotiateFormat(r.Header.Get("Accept"))
+	if format == nil {
+		http.Error(w, "Error: None of the requested formats are supported. Supported formats are: "+strings.Join(supportedMediaTypes(), ", "), http.StatusNotAcceptable)
+		return
+	}
+	if !acquireBackend(w, r, h.Limiter) {
+		return
+	}
+	defer h.Limiter.release()
+	ctx, cancel := withQueryTimeout(r.Context(), h.QueryTimeout)
+	defer cancel()
+	quads, err := h.Source.Describe(ctx, container)
+	if err != nil {
+		writeBackendError(w, ctx, err)
+		return
+	}
+	// Only list the members the client may read
+	var readable []rdf.Quad
+	for _, q := range quads {
+		if q.Pred.String() != ldpContainsIRI || mayReadIRI(ctx, h.URIHost, q.Obj.String()) {
+			readable = append(readable, q)
+		}
+	}
+	quads = readable
+
+	c, _ := rdf.NewIRI(container)
+	typ, _ := rdf.NewIRI(rdfType)
+	basicContainer, _ := rdf.NewIRI(ldpBasicContainer)
+	typed := rdf.Quad{Triple: rdf.Triple{Subj: c, Pred: typ, Obj: basicContainer}}
+	found := false
+	for _, q := range quads {
+		found = found || quadKey(q) == quadKey(typed)
+	}
+	if !found {
+		quads = append([]rdf.Quad{typed}, quads...)
+	}
+	ranges := requestedLanguages(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"), h.Output.FilterLanguages)
+	if ranges != nil {
+		quads = filterLanguages(quads, ranges)
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	opts := &writeOptions{OutputOptions: h.Output, Resource: container, Params: params}
+	if labeler, ok := h.Source.(Labeler); ok {
+		opts.Labels = func(iris []string) map[string]string {
+			return lookupLabels(ctx, labeler, h.URIHost, iris, r.Header.Get("Accept-Language"))
+		}
+	}
+	if err := format.Write(w, quads, opts); err != nil {
+		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// createMember creates a new member of container, with a minted IRI, from
+// the RDF sent, where the member is the empty relative IRI <>. The IRI of
+// the new member is returned in the Location header.
+func (h *ContainerHandler) createMember(w http.ResponseWriter, r *http.Request, container string) {
+	id, err := mintID()
+	if err != nil {
+		http.Error(w, "Error: Could not create an IRI for the new resource ("+err.Error()+")", http.StatusInternalServerError)
+		return
+	}
+	member := strings.TrimSuffix(container, "/") + "/" + id
+	triples, ok := h.Store.readResourceTriples(w, r, member)
+	if !ok || hasContainment(w, triples) {
+		return
+	}
+	w.Header().Set("Location", member)
+	h.Store.change(w, r, func(ctx context.Context) (int, error) {
+		return http.StatusCreated, h.Store.Store.AddTriples(ctx, "", append(triples, ldpContains(container, member)))
+	})
+}
+
+// mintID returns a new random identifier for a resource
+func mintID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// acceptedInputTypes returns the media types accepted for RDF sent to change
+// the data, sorted
+func acceptedInputTypes() []string {
+	var types []string
+	for mediaType := range inputFormats {
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContainerHandler(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	store := &GraphStoreHandler{URIHost: "http://example.org", Store: s, Writers: []string{"authenticated"}, MaxUploadSize: 1024}
+	resolved := 0
+	h := &ContainerHandler{URIHost: "http://example.org", Containers: []string{"/curation/"}, Source: s, Store: store,
+		Resources: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resolved++
+		})}
+
+	// An empty container is described by its type
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("GET", "/curation/", "", "", ""))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), ldpBasicContainer) {
+		t.Errorf("Expected the empty container, got %d (%s)", w.Code, w.Body.String())
+	}
+	links := w.Header()["Link"]
+	if len(links) != 2 || links[0] != `<http://www.w3.org/ns/ldp#Resource>; rel="type"` || !strings.Contains(links[1], ldpBasicContainer) {
+		t.Errorf("Expected Link headers with the types of the container, got %v", links)
+	}
+	if w.Header().Get("Allow") != "GET, HEAD, OPTIONS, POST" || !strings.Contains(w.Header().Get("Accept-Post"), "text/turtle") {
+		t.Errorf("Expected Allow and Accept-Post headers, got %v", w.Header())
+	}
+
+	// Create a member, and check that it is listed
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("POST", "/curation/", "text/turtle", "<> <http://www.w3.org/2000/01/rdf-schema#label> \"New\" .", ""))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unauthenticated POST, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("POST", "/curation/", "text/turtle", "<> <http://www.w3.org/2000/01/rdf-schema#label> \"New\" .", "alice"))
+	member := w.Header().Get("Location")
+	if w.Code != http.StatusCreated || !strings.HasPrefix(member, "http://example.org/curation/") || len(member) != len("http://example.org/curation/")+16 {
+		t.Fatalf("Expected a member to be created, got %d with Location %q (%s)", w.Code, member, w.Body.String())
+	}
+	if quads, _ := s.Describe(context.Background(), member); len(quads) != 2 {
+		t.Errorf("Expected the member and its containment triple, got %v", quads)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("GET", "/curation/", "", "", ""))
+	if !strings.Contains(w.Body.String(), ldpContainsIRI) || !strings.Contains(w.Body.String(), member) {
+		t.Errorf("Expected the container to list the member, got %s", w.Body.String())
+	}
+
+	// Members are served by Resources, but with the LDP headers
+	path := strings.TrimPrefix(member, "http://example.org")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("GET", path, "", "", ""))
+	if resolved != 1 || w.Header().Get("Link") != `<http://www.w3.org/ns/ldp#Resource>; rel="type"` || w.Header().Get("Accept-Post") != "" {
+		t.Errorf("Expected the member to be resolved, with a Link header, got %d resolved, headers %v", resolved, w.Header())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("OPTIONS", path, "", "", ""))
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, HEAD, OPTIONS, PUT, POST, DELETE" {
+		t.Errorf("Expected OPTIONS to return the allowed methods, got %d %v", w.Code, w.Header())
+	}
+
+	// Deleting the member removes it from the container
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("DELETE", path, "", "", "alice"))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected the member to be deleted, got %d (%s)", w.Code, w.Body.String())
+	}
+	if quads, _ := s.Describe(context.Background(), "http://example.org/curation/"); len(quads) != 0 {
+		t.Errorf("Expected the container to be empty, got %v", quads)
+	}
+
+	// PUT creates members too
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("PUT", "/curation/chosen", "text/turtle", "<> <http://www.w3.org/2000/01/rdf-schema#label> \"Chosen\" .", "alice"))
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected the member to be created, got %d (%s)", w.Code, w.Body.String())
+	}
+	if quads, _ := s.Describe(context.Background(), "http://example.org/curation/"); len(quads) != 1 || quads[0].Obj.String() != "http://example.org/curation/chosen" {
+		t.Errorf("Expected the container to list the member, got %v", quads)
+	}
+
+	// The containment triples can only be changed by creating and deleting
+	// members
+	conflicts := []*http.Request{
+		graphStoreRequest("PUT", "/curation/", "text/turtle", "<> <http://www.w3.org/2000/01/rdf-schema#label> \"Curation\" .", "alice"),
+		graphStoreRequest("DELETE", "/curation/", "", "", "alice"),
+		graphStoreRequest("POST", "/curation/", "text/turtle", "<> <"+ldpContainsIRI+"> <http://example.org/other> .", "alice"),
+		graphStoreRequest("PUT", "/curation/chosen", "text/turtle", "<> <"+ldpContainsIRI+"> <http://example.org/other> .", "alice"),
+	}
+	for _, r := range conflicts {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for %s %s, got %d (%s)", r.Method, r.URL, w.Code, w.Body.String())
+		}
+	}
+	if quads, _ := s.Describe(context.Background(), "http://example.org/curation/"); len(quads) != 1 {
+		t.Errorf("Expected the container to still list the member, got %v", quads)
+	}
+
+	// POST to a new member creates it too, while POST to an existing one
+	// only adds to it
+	for _, status := range []int{http.StatusCreated, http.StatusNoContent} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, graphStoreRequest("POST", "/curation/posted", "text/turtle", "<> <http://www.w3.org/2000/01/rdf-schema#label> \"Posted\" .", "alice"))
+		if w.Code != status {
+			t.Errorf("Expected %d for POST to the member, got %d (%s)", status, w.Code, w.Body.String())
+		}
+	}
+	if quads, _ := s.Describe(context.Background(), "http://example.org/curation/"); len(quads) != 2 {
+		t.Errorf("Expected the container to list both members, got %v", quads)
+	}
+}
+
+func TestContainerHandlerAccessRules(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	store := &GraphStoreHandler{URIHost: "http://example.org", Store: s, Writers: []string{"authenticated"}, MaxUploadSize: 1024}
+	h := &ContainerHandler{URIHost: "http://example.org", Containers: []string{"/curation/"}, Source: s, Store: store,
+		Resources: http.NotFoundHandler()}
+	for _, path := range []string{"/curation/public", "/curation/secret"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, graphStoreRequest("PUT", path, "text/turtle", "<> <http://www.w3.org/2000/01/rdf-schema#label> \"Member\" .", "alice"))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected %s to be created, got %d (%s)", path, w.Code, w.Body.String())
+		}
+	}
+
+	// Members the client may not read are left out of the listing
+	auth := &Authenticator{APIKeys: map[string]string{"key": "bob"}, Rules: []AccessRule{{"/curation/secret", []string{"bob"}}}}
+	tests := map[string]bool{"": false, "key": true}
+	for key, listsSecret := range tests {
+		r := httptest.NewRequest("GET", "/curation/", nil)
+		r.Header.Set("Accept", "application/n-triples")
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		withAuth(auth, h).ServeHTTP(w, r)
+		body := w.Body.String()
+		if !strings.Contains(body, "http://example.org/curation/public") || strings.Contains(body, "http://example.org/curation/secret") != listsSecret {
+			t.Errorf("Expected the secret member to be listed with key %q: %v, got %s", key, listsSecret, body)
+		}
+	}
+}
+
+func TestContainerHandlerReadOnly(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	h := &ContainerHandler{URIHost: "http://example.org", Containers: []string{"/curation/"}, Source: s,
+		Resources: http.NotFoundHandler()}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, graphStoreRequest("POST", "/curation/", "text/turtle", "<> <http://example.org/p> \"A\" .", "alice"))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD, OPTIONS" || w.Header().Get("Accept-Post") != "" {
+		t.Errorf("Expected POST not to be allowed, got %d %v", w.Code, w.Header())
+	}
+	if container, isContainer := h.containerOf("/curation/a/b"); container != "" || isContainer {
+		t.Errorf("Expected only direct members to be in the container, got %q", container)
+	}
+}
@@ -1,30 +1,96 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/knakk/rdf"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		runConvert(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "load" {
+		runLoad(os.Args[2:])
+		return
+	}
+
 	// Set up flags
-	srcType := flag.String("srctype", "", "Type of data source. Can be one of: sparql, hdt")
+	srcType := flag.String("srctype", "", "Type of data source. Can be one of: sparql, hdt, file, store, federated, fallback")
 	urihost := flag.String("urihost", "", "Hostname for which to resolve URIs (without trailing slash)")
 	endpoint := flag.String("endpoint", "", "URL to a SPARQL 1.1 endpoint")
 	host := flag.String("host", "localhost", "Hostname where to run this service (without trailing slash)")
 	port := flag.String("port", "8080", "Port where this service should be exposed")
-	hdtFilePath := flag.String("hdtfile", "", "A (relative or full) path to an .hdt file")
-
-	// Parse flags
+	hdtFilePath := flag.String("hdtfile", "", "A (relative or full) path to an .hdt file, a symlink to one, or a directory (where the newest .hdt file is used). Several files, or glob patterns, can be given as a comma separated list, to serve them as one dataset")
+	rdfFilePath := flag.String("rdffile", "", "Comma separated list of RDF files (Turtle, N-Triples, N-Quads or RDF/XML), or glob patterns, to load into memory for the file source type")
+	storePath := flag.String("store", "", "Path to a store file, created with the load subcommand, for the store source type")
+	hdtReloadInterval := flag.Duration("hdt-reload-interval", time.Minute, "How often to check if the HDT file (or the -rdffile files) has changed, to reload it (0 means only reload on SIGHUP or POST /admin/reload)")
+	mementoSnapshots := flag.String("memento-snapshots", "", "Comma separated list of dated HDT snapshots of the dataset (or glob patterns) to serve as Mementos, for the hdt source type. Each may be prefixed with its date, as in 2023-05-01=chembl-33.hdt, otherwise the dcterms:issued date in the HDT header is used")
+	sources := flag.String("sources", "", "Comma separated list of name=location pairs, for the federated and fallback source types. Locations starting with http:// or https:// are SPARQL endpoints, others HDT files")
+	originGraphs := flag.Bool("origin-graphs", false, "Put each triple from a federated source in a named graph identifying the source it came from (<urihost>/.well-known/sources/<name>)")
+	fallbackTimeout := flag.Duration("fallback-timeout", 10*time.Second, "Maximum time to wait for a source before falling back to the next one, for the fallback source type (0 means no timeout)")
+	circuitFailures := flag.Int("circuit-failures", 5, "Number of consecutive failures after which a source of the fallback source type is skipped for -circuit-cooldown (0 means never)")
+	circuitCooldown := flag.Duration("circuit-cooldown", 30*time.Second, "How long to skip a failing source of the fallback source type, before trying it again")
+	writable := flag.Bool("writable", false, "Allow authenticated clients in -writers to change the data with the SPARQL 1.1 Graph Store HTTP Protocol, at /graph-store and at the URIs of resources (sparql and store source types only; requires -auth-rules)")
+	updateEndpoint := flag.String("update-endpoint", "", "URL to the SPARQL 1.1 Update endpoint to send changes to, with -writable (defaults to -endpoint)")
+	writers := flag.String("writers", "authenticated", "Comma separated list of who may change the data, with -writable: authenticated (any authenticated client), user names, or group names prefixed with group:")
+	adminUsers := flag.String("admin-users", "authenticated", "Comma separated list of who may reload the data files with a POST to /admin/reload: authenticated (any authenticated client), user names, or group names prefixed with group: (requires -auth-rules; without it, reload with SIGHUP)")
+	maxGraphTriples := flag.Int("max-graph-triples", 100000, "Maximum number of triples returned when reading a whole graph from /graph-store, with -writable (larger graphs are refused)")
+	maxUploadSize := flag.Int64("max-upload-size", 10<<20, "Maximum size in bytes of the RDF sent to change the data, with -writable")
+	ldpContainers := flag.String("ldp-containers", "", "Comma separated list of paths (e.g. /curation/) to serve as Linked Data Platform Basic Containers, listing their members, and creating new members on POST with -writable (sparql and store source types only)")
+	graphs := flag.String("graphs", "", "Comma separated list of named graphs to restrict resolution to (SPARQL only). If empty, the default graph and all named graphs are used")
+	queryTimeout := flag.Duration("query-timeout", 30*time.Second, "Maximum time allowed for the backend queries of a single request (0 means no timeout)")
+	readyTimeout := flag.Duration("ready-timeout", 5*time.Second, "Maximum time the /readyz endpoint waits for the data source to respond")
+	var timeouts ServerTimeouts
+	flag.DurationVar(&timeouts.ReadHeader, "read-header-timeout", 10*time.Second, "Maximum time for reading the headers of a request")
+	flag.DurationVar(&timeouts.Read, "read-timeout", 30*time.Second, "Maximum time for reading an entire request, including the body")
+	flag.DurationVar(&timeouts.Write, "write-timeout", 5*time.Minute, "Maximum time for writing a response (0 means no timeout)")
+	flag.DurationVar(&timeouts.Idle, "idle-timeout", 2*time.Minute, "Maximum time to keep idle keep-alive connections open")
+	flag.DurationVar(&timeouts.Shutdown, "shutdown-timeout", 30*time.Second, "Maximum time to wait for in-flight requests to finish on SIGTERM/SIGINT")
+	tlsCert := flag.String("tls-cert", "", "Path to a PEM encoded TLS certificate (chain), to serve HTTPS (and HTTP/2). Reloaded when changed on disk")
+	tlsKey := flag.String("tls-key", "", "Path to the PEM encoded private key for -tls-cert")
+	httpRedirectPort := flag.String("http-redirect-port", "", "If set (together with -tls-cert), also listen on this port with plain HTTP, redirecting to HTTPS")
+	rateLimit := flag.Float64("rate-limit", 0, "Maximum average number of requests per second allowed per client IP address (0 means no limit)")
+	rateBurst := flag.Int("rate-burst", 20, "Maximum number of requests a client may make in a burst, when -rate-limit is set")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma separated list of IP addresses or CIDR ranges of proxies trusted to set X-Forwarded-For")
+	maxSparqlQueries := flag.Int("max-sparql-queries", 16, "Maximum number of concurrent queries to the SPARQL endpoint (0 means no limit)")
+	maxHdtQueries := flag.Int("max-hdt-queries", 4, "Maximum number of concurrent lookups in the HDT file, each running two hdtSearch processes (0 means no limit)")
+	maxQueuedQueries := flag.Int("max-queued-queries", 64, "Maximum number of queries waiting for one of the concurrent query slots")
+	queueTimeout := flag.Duration("queue-timeout", 10*time.Second, "Maximum time a query waits for a concurrent query slot, before 503 is returned")
+	authRules := flag.String("auth-rules", "", "Path to a file with access rules, restricting path prefixes to certain users or groups. Authentication is disabled if empty")
+	apiKeysFile := flag.String("api-keys-file", "", "Path to a file with API keys (one \"name key\" pair per line), accepted in the X-API-Key header")
+	htpasswdFile := flag.String("htpasswd-file", "", "Path to an htpasswd file with users accepted with HTTP Basic authentication")
+	jwksFile := flag.String("jwks-file", "", "Path to a JSON Web Key Set file with the keys used to verify bearer JSON Web Tokens")
+	jwtIssuer := flag.String("jwt-issuer", "", "If set, only accept JSON Web Tokens from this issuer (iss claim)")
+	jwtAudience := flag.String("jwt-audience", "", "If set, only accept JSON Web Tokens for this audience (aud claim)")
+	corsOrigins := flag.String("cors-origins", "", "Comma separated list of origins allowed to make cross-origin (CORS) requests, e.g. https://app.example.org or * for any. CORS is disabled if empty")
+	corsMethods := flag.String("cors-methods", "GET,HEAD,OPTIONS", "Comma separated list of methods allowed in CORS requests")
+	corsHeaders := flag.String("cors-headers", "Accept,Accept-Datetime,Accept-Language,Authorization,Content-Type", "Comma separated list of request headers allowed in CORS requests")
+	corsExposedHeaders := flag.String("cors-exposed-headers", "Content-Type,Content-Location,Link,ETag,Last-Modified,Memento-Datetime,Warning,X-Urisolve-Source", "Comma separated list of response headers exposed to CORS clients")
+	corsCredentials := flag.Bool("cors-credentials", false, "Allow CORS requests with credentials (cookies, HTTP authentication), from the origins listed in -cors-origins (which may then not contain *)")
+	corsMaxAge := flag.Duration("cors-max-age", 10*time.Minute, "How long clients may cache the result of a CORS preflight request")
+	prefixList := flag.String("prefixes", "", "Comma separated list of prefix=namespace pairs, used to abbreviate IRIs in Turtle, TriG and HTML output")
+	prefixFile := flag.String("prefix-file", "", "Path to a file with prefixes, as downloaded from prefix.cc (JSON, Turtle, SPARQL or plain text)")
+	filterLanguages := flag.Bool("filter-languages", false, "Only return language-tagged literals in the languages accepted by the Accept-Language header of the request (the lang query parameter is always honoured)")
+	voidSparqlEndpoint := flag.String("void-sparql-endpoint", "", "Public SPARQL endpoint to advertise in the VoID description of the dataset (defaults to -endpoint for SPARQL sources)")
+	voidTPFEndpoint := flag.String("void-tpf-endpoint", "", "Triple Pattern Fragments endpoint to advertise in the VoID description of the dataset, if any")
+	jsonldContextFile := flag.String("jsonld-context", "", "Path to a JSON-LD context file, used to compact JSON-LD output. If empty, JSON-LD is written in expanded form")
+
+	// Parse flags, and let any flags not given on the command line be set
+	// with URISOLVE_OPT_* environment variables (e.g. URISOLVE_OPT_TLS_CERT)
 	flag.Parse()
+	if err := setFlagsFromEnv(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
 
 	// Handle flag errors
 	if *srcType == "sparql" {
@@ -35,29 +101,134 @@ func main() {
 		if *hdtFilePath == "" {
 			log.Fatal("No HDT file path specified! You have to specify a path to a .hdt file using the -hdtfile flag. Use -h to view options")
 		}
+		if *graphs != "" {
+			log.Fatal("HDT files have no named graphs, so -graphs can only be used with SPARQL endpoints. Use -h to view options")
+		}
+	} else if *srcType == "file" {
+		if *rdfFilePath == "" {
+			log.Fatal("No RDF files specified! You have to specify the files to load using the -rdffile flag. Use -h to view options")
+		}
+	} else if *srcType == "store" {
+		if *storePath == "" {
+			log.Fatal("No store specified! You have to specify the store file using the -store flag. Use -h to view options")
+		}
+	} else if *srcType == "federated" || *srcType == "fallback" {
+		if *sources == "" {
+			log.Fatal("No sources specified! You have to specify the data sources using the -sources flag. Use -h to view options")
+		}
 	} else {
-		log.Fatal("Invalid source type specified. You have to use the -srctype flag to specify either 'sparql' or 'hdt'. Use -h to view options")
+		log.Fatal("Invalid source type specified. You have to use the -srctype flag to specify either 'sparql', 'hdt', 'file', 'store', 'federated' or 'fallback'. Use -h to view options")
+	}
+	if *mementoSnapshots != "" && *srcType != "hdt" {
+		log.Fatal("-memento-snapshots can only be used with the hdt source type. Use -h to view options")
+	}
+	if *originGraphs && *srcType != "federated" {
+		log.Fatal("-origin-graphs can only be used with the federated source type. Use -h to view options")
 	}
 
 	if *urihost == "" {
 		log.Fatal("No urihost provided. Use the -h flag to view options")
 	}
 
-	// Allow setting the default home page
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("Both -tls-cert and -tls-key have to be specified in order to serve HTTPS. Use the -h flag to view options")
+	}
+	if *httpRedirectPort != "" && *tlsCert == "" {
+		log.Fatal("-http-redirect-port can only be used together with -tls-cert and -tls-key. Use the -h flag to view options")
+	}
+
+	if *corsCredentials && containsFold(splitList(*corsOrigins), "*") {
+		log.Fatal("-cors-credentials can not be used with * in -cors-origins, since any website could then read restricted data with the credentials of its visitors. List the allowed origins instead. Use -h to view options")
+	}
+
+	trustedProxyNets, err := parseCIDRs(splitList(*trustedProxies))
+	if err != nil {
+		log.Fatal("Invalid -trusted-proxies: " + err.Error())
+	}
+
+	if *writable && *srcType != "sparql" && *srcType != "store" {
+		log.Fatal("-writable can only be used with the sparql and store source types. Use -h to view options")
+	}
+	if *ldpContainers != "" && *srcType != "sparql" && *srcType != "store" {
+		log.Fatal("-ldp-containers can only be used with the sparql and store source types. Use -h to view options")
+	}
+	for _, container := range splitList(*ldpContainers) {
+		if !strings.HasPrefix(container, "/") || container == "/" {
+			log.Fatal("Invalid container in -ldp-containers: " + container + " (it has to be a path, starting with /). Use -h to view options")
+		}
+	}
+	if *writable && *authRules == "" {
+		log.Fatal("Changing data requires authentication, so -writable can only be used together with -auth-rules. Use -h to view options")
+	}
+
+	var auth *Authenticator
+	if *authRules != "" {
+		auth, err = newAuthenticator(*authRules, *apiKeysFile, *htpasswdFile, *jwksFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		auth.JWTIssuer = *jwtIssuer
+		auth.JWTAudience = *jwtAudience
+	} else if *apiKeysFile != "" || *htpasswdFile != "" || *jwksFile != "" {
+		log.Fatal("No access rules specified. Use -auth-rules to specify which paths require authentication. Use -h to view options")
+	}
+
+	// Prefixes for common vocabularies are always available. Prefixes
+	// declared in the HDT header, in the prefix file and on the command line
+	// override them, in that order.
+	output := OutputOptions{Prefixes: make(PrefixMap), FilterLanguages: *filterLanguages}
+	output.Prefixes.merge(defaultPrefixes)
+	if *srcType == "hdt" {
+		hdtFiles, err := resolveHdtFiles(*hdtFilePath)
+		if err != nil {
+			log.Fatal("Could not find the HDT files: " + err.Error() + ". Use -h to view options")
+		}
+		for _, hdtFile := range hdtFiles {
+			hdtPrefixes, err := hdtHeaderPrefixes(hdtFile)
+			if err != nil {
+				log.Println("Could not read prefixes from the HDT header: " + err.Error())
+			}
+			output.Prefixes.merge(hdtPrefixes)
+		}
+	}
+	if *prefixFile != "" {
+		filePrefixes, err := loadPrefixFile(*prefixFile)
+		if err != nil {
+			log.Fatal("Could not load -prefix-file: " + err.Error())
+		}
+		output.Prefixes.merge(filePrefixes)
+	}
+	flagPrefixes, err := parsePrefixList(*prefixList)
+	if err != nil {
+		log.Fatal("Invalid -prefixes: " + err.Error() + ". Use -h to view options")
+	}
+	output.Prefixes.merge(flagPrefixes)
+	if *jsonldContextFile != "" {
+		output.JSONLDContext, err = loadJSONLDContext(*jsonldContextFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Allow replacing the generated HTML dataset description with a custom
+	// home page
 	homePageHtml := os.Getenv("URISOLVE_HOMEPAGEHTML")
-	if homePageHtml == "" {
-		homePageHtml = `<html>
-		<head>
-			<title>URI Resolver service</title>
-			<style>
-				body { font-family: arial, helvetica, sans-serif; }
-			</style>
-		</head>
-		<body>
-			<h1>Welcome to the URI resolver service</h1>
-			<p>Specify a specific URL in order to view data</p>
-		</body>
-	</html>`
+
+	// Rate limit clients and check their access, if asked to (but not for
+	// the health endpoints). All endpoints share the same limiter, so that
+	// a client's requests count against one limit wherever they go.
+	var rateLimiter *clientRateLimiter
+	if *rateLimit > 0 {
+		rateLimiter = newClientRateLimiter(*rateLimit, *rateBurst)
+	}
+	protect := func(handler http.Handler) http.Handler {
+		if auth != nil {
+			handler = withAuth(auth, handler)
+		}
+		if rateLimiter == nil {
+			return handler
+		}
+		return withRateLimit(rateLimiter, trustedProxyNets, handler)
 	}
 
 	// Execute the relevant HTTP handler, based on the source type selected
@@ -67,151 +238,357 @@ func main() {
 		fmt.Println("Starting to serve at: " + *host + ":" + *port + " ...")
 
 		// Start handling requests
-		uriResHandlerSparql := &URIResolverHandlerSparql{*urihost, *endpoint, homePageHtml}
-		http.Handle("/", uriResHandlerSparql)
+		sparqlSource := &SparqlSource{*endpoint, splitList(*graphs)}
+		limiter := newConcurrencyLimiter(*maxSparqlQueries, *maxQueuedQueries, *queueTimeout)
+		uriResHandler := &URIResolverHandler{*urihost, sparqlSource, homePageHtml, *queryTimeout, limiter, output}
+		if *voidSparqlEndpoint == "" {
+			*voidSparqlEndpoint = *endpoint
+		}
+		voidHandler := &VoIDHandler{URIHost: *urihost, Source: sparqlSource, SparqlEndpoint: *voidSparqlEndpoint, TPFEndpoint: *voidTPFEndpoint,
+			HomePageContent: homePageHtml, QueryTimeout: *queryTimeout, Limiter: limiter, Output: output}
+		browseHandler := &BrowseHandler{URIHost: *urihost, Browser: sparqlSource, QueryTimeout: *queryTimeout, Limiter: limiter, Output: output}
+		var handler http.Handler = withDatasetDescription(voidHandler, uriResHandler)
+		var graphStoreHandler *GraphStoreHandler
+		if *writable {
+			if *updateEndpoint == "" {
+				*updateEndpoint = *endpoint
+			}
+			graphStoreHandler = &GraphStoreHandler{URIHost: *urihost, Store: &SparqlGraphStore{sparqlSource, *updateEndpoint}, Auth: auth,
+				Writers: splitList(*writers), MaxUploadSize: *maxUploadSize, MaxGraphTriples: *maxGraphTriples, QueryTimeout: *queryTimeout, Limiter: limiter, Output: output,
+				OnChange: []func(){voidHandler.reset, browseHandler.reset}}
+			http.Handle("/graph-store", protect(graphStoreHandler))
+			handler = withGraphStore(graphStoreHandler, handler)
+			fmt.Println("Sending changes to the SPARQL Update endpoint: " + *updateEndpoint)
+		}
+		if *ldpContainers != "" {
+			handler = &ContainerHandler{URIHost: *urihost, Containers: splitList(*ldpContainers), Source: sparqlSource, Store: graphStoreHandler,
+				QueryTimeout: *queryTimeout, Limiter: limiter, Output: output, Resources: handler}
+		}
+		http.Handle("/", protect(handler))
+		http.Handle("/.well-known/void", protect(voidHandler))
+		http.Handle("/search", protect(&SearchHandler{*urihost, sparqlSource, *queryTimeout, limiter}))
+		http.Handle("/browse/", protect(browseHandler))
+		http.Handle("/readyz", &ReadyzHandler{sparqlSource, *readyTimeout})
 	} else if *srcType == "hdt" {
+		hdtSource := &HdtSource{FilePath: *hdtFilePath}
+		if _, err := hdtSource.Reload(); err != nil {
+			log.Fatal("Could not load the HDT files: " + err.Error())
+		}
+
 		// Print some output to the console
-		fmt.Println("Using the following HDT for querying: ", *hdtFilePath)
+		fmt.Println("Using the following HDT for querying: ", strings.Join(hdtSource.paths(), ", "))
 		fmt.Println("Starting to serve at: " + *host + ":" + *port + " ...")
 
 		// Start handling requests
-		uriResHandlerHdt := &URIResolverHandlerHdt{*urihost, *hdtFilePath, homePageHtml}
-		http.Handle("/", uriResHandlerHdt)
-	}
-
-	// Start serving requests
-	err := http.ListenAndServe(*host+":"+*port, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
+		limiter := newConcurrencyLimiter(*maxHdtQueries, *maxQueuedQueries, *queueTimeout)
+		uriResHandler := &URIResolverHandler{*urihost, hdtSource, homePageHtml, *queryTimeout, limiter, output}
+		voidHandler := &VoIDHandler{URIHost: *urihost, Source: hdtSource, SparqlEndpoint: *voidSparqlEndpoint, TPFEndpoint: *voidTPFEndpoint,
+			HomePageContent: homePageHtml, QueryTimeout: *queryTimeout, Limiter: limiter, Output: output}
+		browseHandler := &BrowseHandler{URIHost: *urihost, Browser: hdtSource, QueryTimeout: *queryTimeout, Limiter: limiter, Output: output}
+		var handler http.Handler = withDatasetDescription(voidHandler, uriResHandler)
+		if *mementoSnapshots != "" {
+			snapshots, err := loadSnapshots(*mementoSnapshots)
+			if err != nil {
+				log.Fatal("Could not load the Memento snapshots: " + err.Error())
+			}
+			for _, snapshot := range snapshots {
+				fmt.Println("Serving Mementos from " + snapshot.Datetime.Format(time.RFC3339) + " with: " + snapshot.Path)
+			}
+			handler = &MementoHandler{URIHost: *urihost, Snapshots: snapshots, QueryTimeout: *queryTimeout, Limiter: limiter, Output: output, Resources: handler}
+		}
+		http.Handle("/", protect(handler))
+		http.Handle("/.well-known/void", protect(voidHandler))
+		http.Handle("/browse/", protect(browseHandler))
+		http.Handle("/readyz", &ReadyzHandler{hdtSource, *readyTimeout})
 
-// URIResolverHandlerSparql handles RDF URI:s and writes out RDF with any triples
-// connected to the URI in question, to w, based on information in a SPARQL
-// endpoint as indicated with the SparqlEndpointUrl field, which has to be set
-// upon creating a new URIResolverHandlerSparql.
-type URIResolverHandlerSparql struct {
-	URIHost           string
-	SparqlEndpointUrl string
-	HomePageContent   string
-}
+		// Index the literals in the HDT file for searching
+		searchIndex, err := newHdtSearchIndex(hdtSource)
+		if err != nil {
+			log.Fatal("Could not build the search index: " + err.Error())
+		}
+		fmt.Printf("Indexed %d literals for searching\n", len(searchIndex.literals))
+		http.Handle("/search", protect(&SearchHandler{*urihost, searchIndex, *queryTimeout, limiter}))
+
+		// Switch to new versions of the HDT files when asked to, or when they
+		// change, and recompute everything computed from the previous ones
+		reloader := &Reloader{Source: hdtSource, Auth: auth, Admins: splitList(*adminUsers), OnReload: []func(){
+			voidHandler.reset,
+			browseHandler.reset,
+			func() {
+				if err := searchIndex.rebuild(); err != nil {
+					log.Println("Could not rebuild the search index, keeping the current one: " + err.Error())
+				}
+			},
+		}}
+		http.Handle("/admin/reload", protect(reloader))
+		go reloader.reloadOnSIGHUP()
+		if *hdtReloadInterval > 0 {
+			go reloader.watch(*hdtReloadInterval)
+		}
+	} else if *srcType == "file" {
+		fileSource := &FileSource{FilePath: *rdfFilePath}
+		if _, err := fileSource.Reload(); err != nil {
+			log.Fatal("Could not load the RDF files: " + err.Error())
+		}
 
-func (h *URIResolverHandlerSparql) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path[1:]
+		// Print some output to the console
+		fmt.Println("Loaded the RDF files: ", strings.Join(fileSource.paths(), ", "))
+		fmt.Println("Starting to serve at: " + *host + ":" + *port + " ...")
 
-	if path == "" { // Handle the home page URL
-		w.Write([]byte(h.HomePageContent))
-		return
-	}
+		// Start handling requests. The data is in memory, so the number of
+		// concurrent queries is not limited.
+		uriResHandler := &URIResolverHandler{*urihost, fileSource, homePageHtml, *queryTimeout, nil, output}
+		voidHandler := &VoIDHandler{URIHost: *urihost, Source: fileSource, SparqlEndpoint: *voidSparqlEndpoint, TPFEndpoint: *voidTPFEndpoint,
+			HomePageContent: homePageHtml, QueryTimeout: *queryTimeout, Output: output}
+		browseHandler := &BrowseHandler{URIHost: *urihost, Browser: fileSource, QueryTimeout: *queryTimeout, Output: output}
+		http.Handle("/", protect(withDatasetDescription(voidHandler, uriResHandler)))
+		http.Handle("/.well-known/void", protect(voidHandler))
+		http.Handle("/browse/", protect(browseHandler))
+		http.Handle("/readyz", &ReadyzHandler{fileSource, *readyTimeout})
+
+		// Load the files again when asked to, or when they change
+		reloader := &Reloader{Source: fileSource, Auth: auth, Admins: splitList(*adminUsers), OnReload: []func(){voidHandler.reset, browseHandler.reset}}
+		http.Handle("/admin/reload", protect(reloader))
+		go reloader.reloadOnSIGHUP()
+		if *hdtReloadInterval > 0 {
+			go reloader.watch(*hdtReloadInterval)
+		}
+	} else if *srcType == "store" {
+		storeSource, err := openStore(*storePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer storeSource.Close()
 
-	uri := h.URIHost + "/" + path
+		// Print some output to the console
+		fmt.Println("Using the store: " + *storePath)
+		fmt.Println("Starting to serve at: " + *host + ":" + *port + " ...")
 
-	sparqlQuery := `query=DESCRIBE <` + uri + `>`
+		// Start handling requests. Lookups only read the parts of the store
+		// they need, so the number of concurrent queries is not limited.
+		uriResHandler := &URIResolverHandler{*urihost, storeSource, homePageHtml, *queryTimeout, nil, output}
+		voidHandler := &VoIDHandler{URIHost: *urihost, Source: storeSource, SparqlEndpoint: *voidSparqlEndpoint, TPFEndpoint: *voidTPFEndpoint,
+			HomePageContent: homePageHtml, QueryTimeout: *queryTimeout, Output: output}
+		browseHandler := &BrowseHandler{URIHost: *urihost, Browser: storeSource, QueryTimeout: *queryTimeout, Output: output}
+		var handler http.Handler = withDatasetDescription(voidHandler, uriResHandler)
+		var graphStoreHandler *GraphStoreHandler
+		if *writable {
+			graphStoreHandler = &GraphStoreHandler{URIHost: *urihost, Store: storeSource, Auth: auth,
+				Writers: splitList(*writers), MaxUploadSize: *maxUploadSize, MaxGraphTriples: *maxGraphTriples, QueryTimeout: *queryTimeout, Output: output,
+				OnChange: []func(){voidHandler.reset, browseHandler.reset}}
+			http.Handle("/graph-store", protect(graphStoreHandler))
+			handler = withGraphStore(graphStoreHandler, handler)
+		}
+		if *ldpContainers != "" {
+			handler = &ContainerHandler{URIHost: *urihost, Containers: splitList(*ldpContainers), Source: storeSource, Store: graphStoreHandler,
+				QueryTimeout: *queryTimeout, Output: output, Resources: handler}
+		}
+		http.Handle("/", protect(handler))
+		http.Handle("/.well-known/void", protect(voidHandler))
+		http.Handle("/browse/", protect(browseHandler))
+		http.Handle("/readyz", &ReadyzHandler{storeSource, *readyTimeout})
+	} else if *srcType == "federated" {
+		members, err := parseNamedSources(*sources, splitList(*graphs))
+		if err != nil {
+			log.Fatal("Invalid -sources: " + err.Error() + ". Use -h to view options")
+		}
+		// Print some output to the console
+		for _, m := range members {
+			fmt.Println("Federating data source:", m.Name)
+		}
+		fmt.Println("Starting to serve at: " + *host + ":" + *port + " ...")
 
-	fmt.Println("Querying " + h.SparqlEndpointUrl + " with the following parameters:")
-	fmt.Println(sparqlQuery)
+		// Start handling requests
+		federatedSource := &FederatedSource{Members: members}
+		if *originGraphs {
+			federatedSource.OriginGraphPrefix = *urihost + "/.well-known/sources/"
+		}
+		limiter := newConcurrencyLimiter(*maxSparqlQueries, *maxQueuedQueries, *queueTimeout)
+		http.Handle("/", protect(&URIResolverHandler{*urihost, federatedSource, homePageHtml, *queryTimeout, limiter, output}))
+		http.Handle("/readyz", &ReadyzHandler{federatedSource, *readyTimeout})
+	} else if *srcType == "fallback" {
+		namedSources, err := parseNamedSources(*sources, splitList(*graphs))
+		if err != nil {
+			log.Fatal("Invalid -sources: " + err.Error() + ". Use -h to view options")
+		}
+		// Print some output to the console
+		for i, source := range namedSources {
+			fmt.Printf("Data source %d: %s\n", i+1, source.Name)
+		}
+		fmt.Println("Starting to serve at: " + *host + ":" + *port + " ...")
 
-	reader := strings.NewReader(sparqlQuery)
-	request, err := http.NewRequest("POST", h.SparqlEndpointUrl, reader)
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	if err != nil {
-		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
-		return
+		// Start handling requests
+		failoverSource := newFailoverSource(namedSources, *fallbackTimeout, *circuitFailures, *circuitCooldown)
+		limiter := newConcurrencyLimiter(*maxSparqlQueries, *maxQueuedQueries, *queueTimeout)
+		http.Handle("/", protect(&URIResolverHandler{*urihost, failoverSource, homePageHtml, *queryTimeout, limiter, output}))
+		http.Handle("/readyz", &ReadyzHandler{failoverSource, *readyTimeout})
 	}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
-		return
+	// Liveness probe, which does not depend on the data source
+	http.Handle("/healthz", &HealthzHandler{})
+
+	var handler http.Handler = http.DefaultServeMux
+	if *corsOrigins != "" {
+		handler = withCORS(CORSOptions{
+			AllowedOrigins:   splitList(*corsOrigins),
+			AllowedMethods:   splitList(*corsMethods),
+			AllowedHeaders:   splitList(*corsHeaders),
+			ExposedHeaders:   splitList(*corsExposedHeaders),
+			AllowCredentials: *corsCredentials,
+			MaxAge:           *corsMaxAge,
+		}, handler)
 	}
 
-	// Just forward the raw RDF/XML from Blazegraph
-	_, err = io.Copy(w, response.Body)
-	if err != nil {
-		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
-		return
+	// Start serving requests, until we get told to shut down
+	srv, cancelRequests := newServer(*host+":"+*port, handler, timeouts)
+	serve := srv.ListenAndServe
+	if *tlsCert != "" {
+		certs, err := newCertReloader(*tlsCert, *tlsKey, 30*time.Second)
+		if err != nil {
+			log.Fatal("Could not load TLS certificate: " + err.Error())
+		}
+		srv.TLSConfig = newTLSConfig(certs)
+		serve = func() error {
+			return srv.ListenAndServeTLS("", "")
+		}
+		fmt.Println("Serving HTTPS, using the certificate in: " + *tlsCert)
+
+		if *httpRedirectPort != "" {
+			redirectSrv, _ := newServer(*host+":"+*httpRedirectPort, &HTTPSRedirectHandler{*port}, timeouts)
+			go func() {
+				err := redirectSrv.ListenAndServe()
+				if err != nil && err != http.ErrServerClosed {
+					log.Fatal(err)
+				}
+			}()
+			defer redirectSrv.Close()
+			fmt.Println("Redirecting HTTP to HTTPS at: " + *host + ":" + *httpRedirectPort)
+		}
+	}
+	err = serveUntilSignalled(srv, serve, timeouts.Shutdown, cancelRequests)
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
 	}
 }
 
-// URIResolverHandlerHdt handles RDF URI:s and writes out RDF with any triples
-// connected to the URI in question, to w, based on information in a (RDF)HDT
-// dataset file. You can find more info about hDT at http://www.rdfhdt.org
-type URIResolverHandlerHdt struct {
+// URIResolverHandler handles RDF URI:s and writes out RDF with any triples
+// connected to the URI in question, to w, based on information in Source.
+// The RDF serialization is negotiated with the Accept header. Queries taking
+// longer than QueryTimeout (if non-zero) are aborted, and the number of
+// concurrent queries is capped by Limiter (if not nil). Output configures
+// the serializations, e.g. with a JSON-LD context.
+type URIResolverHandler struct {
 	URIHost         string
-	HdtFilePath     string
+	Source          Source
 	HomePageContent string
+	QueryTimeout    time.Duration
+	Limiter         *concurrencyLimiter
+	Output          OutputOptions
 }
 
-func (h *URIResolverHandlerHdt) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *URIResolverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path[1:]
 
 	if path == "" { // Handle the home page URL
 		w.Write([]byte(h.HomePageContent))
 		return
 	}
+	if path == "favicon.ico" {
+		http.NotFound(w, r)
+		return
+	}
 
-	enc := rdf.NewTripleEncoder(w, rdf.NTriples)
-
-	var triples []rdf.Triple
+	uri := h.URIHost + "/" + path
+	if !validUri(uri) {
+		http.Error(w, "Error: Invalid URI (invalid characters in URI)", http.StatusBadRequest)
+		return
+	}
 
-	if path != "favicon.ico" {
-		uri := h.URIHost + "/" + r.URL.Path[1:]
-		if !validUri(uri) {
-			http.Error(w, "Error: Invalid URI (invalid characters in URI)", http.StatusBadRequest)
-			return
-		}
-		newTriples, err := h.runHdtQuery(uri + " ? ?")
-		if err != nil {
-			http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		triples = append(triples, newTriples...)
-		newTriples, err = h.runHdtQuery("? ? " + uri)
-		if err != nil {
-			http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		triples = append(triples, newTriples...)
-		if len(triples) == 0 {
-			http.Error(w, "Could not find any triples linking to this URI", http.StatusNotFound)
-			return
-		}
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Accept-Language")
+	format, mediaType, params := negotiateFormat(r.Header.Get("Accept"))
+	if format == nil {
+		http.Error(w, "Error: None of the requested formats are supported. Supported formats are: "+strings.Join(supportedMediaTypes(), ", "), http.StatusNotAcceptable)
+		return
 	}
 
-	for _, triple := range triples {
-		err := enc.Encode(triple)
-		if err != nil {
-			http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
-			return
+	if !acquireBackend(w, r, h.Limiter) {
+		return
+	}
+	defer h.Limiter.release()
+
+	ctx, cancel := withQueryTimeout(r.Context(), h.QueryTimeout)
+	defer cancel()
+	var quads []rdf.Quad
+	var err error
+	if picker, ok := h.Source.(SourcePicker); ok {
+		var name string
+		quads, name, err = picker.DescribeFrom(ctx, uri)
+		if name != "" {
+			w.Header().Set("X-Urisolve-Source", name)
 		}
+	} else {
+		quads, err = h.Source.Describe(ctx, uri)
+	}
+	if partial, ok := err.(*PartialResultError); ok {
+		// Serve what was found, but tell the client that it may be incomplete
+		partial.writeWarnings(w)
+		err = nil
+	}
+	if err != nil {
+		writeBackendError(w, ctx, err)
+		return
+	}
+	if len(quads) == 0 {
+		http.Error(w, "Could not find any triples linking to this URI", http.StatusNotFound)
+		return
 	}
-	enc.Close()
-}
 
-func (h *URIResolverHandlerHdt) runHdtQuery(query string) ([]rdf.Triple, error) {
-	var triples []rdf.Triple
+	// Drop literals in other languages than the requested ones, if asked to
+	ranges := requestedLanguages(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"), h.Output.FilterLanguages)
+	if ranges != nil {
+		quads = filterLanguages(quads, ranges)
+	}
 
-	Cmd := exec.Command("hdtSearch", "-q", query, h.HdtFilePath)
-	hdtOut, err := Cmd.Output()
+	w.Header().Set("Content-Type", mediaType)
+	opts := &writeOptions{OutputOptions: h.Output, Resource: uri, Params: params}
+	if labeler, ok := h.Source.(Labeler); ok {
+		opts.Labels = func(iris []string) map[string]string {
+			return lookupLabels(ctx, labeler, h.URIHost, iris, r.Header.Get("Accept-Language"))
+		}
+	}
+	err = format.Write(w, quads, opts)
 	if err != nil {
-		return nil, err
+		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+}
 
-	lines := strings.Split(string(hdtOut), "\n")
-	for _, line := range lines {
-		for _, l := range strings.Split(line, "\r") {
-			if len(l) >= 4 && l[0:4] == "http" {
-				triple, err := h.strToTriple(l)
-				if err != nil {
-					return nil, err
-				}
-				triples = append(triples, triple)
-			}
-		}
+// withQueryTimeout returns a context for the backend queries of a request,
+// which is cancelled after timeout, unless timeout is zero
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
+}
 
-	return triples, nil
+// writeBackendError writes an error response for err, returned from a backend
+// query run with ctx. If the query was aborted because it ran out of time,
+// 504 Gateway Timeout is returned, rather than a generic server error.
+func writeBackendError(w http.ResponseWriter, ctx context.Context, err error) {
+	if err == errNoSourceAvailable {
+		http.Error(w, "Error: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		http.Error(w, "Error: The data source did not answer in time", http.StatusGatewayTimeout)
+	case context.Canceled:
+		http.Error(w, "Error: The request was cancelled", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
+	}
 }
 
 func validUri(uri string) bool {
@@ -223,40 +600,3 @@ func validUri(uri string) bool {
 	return validRegexp.MatchString(uri)
 
 }
-
-func (h *URIResolverHandlerHdt) strToTriple(line string) (rdf.Triple, error) {
-	var triple rdf.Triple
-
-	terms := strings.Split(line, " ")
-	if len(terms) >= 3 {
-		sRaw := terms[0]
-		pRaw := terms[1]
-		oRaw := terms[2]
-
-		s, err := rdf.NewIRI(sRaw)
-		if err != nil {
-			return rdf.Triple{}, fmt.Errorf("Could not convert subject to IRI: %s (%s)", sRaw, err.Error())
-		}
-
-		p, err := rdf.NewIRI(pRaw)
-		if err != nil {
-			return rdf.Triple{}, fmt.Errorf("Could not convert predicate to IRI: %s (%s)", pRaw, err.Error())
-		}
-
-		if oRaw[0:1] == "h" {
-			o, err := rdf.NewIRI(oRaw)
-			if err != nil {
-				return rdf.Triple{}, fmt.Errorf("Could not convert object to IRI: %s (%s)", oRaw, err.Error())
-			}
-			triple = rdf.Triple{s, p, o}
-		} else if oRaw[0:1] == "\"" {
-			o, err := rdf.NewLiteral(oRaw)
-			if err != nil {
-				return rdf.Triple{}, fmt.Errorf("Could not convert object to Literal: %s (%s)", oRaw, err.Error())
-			}
-			triple = rdf.Triple{s, p, o}
-		}
-	}
-
-	return triple, nil
-}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestValidUri(t *testing.T) {
@@ -20,3 +23,21 @@ func TestValidUri(t *testing.T) {
 		}
 	}
 }
+
+func TestSparqlQueryTimeout(t *testing.T) {
+	slowEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slowEndpoint.Close()
+
+	h := &URIResolverHandler{"http://ex.org", &SparqlSource{slowEndpoint.URL, nil}, "", 50 * time.Millisecond, nil, OutputOptions{}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/cplogd/Compound1", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status %d for a slow SPARQL endpoint, got %d", http.StatusGatewayTimeout, rec.Code)
+	}
+}
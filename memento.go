@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mementoTimeLayout is the layout of the datetimes in the URIs of Mementos,
+// e.g. /memento/20230501000000/cplogd/CPD-1
+const mementoTimeLayout = "20060102150405"
+
+// issuedLayouts are the layouts tried for the dcterms:issued date in HDT
+// headers, and for the dates given with snapshots
+var issuedLayouts = []string{"2006-01-02T15:04:05-0700", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// MementoSnapshot is a dated snapshot of the dataset, served as Mementos
+type MementoSnapshot struct {
+	Datetime time.Time
+	Path     string
+	Source   Source
+}
+
+// parseIssued parses a date as in the dcterms:issued of an HDT header
+func parseIssued(s string) (time.Time, error) {
+	for _, layout := range issuedLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid date: %s", s)
+}
+
+// hdtIssued returns the date an HDT file was issued, from the dcterms:issued
+// of its header
+func hdtIssued(path string) (time.Time, error) {
+	header, err := readHdtHeader(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, t := range header {
+		if t.Pred.String() == dctermsIssued {
+			return parseIssued(t.Obj.String())
+		}
+	}
+	return time.Time{}, fmt.Errorf("No dcterms:issued date in the header of %s, so give the date of the snapshot as date=path", path)
+}
+
+// loadSnapshots loads the HDT snapshots in the comma separated list list,
+// oldest first. Each item is an HDT file, or a glob pattern, optionally
+// prefixed with the date of the snapshot, as in 2023-05-01=chembl-33.hdt.
+// Without a date, the dcterms:issued date in the header of the file is used.
+func loadSnapshots(list string) ([]MementoSnapshot, error) {
+	var snapshots []MementoSnapshot
+	for _, item := range splitList(list) {
+		var date time.Time
+		if i := strings.Index(item, "="); i >= 0 {
+			var err error
+			if date, err = parseIssued(item[:i]); err != nil {
+				return nil, err
+			}
+			item = item[i+1:]
+		}
+		files, err := resolveHdtFiles(item)
+		if err != nil {
+			return nil, err
+		}
+		if !date.IsZero() && len(files) > 1 {
+			return nil, fmt.Errorf("A date can only be given for a single file, but %s matches several", item)
+		}
+		for _, file := range files {
+			datetime := date
+			if datetime.IsZero() {
+				if datetime, err = hdtIssued(file); err != nil {
+					return nil, err
+				}
+			}
+			source := &HdtSource{FilePath: file}
+			if _, err := source.Reload(); err != nil {
+				return nil, err
+			}
+			snapshots = append(snapshots, MementoSnapshot{datetime.Truncate(time.Second), file, source})
+		}
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Datetime.Before(snapshots[j].Datetime)
+	})
+	for i := 1; i < len(snapshots); i++ {
+		if snapshots[i].Datetime.Equal(snapshots[i-1].Datetime) {
+			return nil, fmt.Errorf("The snapshots %s and %s have the same date", snapshots[i-1].Path, snapshots[i].Path)
+		}
+	}
+	return snapshots, nil
+}
+
+// MementoHandler serves Snapshots (oldest first) as Mementos of the
+// resources served by Resources, following RFC 7089 (Memento): for the
+// resource at /path, the TimeGate at /timegate/path redirects to the
+// Memento for the datetime asked for with the Accept-Datetime header, the
+// TimeMap at /timemap/path lists the Mementos, and the Memento of a snapshot
+// is served at /memento/<datetime>/path, with a Memento-Datetime header.
+// Responses for the resources link to their TimeGate and TimeMap. Since the
+// access rules are checked by withAuth for the requested path, the rule for
+// the resource itself is checked for its TimeGate, TimeMap and Mementos.
+type MementoHandler struct {
+	URIHost      string
+	Snapshots    []MementoSnapshot
+	QueryTimeout time.Duration
+	Limiter      *concurrencyLimiter
+	Output       OutputOptions
+	Resources    http.Handler
+}
+
+func (h *MementoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/timegate/"):
+		h.serveTimeGate(w, r, strings.TrimPrefix(path, "/timegate"))
+	case strings.HasPrefix(path, "/timemap/"):
+		h.serveTimeMap(w, r, strings.TrimPrefix(path, "/timemap"))
+	case strings.HasPrefix(path, "/memento/"):
+		h.serveMemento(w, r, strings.TrimPrefix(path, "/memento/"))
+	default:
+		if path != "/" {
+			w.Header().Add("Link", h.links(path, "timegate", "timemap"))
+		}
+		h.Resources.ServeHTTP(w, r)
+	}
+}
+
+// mementoURI returns the URI of the Memento of the resource at path, in
+// snapshot
+func (h *MementoHandler) mementoURI(path string, snapshot MementoSnapshot) string {
+	return h.URIHost + "/memento/" + snapshot.Datetime.UTC().Format(mementoTimeLayout) + path
+}
+
+// links returns a Link header value linking to the original resource at
+// path, its TimeGate and its TimeMap, as listed in rels
+func (h *MementoHandler) links(path string, rels ...string) string {
+	var links []string
+	for _, rel := range rels {
+		switch rel {
+		case "original":
+			links = append(links, "<"+h.URIHost+path+">; rel=\"original\"")
+		case "timegate":
+			links = append(links, "<"+h.URIHost+"/timegate"+path+">; rel=\"timegate\"")
+		case "timemap":
+			links = append(links, "<"+h.URIHost+"/timemap"+path+">; rel=\"timemap\"; type=\"application/link-format\"")
+		}
+	}
+	return strings.Join(links, ", ")
+}
+
+// validResourcePath checks that path is the path of a resource, which the
+// client may access, answering 404, 400, 401 or 403 if not
+func (h *MementoHandler) validResourcePath(w http.ResponseWriter, r *http.Request, path string) bool {
+	if path == "" || path == "/" {
+		http.Error(w, "Error: No resource given", http.StatusNotFound)
+		return false
+	}
+	if !validUri(h.URIHost + path) {
+		http.Error(w, "Error: Invalid URI (invalid characters in URI)", http.StatusBadRequest)
+		return false
+	}
+	return authenticatorFromContext(r.Context()).allowAccess(w, path, principalFromContext(r.Context()))
+}
+
+// serveTimeGate redirects to the Memento of the resource at path in the
+// last snapshot at or before the datetime in the Accept-Datetime header (or
+// in the first snapshot, if it is older than all of them). Without
+// Accept-Datetime, it redirects to the most recent Memento.
+func (h *MementoHandler) serveTimeGate(w http.ResponseWriter, r *http.Request, path string) {
+	if !h.validResourcePath(w, r, path) {
+		return
+	}
+	w.Header().Add("Vary", "Accept-Datetime")
+	w.Header().Add("Link", h.links(path, "original", "timemap"))
+	snapshot := h.Snapshots[len(h.Snapshots)-1]
+	if accept := r.Header.Get("Accept-Datetime"); accept != "" {
+		datetime, err := http.ParseTime(accept)
+		if err != nil {
+			http.Error(w, "Error: Invalid Accept-Datetime, it has to be an HTTP date, e.g. "+time.Now().UTC().Format(http.TimeFormat), http.StatusBadRequest)
+			return
+		}
+		i := sort.Search(len(h.Snapshots), func(i int) bool {
+			return h.Snapshots[i].Datetime.After(datetime)
+		})
+		if i > 0 {
+			i--
+		}
+		snapshot = h.Snapshots[i]
+	}
+	http.Redirect(w, r, h.mementoURI(path, snapshot), http.StatusFound)
+}
+
+// serveTimeMap lists the Mementos of the resource at path, in the link
+// format of RFC 6690
+func (h *MementoHandler) serveTimeMap(w http.ResponseWriter, r *http.Request, path string) {
+	if !h.validResourcePath(w, r, path) {
+		return
+	}
+	first, last := h.Snapshots[0], h.Snapshots[len(h.Snapshots)-1]
+	links := []string{
+		"<" + h.URIHost + path + ">; rel=\"original\"",
+		"<" + h.URIHost + "/timegate" + path + ">; rel=\"timegate\"",
+		"<" + h.URIHost + "/timemap" + path + ">; rel=\"self\"; type=\"application/link-format\"" +
+			"; from=\"" + first.Datetime.UTC().Format(http.TimeFormat) + "\"; until=\"" + last.Datetime.UTC().Format(http.TimeFormat) + "\"",
+	}
+	for i, snapshot := range h.Snapshots {
+		rel := "memento"
+		if i == len(h.Snapshots)-1 {
+			rel = "last " + rel
+		}
+		if i == 0 {
+			rel = "first " + rel
+		}
+		links = append(links, "<"+h.mementoURI(path, snapshot)+">; rel=\""+rel+"\"; datetime=\""+snapshot.Datetime.UTC().Format(http.TimeFormat)+"\"")
+	}
+	w.Header().Set("Content-Type", "application/link-format")
+	w.Write([]byte(strings.Join(links, ",\n") + "\n"))
+}
+
+// serveMemento serves the resource in the snapshot given by the datetime at
+// the start of rest, as in 20230501000000/cplogd/CPD-1
+func (h *MementoHandler) serveMemento(w http.ResponseWriter, r *http.Request, rest string) {
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		http.Error(w, "Error: No resource given", http.StatusNotFound)
+		return
+	}
+	path := rest[i:]
+	if !h.validResourcePath(w, r, path) {
+		return
+	}
+	datetime, err := time.Parse(mementoTimeLayout, rest[:i])
+	if err != nil {
+		http.Error(w, "Error: Invalid datetime in the URI of the Memento: "+rest[:i], http.StatusNotFound)
+		return
+	}
+	var snapshot *MementoSnapshot
+	for i := range h.Snapshots {
+		if h.Snapshots[i].Datetime.Equal(datetime) {
+			snapshot = &h.Snapshots[i]
+		}
+	}
+	if snapshot == nil {
+		http.Error(w, "Error: There is no snapshot from "+datetime.Format(http.TimeFormat)+", see the TimeMap at "+h.URIHost+"/timemap"+path, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Memento-Datetime", snapshot.Datetime.UTC().Format(http.TimeFormat))
+	w.Header().Add("Link", h.links(path, "original", "timegate", "timemap"))
+	resolver := &URIResolverHandler{h.URIHost, snapshot.Source, "", h.QueryTimeout, h.Limiter, h.Output}
+	u := *r.URL
+	u.Path, u.RawPath = path, ""
+	req := r.WithContext(r.Context())
+	req.URL = &u
+	resolver.ServeHTTP(w, req)
+}
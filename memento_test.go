@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSnapshots(t *testing.T) {
+	snapshots, err := loadSnapshots("2020-01-01=example_data.hdt, example_data.hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %v", snapshots)
+	}
+	issued := time.Date(2017, 9, 25, 20, 32, 19, 0, time.UTC)
+	if !snapshots[0].Datetime.Equal(issued) || !snapshots[1].Datetime.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the snapshots from the HDT header date and the given date, oldest first, got %v and %v", snapshots[0].Datetime, snapshots[1].Datetime)
+	}
+	if _, err := loadSnapshots("example_data.hdt,example_data.hdt"); err == nil {
+		t.Error("Expected an error for snapshots with the same date")
+	}
+	if _, err := loadSnapshots("2020-13-01=example_data.hdt"); err == nil {
+		t.Error("Expected an error for an invalid date")
+	}
+}
+
+func TestMementoHandler(t *testing.T) {
+	compound := "http://example.org/cplogd/CPD-1"
+	old := staticSource{mustQuad(compound, rdfsLabel, mustLangLiteral("Old name", "en"), "")}
+	h := &MementoHandler{
+		URIHost: "http://example.org",
+		Snapshots: []MementoSnapshot{
+			{time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), "old.hdt", old},
+			{time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), "new.hdt", staticSource{}},
+		},
+		Resources: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+
+	// The original resource links to its TimeGate and TimeMap
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/cplogd/CPD-1", nil))
+	if link := w.Header().Get("Link"); !strings.Contains(link, `<http://example.org/timegate/cplogd/CPD-1>; rel="timegate"`) || !strings.Contains(link, `<http://example.org/timemap/cplogd/CPD-1>; rel="timemap"`) {
+		t.Errorf("Expected links to the TimeGate and TimeMap, got %s", link)
+	}
+
+	// The TimeGate negotiates with Accept-Datetime
+	timegate := []struct {
+		acceptDatetime string
+		location       string
+	}{
+		{"", "http://example.org/memento/20230501120000/cplogd/CPD-1"},
+		{"Fri, 01 Jan 2022 00:00:00 GMT", "http://example.org/memento/20210301000000/cplogd/CPD-1"},
+		{"Mon, 01 May 2023 12:00:00 GMT", "http://example.org/memento/20230501120000/cplogd/CPD-1"},
+		{"Wed, 01 Jan 2020 00:00:00 GMT", "http://example.org/memento/20210301000000/cplogd/CPD-1"},
+	}
+	for _, test := range timegate {
+		r := httptest.NewRequest("GET", "/timegate/cplogd/CPD-1", nil)
+		if test.acceptDatetime != "" {
+			r.Header.Set("Accept-Datetime", test.acceptDatetime)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != test.location || w.Header().Get("Vary") != "Accept-Datetime" {
+			t.Errorf("Expected a redirect to %s for %q, got %d %v", test.location, test.acceptDatetime, w.Code, w.Header())
+		}
+	}
+	r := httptest.NewRequest("GET", "/timegate/cplogd/CPD-1", nil)
+	r.Header.Set("Accept-Datetime", "2022-01-01")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid Accept-Datetime, got %d", w.Code)
+	}
+
+	// The TimeMap lists the Mementos
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/timemap/cplogd/CPD-1", nil))
+	expected := `<http://example.org/cplogd/CPD-1>; rel="original",
+<http://example.org/timegate/cplogd/CPD-1>; rel="timegate",
+<http://example.org/timemap/cplogd/CPD-1>; rel="self"; type="application/link-format"; from="Mon, 01 Mar 2021 00:00:00 GMT"; until="Mon, 01 May 2023 12:00:00 GMT",
+<http://example.org/memento/20210301000000/cplogd/CPD-1>; rel="first memento"; datetime="Mon, 01 Mar 2021 00:00:00 GMT",
+<http://example.org/memento/20230501120000/cplogd/CPD-1>; rel="last memento"; datetime="Mon, 01 May 2023 12:00:00 GMT"
+`
+	if w.Header().Get("Content-Type") != "application/link-format" || w.Body.String() != expected {
+		t.Errorf("Expected the TimeMap\n%s\ngot %s\n%s", expected, w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	// Mementos are resolved against their snapshot
+	r = httptest.NewRequest("GET", "/memento/20210301000000/cplogd/CPD-1", nil)
+	r.Header.Set("Accept", "application/n-triples")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Old name") {
+		t.Errorf("Expected the resource from the old snapshot, got %d (%s)", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Memento-Datetime") != "Mon, 01 Mar 2021 00:00:00 GMT" || !strings.Contains(w.Header().Get("Link"), `rel="original"`) {
+		t.Errorf("Expected Memento headers, got %v", w.Header())
+	}
+	missing := map[string]int{
+		"/memento/20230501120000/cplogd/CPD-1": http.StatusNotFound,
+		"/memento/20220101000000/cplogd/CPD-1": http.StatusNotFound,
+		"/memento/yesterday/cplogd/CPD-1":      http.StatusNotFound,
+		"/memento/20210301000000/":             http.StatusNotFound,
+	}
+	for path, status := range missing {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Errorf("Expected %d for %s, got %d", status, path, w.Code)
+		}
+	}
+}
+
+func TestMementoHandlerAccessRules(t *testing.T) {
+	h := &MementoHandler{
+		URIHost:   "http://example.org",
+		Snapshots: []MementoSnapshot{{time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), "old.hdt", staticSource{}}},
+		Resources: http.NotFoundHandler(),
+	}
+	auth := &Authenticator{
+		APIKeys: map[string]string{"key-of-bob": "bob", "key-of-carol": "carol"},
+		Rules:   []AccessRule{{"/preprint/", []string{"bob"}}},
+	}
+	handler := withAuth(auth, h)
+	paths := []string{"/timegate/preprint/CPD-2", "/timemap/preprint/CPD-2", "/memento/20210301000000/preprint/CPD-2"}
+	for _, path := range paths {
+		for key, status := range map[string]int{"": http.StatusUnauthorized, "key-of-carol": http.StatusForbidden} {
+			r := httptest.NewRequest("GET", path, nil)
+			if key != "" {
+				r.Header.Set("X-API-Key", key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != status {
+				t.Errorf("Expected %d for %s with key %q, got %d", status, path, key, w.Code)
+			}
+		}
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("X-API-Key", "key-of-bob")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden || w.Header().Get("Cache-Control") != "private" {
+			t.Errorf("Expected %s to be allowed for bob, but not cached by shared caches, got %d %v", path, w.Code, w.Header())
+		}
+		if r.URL.Path != path {
+			t.Errorf("Expected the request not to be changed, got the path %s", r.URL.Path)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/knakk/rdf"
+)
+
+// memoryStore is an in-memory set of quads, with three indexes: SPO (the
+// quads of each subject, ordered by predicate and object), POS (the quads of
+// each predicate, ordered by object and subject) and OSP (the quads of each
+// object, ordered by subject and predicate). Terms are stored once, and
+// referred to by their ID. A memoryStore must not be changed once indexed.
+type memoryStore struct {
+	terms []rdf.Term        // ID -> term (ID 0 is the default graph)
+	keys  []string          // ID -> serialized term, for ordering
+	ids   map[string]uint32 // Serialized term -> ID
+	quads [][4]uint32       // Subject, predicate, object and graph IDs
+	seen  map[[4]uint32]bool
+
+	spo, pos, osp map[uint32][]int32 // Term ID -> indexes in quads
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{terms: []rdf.Term{nil}, keys: []string{""}, ids: make(map[string]uint32), seen: make(map[[4]uint32]bool)}
+}
+
+// id returns the ID of term, adding it if needed
+func (m *memoryStore) id(term rdf.Term) uint32 {
+	if term == nil {
+		return 0
+	}
+	key := term.Serialize(rdf.NTriples)
+	id, ok := m.ids[key]
+	if !ok {
+		id = uint32(len(m.terms))
+		m.ids[key] = id
+		m.terms = append(m.terms, term)
+		m.keys = append(m.keys, key)
+	}
+	return id
+}
+
+// lookup returns the ID of term, if it is in the store
+func (m *memoryStore) lookup(term rdf.Term) (uint32, bool) {
+	id, ok := m.ids[term.Serialize(rdf.NTriples)]
+	return id, ok
+}
+
+// add adds q to the store, unless it is already there
+func (m *memoryStore) add(q rdf.Quad) error {
+	var g uint32
+	if q.Ctx != nil {
+		g = m.id(q.Ctx)
+	}
+	quad := [4]uint32{m.id(q.Subj), m.id(q.Pred), m.id(q.Obj), g}
+	if !m.seen[quad] {
+		m.seen[quad] = true
+		m.quads = append(m.quads, quad)
+	}
+	return nil
+}
+
+// index builds the SPO, POS and OSP indexes, once all quads are added
+func (m *memoryStore) index() {
+	m.seen = nil
+	m.spo = m.buildIndex(0, 1, 2)
+	m.pos = m.buildIndex(1, 2, 0)
+	m.osp = m.buildIndex(2, 0, 1)
+}
+
+// buildIndex returns the indexes of the quads of each term in position
+// first, ordered by the terms in positions second and third
+func (m *memoryStore) buildIndex(first int, second int, third int) map[uint32][]int32 {
+	index := make(map[uint32][]int32)
+	for i, q := range m.quads {
+		index[q[first]] = append(index[q[first]], int32(i))
+	}
+	for _, positions := range index {
+		sort.Slice(positions, func(i, j int) bool {
+			a, b := m.quads[positions[i]], m.quads[positions[j]]
+			if a[second] != b[second] {
+				return m.keys[a[second]] < m.keys[b[second]]
+			}
+			return m.keys[a[third]] < m.keys[b[third]]
+		})
+	}
+	return index
+}
+
+// quad returns the quad at position i
+func (m *memoryStore) quad(i int32) rdf.Quad {
+	q := m.quads[i]
+	quad := rdf.Quad{Triple: rdf.Triple{Subj: m.terms[q[0]].(rdf.Subject), Pred: m.terms[q[1]].(rdf.Predicate), Obj: m.terms[q[2]].(rdf.Object)}}
+	if q[3] != 0 {
+		quad.Ctx = m.terms[q[3]].(rdf.Context)
+	}
+	return quad
+}
+
+// match returns the quads which have term at the position of index
+func (m *memoryStore) match(index map[uint32][]int32, term rdf.Term) []rdf.Quad {
+	id, ok := m.lookup(term)
+	if !ok {
+		return nil
+	}
+	var quads []rdf.Quad
+	for _, i := range index[id] {
+		quads = append(quads, m.quad(i))
+	}
+	return quads
+}
+
+// describe returns the quads with iri as subject or object
+func (m *memoryStore) describe(iri rdf.IRI) []rdf.Quad {
+	id, ok := m.lookup(iri)
+	if !ok {
+		return nil
+	}
+	var quads []rdf.Quad
+	for _, i := range m.spo[id] {
+		quads = append(quads, m.quad(i))
+	}
+	for _, i := range m.osp[id] {
+		if m.quads[i][0] != id {
+			quads = append(quads, m.quad(i))
+		}
+	}
+	return quads
+}
+
+// triples returns the number of distinct triples, regardless of graph
+func (m *memoryStore) triples() int64 {
+	distinct := make(map[[3]uint32]bool)
+	for _, q := range m.quads {
+		distinct[[3]uint32{q[0], q[1], q[2]}] = true
+	}
+	return int64(len(distinct))
+}
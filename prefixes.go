@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// PrefixMap maps prefixes to the namespace IRIs they abbreviate, for writing
+// IRIs in compact prefix:localName form
+type PrefixMap map[string]string
+
+// defaultPrefixes are prefixes for common vocabularies, which are used unless
+// overridden
+var defaultPrefixes = PrefixMap{
+	"rdf":     "http://www.w3.org/1999/02/22-rdf-syntax-ns#",
+	"rdfs":    "http://www.w3.org/2000/01/rdf-schema#",
+	"xsd":     "http://www.w3.org/2001/XMLSchema#",
+	"owl":     "http://www.w3.org/2002/07/owl#",
+	"skos":    "http://www.w3.org/2004/02/skos/core#",
+	"dc":      "http://purl.org/dc/elements/1.1/",
+	"dcterms": "http://purl.org/dc/terms/",
+	"foaf":    "http://xmlns.com/foaf/0.1/",
+	"schema":  "http://schema.org/",
+	"void":    "http://rdfs.org/ns/void#",
+}
+
+// Predicates used to declare prefixes in RDF, which are looked for in the
+// header of HDT files
+const (
+	vannPreferredNamespacePrefix = "http://purl.org/vocab/vann/preferredNamespacePrefix"
+	vannPreferredNamespaceUri    = "http://purl.org/vocab/vann/preferredNamespaceUri"
+	shaclPrefix                  = "http://www.w3.org/ns/shacl#prefix"
+	shaclNamespace               = "http://www.w3.org/ns/shacl#namespace"
+)
+
+// merge adds the prefixes in other to m, replacing any prefix already in m
+// (and any other prefix for the same namespace)
+func (m PrefixMap) merge(other PrefixMap) {
+	for prefix, namespace := range other {
+		for p, ns := range m {
+			if ns == namespace {
+				delete(m, p)
+			}
+		}
+		m[prefix] = namespace
+	}
+}
+
+// compact returns iri as prefix:localName, using the longest namespace which
+// leaves a valid Turtle local name, or false if no prefix can be used
+func (m PrefixMap) compact(iri string) (string, bool) {
+	bestPrefix, bestNamespace := "", ""
+	for prefix, namespace := range m {
+		if !strings.HasPrefix(iri, namespace) || !isLocalName(iri[len(namespace):]) {
+			continue
+		}
+		if len(namespace) > len(bestNamespace) || (len(namespace) == len(bestNamespace) && prefix < bestPrefix) {
+			bestPrefix, bestNamespace = prefix, namespace
+		}
+	}
+	if bestNamespace == "" {
+		return "", false
+	}
+	return bestPrefix + ":" + iri[len(bestNamespace):], true
+}
+
+// isLocalName returns true if s can be written, unescaped, as the local name
+// of a prefixed name in Turtle
+func isLocalName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isNameChar(c) && (c != '.' || (i > 0 && i < len(s)-1)) {
+			continue
+		}
+		return false
+	}
+	return len(s) == 0 || s[0] != '-'
+}
+
+// isPrefixName returns true if s is a valid prefix (PN_PREFIX) in Turtle and
+// SPARQL, or the empty prefix
+func isPrefixName(s string) bool {
+	if s == "" {
+		return true
+	}
+	c := s[0]
+	if !(c >= 'A' && c <= 'Z') && !(c >= 'a' && c <= 'z') {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return s[len(s)-1] != '.'
+}
+
+// usedBy returns the prefixes in m which are used to compact the IRIs in
+// triples, sorted by prefix
+func (m PrefixMap) usedBy(triples []rdf.Triple) []string {
+	used := make(map[string]bool)
+	for _, t := range triples {
+		for _, term := range []rdf.Term{t.Subj, t.Pred, t.Obj} {
+			iri := term.String()
+			if lit, ok := term.(rdf.Literal); ok {
+				iri = lit.DataType.String()
+			} else if term.Type() != rdf.TermIRI {
+				continue
+			}
+			if compacted, ok := m.compact(iri); ok {
+				used[compacted[:strings.Index(compacted, ":")]] = true
+			}
+		}
+	}
+	var prefixes []string
+	for prefix := range used {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// parsePrefixList parses a comma separated list of prefix=namespace pairs
+func parsePrefixList(list string) (PrefixMap, error) {
+	prefixes := make(PrefixMap)
+	for _, item := range splitList(list) {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("Invalid prefix %q, expected prefix=namespace", item)
+		}
+		prefix := strings.TrimSpace(kv[0])
+		if !isPrefixName(prefix) {
+			return nil, fmt.Errorf("Invalid prefix name %q", prefix)
+		}
+		prefixes[prefix] = strings.TrimSpace(kv[1])
+	}
+	return prefixes, nil
+}
+
+// loadPrefixFile loads prefixes from a file in one of the formats offered by
+// prefix.cc: JSON ({"prefix": "namespace", ...}), Turtle (@prefix lines),
+// SPARQL (PREFIX lines) or plain text (whitespace separated prefix and
+// namespace on each line)
+func loadPrefixFile(path string) (PrefixMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	prefixes := make(PrefixMap)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("Could not parse prefix file %s (%s)", path, err.Error())
+		}
+		if ctx, ok := doc["@context"].(map[string]interface{}); ok {
+			doc = ctx
+		}
+		for prefix, namespace := range doc {
+			if namespace, ok := namespace.(string); ok && !strings.HasPrefix(prefix, "@") {
+				if !isPrefixName(prefix) {
+					return nil, fmt.Errorf("Invalid prefix name %q in prefix file %s", prefix, path)
+				}
+				prefixes[prefix] = namespace
+			}
+		}
+		return prefixes, nil
+	}
+
+	err = readConfigLines(path, func(fields []string) error {
+		if strings.EqualFold(fields[0], "@prefix") || strings.EqualFold(fields[0], "PREFIX") {
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("expected a prefix and a namespace")
+		}
+		prefix := strings.TrimSuffix(fields[0], ":")
+		if !isPrefixName(prefix) {
+			return fmt.Errorf("invalid prefix name %q", prefix)
+		}
+		namespace := strings.TrimSuffix(strings.TrimPrefix(fields[1], "<"), ">")
+		if namespace == "" {
+			return fmt.Errorf("empty namespace for prefix %q", prefix)
+		}
+		prefixes[prefix] = namespace
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// hdtHeaderPrefixes returns the prefixes declared in the header of an HDT
+// file, with the vann:preferredNamespacePrefix/Uri or sh:prefix/namespace
+// properties. Prefixes which are not valid prefix names are skipped.
+func hdtHeaderPrefixes(path string) (PrefixMap, error) {
+	header, err := readHdtHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	prefixOf := make(map[string]string)
+	namespaceOf := make(map[string]string)
+	for _, t := range header {
+		subj := t.Subj.Serialize(rdf.NTriples)
+		switch t.Pred.String() {
+		case vannPreferredNamespacePrefix, shaclPrefix:
+			prefixOf[subj] = t.Obj.String()
+		case vannPreferredNamespaceUri, shaclNamespace:
+			namespaceOf[subj] = t.Obj.String()
+		}
+	}
+	prefixes := make(PrefixMap)
+	for subj, prefix := range prefixOf {
+		namespace, ok := namespaceOf[subj]
+		if !ok {
+			continue
+		}
+		if !isPrefixName(prefix) {
+			log.Printf("Skipping the invalid prefix name %q in the header of %s", prefix, path)
+			continue
+		}
+		prefixes[prefix] = namespace
+	}
+	return prefixes, nil
+}
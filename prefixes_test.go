@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+func TestPrefixMapCompact(t *testing.T) {
+	prefixes := PrefixMap{
+		"cplogd": "http://rdf.pharmb.io/cplogd/",
+		"pharmb": "http://rdf.pharmb.io/",
+		"rdfs":   "http://www.w3.org/2000/01/rdf-schema#",
+	}
+	iris := map[string]string{
+		"http://rdf.pharmb.io/cplogd/Compound1":      "cplogd:Compound1",
+		"http://rdf.pharmb.io/other":                 "pharmb:other",
+		"http://rdf.pharmb.io/cplogd/a/b":            "",
+		"http://www.w3.org/2000/01/rdf-schema#label": "rdfs:label",
+		"http://rdf.pharmb.io/cplogd/Compound1.":     "",
+		"http://example.org/Compound1":               "",
+	}
+	for iri, expected := range iris {
+		got, ok := prefixes.compact(iri)
+		if ok != (expected != "") || got != expected {
+			t.Errorf("Expected %s to be compacted to %q, got %q", iri, expected, got)
+		}
+	}
+}
+
+func TestLoadPrefixFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := PrefixMap{"cplogd": "http://rdf.pharmb.io/cplogd/", "foaf": "http://xmlns.com/foaf/0.1/"}
+	files := map[string]string{
+		"prefixes.json":   `{"cplogd": "http://rdf.pharmb.io/cplogd/", "foaf": "http://xmlns.com/foaf/0.1/"}`,
+		"prefixes.ttl":    "@prefix cplogd: <http://rdf.pharmb.io/cplogd/> .\n@prefix foaf: <http://xmlns.com/foaf/0.1/> .\n",
+		"prefixes.sparql": "PREFIX cplogd: <http://rdf.pharmb.io/cplogd/>\nPREFIX foaf: <http://xmlns.com/foaf/0.1/>\n",
+		"prefixes.txt":    "# Prefixes\ncplogd\thttp://rdf.pharmb.io/cplogd/\nfoaf\thttp://xmlns.com/foaf/0.1/\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		prefixes, err := loadPrefixFile(path)
+		if err != nil {
+			t.Errorf("Could not load %s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(prefixes, expected) {
+			t.Errorf("Unexpected prefixes loaded from %s: %v", name, prefixes)
+		}
+	}
+
+	invalid := map[string]string{
+		"invalid.json": `{"foaf/0.1": "http://xmlns.com/foaf/0.1/"}`,
+		"invalid.ttl":  "@prefix 1foaf: <http://xmlns.com/foaf/0.1/> .\n",
+	}
+	for name, content := range invalid {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadPrefixFile(path); err == nil {
+			t.Errorf("Expected an error for the invalid prefix in %s", name)
+		}
+	}
+}
+
+func TestParsePrefixList(t *testing.T) {
+	lists := map[string]bool{
+		"cplogd=http://rdf.pharmb.io/cplogd/, foaf=http://xmlns.com/foaf/0.1/": true,
+		"=http://example.org/":         true,
+		"ex.v2=http://example.org/v2/": true,
+		"2ex=http://example.org/":      false,
+		"_ex=http://example.org/":      false,
+		"ex.=http://example.org/":      false,
+		"e x=http://example.org/":      false,
+		"ex:=http://example.org/":      false,
+		"ex=":                          false,
+	}
+	for list, valid := range lists {
+		if _, err := parsePrefixList(list); (err == nil) != valid {
+			t.Errorf("Expected %q to be valid: %v, got error %v", list, valid, err)
+		}
+	}
+}
+
+func TestReadHdtHeader(t *testing.T) {
+	header, err := readHdtHeader("example_data.hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, triple := range header {
+		if triple.Pred.String() == "http://rdfs.org/ns/void#triples" && triple.Obj.String() == "135" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the header to contain the number of triples, got %d triples: %v", len(header), header)
+	}
+}
+
+func TestWriteTurtlePrefixes(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &writeOptions{OutputOptions: OutputOptions{Prefixes: PrefixMap{
+		"cplogd": "http://rdf.pharmb.io/cplogd/",
+		"xsd":    "http://www.w3.org/2001/XMLSchema#",
+		"foaf":   "http://xmlns.com/foaf/0.1/",
+	}}}
+	quads := append([]rdf.Quad{}, testQuads...)
+	quads = append(quads, mustQuad("http://rdf.pharmb.io/cplogd/Compound1", "http://rdf.pharmb.io/cplogd/measured", rdf.NewTypedLiteral("2020-01-01", mustIRI("http://www.w3.org/2001/XMLSchema#date")), ""))
+	if err := writeTurtle(&buf, quads, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := "@prefix cplogd: <http://rdf.pharmb.io/cplogd/> .\n" +
+		"@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .\n" +
+		"\n" +
+		"cplogd:Compound1 a cplogd:Compound ;\n" +
+		"\tcplogd:logD 2.5 ;\n" +
+		"\tcplogd:measured \"2020-01-01\"^^xsd:date .\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected Turtle output. Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
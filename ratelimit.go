@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientRateLimiter limits the rate of requests per client IP address, using
+// one token bucket per client, which is refilled with rate tokens per second,
+// up to burst tokens.
+type clientRateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newClientRateLimiter creates a clientRateLimiter allowing each client rate
+// requests per second on average, with bursts of up to burst requests
+func newClientRateLimiter(rate float64, burst int) *clientRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &clientRateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of client, if there is one. If not, it
+// returns false, and the time until a token will be available.
+func (l *clientRateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets about clients whose buckets have been refilled completely,
+// at most once a minute, so that the map of buckets does not grow forever
+func (l *clientRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// withRateLimit answers requests from clients exceeding the rate allowed by
+// limiter with 429 Too Many Requests. The client IP address is taken from
+// X-Forwarded-For when the request comes from one of trustedProxies.
+func withRateLimit(limiter *clientRateLimiter, trustedProxies []*net.IPNet, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := limiter.allow(clientIP(r, trustedProxies), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			http.Error(w, "Error: Too many requests, please slow down", http.StatusTooManyRequests)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the client making r. If the request was
+// made by a trusted proxy, the X-Forwarded-For header is used, taking the
+// right-most address which is not itself a trusted proxy.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !ipInNets(remote, trustedProxies) {
+		return remote
+	}
+
+	var forwarded []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, splitList(header)...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !ipInNets(forwarded[i], trustedProxies) {
+			return forwarded[i]
+		}
+	}
+	if len(forwarded) > 0 {
+		return forwarded[0]
+	}
+	return remote
+}
+
+func ipInNets(address string, nets []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses a list of CIDR ranges (e.g. 10.0.0.0/8) or single IP
+// addresses
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			if strings.Contains(item, ":") {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// errBackendBusy is returned by concurrencyLimiter.acquire when no backend
+// query slot became available in time
+var errBackendBusy = errors.New("Too many concurrent queries to the data source")
+
+// concurrencyLimiter caps the number of concurrent queries to a backend.
+// Queries beyond the cap are queued, for at most queueTimeout, and with at
+// most maxQueued queries waiting at the same time.
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+	maxQueued    int
+
+	mu     sync.Mutex
+	queued int
+}
+
+// newConcurrencyLimiter creates a concurrencyLimiter allowing max concurrent
+// queries, or nil (meaning no limit) if max is zero
+func newConcurrencyLimiter(max int, maxQueued int, queueTimeout time.Duration) *concurrencyLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &concurrencyLimiter{
+		slots:        make(chan struct{}, max),
+		queueTimeout: queueTimeout,
+		maxQueued:    maxQueued,
+	}
+}
+
+// acquire waits for a free query slot, which must be given back with
+// release. A nil concurrencyLimiter never blocks.
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.maxQueued {
+		l.mu.Unlock()
+		return errBackendBusy
+	}
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errBackendBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release gives back a query slot taken with acquire
+func (l *concurrencyLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// acquireBackend takes a query slot from limiter for the request r, waiting in
+// the queue if needed. If no slot could be acquired, an error response is
+// written to w, and false returned. Otherwise, the slot must be given back
+// with limiter.release.
+func acquireBackend(w http.ResponseWriter, r *http.Request, limiter *concurrencyLimiter) bool {
+	err := limiter.acquire(r.Context())
+	if err == errBackendBusy {
+		writeBusyError(w, limiter.queueTimeout)
+		return false
+	} else if err != nil {
+		writeBackendError(w, r.Context(), err)
+		return false
+	}
+	return true
+}
+
+// writeBusyError writes a 503 Service Unavailable response, telling the client
+// to retry after retryAfter
+func writeBusyError(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	http.Error(w, "Error: "+errBackendBusy.Error()+", please try again later", http.StatusServiceUnavailable)
+}
+
+// retryAfterSeconds formats d as a number of seconds for the Retry-After
+// header, rounding up to at least one second
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	limiter := newClientRateLimiter(1, 2)
+	start := time.Now()
+
+	allowed := []struct {
+		client  string
+		after   time.Duration
+		allowed bool
+	}{
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, false},
+		{"10.0.0.2", 0, true},
+		{"10.0.0.1", 500 * time.Millisecond, false},
+		{"10.0.0.1", 1100 * time.Millisecond, true},
+	}
+	for _, test := range allowed {
+		ok, wait := limiter.allow(test.client, start.Add(test.after))
+		if ok != test.allowed {
+			t.Errorf("Expected request from %s after %s to be allowed=%v", test.client, test.after, test.allowed)
+		}
+		if !ok && wait <= 0 {
+			t.Errorf("Expected a positive wait time for a denied request, got %s", wait)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor string
+		expectedIP   string
+	}{
+		{"203.0.113.5:1234", "", "203.0.113.5"},
+		{"203.0.113.5:1234", "198.51.100.7", "203.0.113.5"},
+		{"10.1.2.3:1234", "198.51.100.7", "198.51.100.7"},
+		{"10.1.2.3:1234", "1.2.3.4, 198.51.100.7, 192.168.1.1", "198.51.100.7"},
+		{"192.168.1.1:1234", "", "192.168.1.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/cplogd/Compound1", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if ip := clientIP(r, trusted); ip != test.expectedIP {
+			t.Errorf("Expected client IP %s for %s (X-Forwarded-For: %s), got %s", test.expectedIP, test.remoteAddr, test.forwardedFor, ip)
+		}
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	handler := withRateLimit(newClientRateLimiter(0.1, 1), nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, expectedCode := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/cplogd/Compound1", nil))
+		if rec.Code != expectedCode {
+			t.Errorf("Request %d: expected status %d, got %d", i, expectedCode, rec.Code)
+		}
+		if expectedCode == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "10" {
+			t.Errorf("Expected Retry-After of 10 seconds, got %q", rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := newConcurrencyLimiter(1, 1, 50*time.Millisecond)
+	ctx := context.Background()
+
+	if err := limiter.acquire(ctx); err != nil {
+		t.Fatalf("Expected to get a free slot, got: %v", err)
+	}
+
+	// The queue is full while this one is waiting
+	waiting := make(chan error)
+	go func() {
+		waiting <- limiter.acquire(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := limiter.acquire(ctx); err != errBackendBusy {
+		t.Errorf("Expected a full queue to be rejected, got: %v", err)
+	}
+	if err := <-waiting; err != errBackendBusy {
+		t.Errorf("Expected the queued query to time out, got: %v", err)
+	}
+
+	limiter.release()
+	if err := limiter.acquire(ctx); err != nil {
+		t.Errorf("Expected to get the released slot, got: %v", err)
+	}
+	limiter.release()
+
+	var unlimited *concurrencyLimiter
+	if err := unlimited.acquire(ctx); err != nil {
+		t.Errorf("Expected a nil limiter to never block, got: %v", err)
+	}
+	unlimited.release()
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/knakk/rdf"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchResult is a resource with a literal value matching a search
+type SearchResult struct {
+	IRI      string `json:"iri"`
+	Property string `json:"property"`
+	Text     string `json:"text"`
+}
+
+// Searcher is implemented by sources which can search the literal values of
+// resources
+type Searcher interface {
+	// Search returns (at most limit) resources with a literal value
+	// containing all the words in query
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+// searchWords splits s into lower case words, of letters and digits
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Search finds resources with a literal value containing query (ignoring
+// case), with a CONTAINS filter
+func (s *SparqlSource) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	bindings, err := s.selectQuery(ctx, `SELECT DISTINCT ?s ?p ?o WHERE {
+  `+s.graphPattern("?s ?p ?o")+`
+  FILTER(isIRI(?s) && isLiteral(?o) && CONTAINS(LCASE(STR(?o)), `+sparqlString(strings.ToLower(query))+`))
+} LIMIT `+strconv.Itoa(limit))
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	for _, b := range bindings {
+		results = append(results, SearchResult{IRI: b["s"].Value, Property: b["p"].Value, Text: b["o"].Value})
+	}
+	return results, nil
+}
+
+// sparqlString returns s as a quoted SPARQL string literal
+func sparqlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(s) + `"`
+}
+
+// hdtSearchIndex is an inverted index of the words in the literals of HDT
+// files, built from their dictionaries. Resources with matching literals are
+// looked up with hdtSearch.
+type hdtSearchIndex struct {
+	source *HdtSource
+
+	mu       sync.RWMutex
+	literals []string         // Literals, as stored in the dictionary
+	words    []string         // All indexed words, sorted
+	postings map[string][]int // Word -> indexes of the literals containing it
+}
+
+// newHdtSearchIndex builds a search index of the literals in the
+// dictionaries of the HDT files of source
+func newHdtSearchIndex(source *HdtSource) (*hdtSearchIndex, error) {
+	index := &hdtSearchIndex{source: source, postings: make(map[string][]int)}
+	seen := make(map[string]bool)
+	for _, path := range source.paths() {
+		_, err := readHdtDictionary(path, func(section hdtSection, term string) {
+			if section != hdtObjects || !strings.HasPrefix(term, `"`) || seen[term] {
+				return
+			}
+			lit, err := hdtTerm(term)
+			if err != nil {
+				return
+			}
+			seen[term] = true
+			i := len(index.literals)
+			index.literals = append(index.literals, term)
+			for _, word := range searchWords(lit.String()) {
+				if p := index.postings[word]; len(p) == 0 || p[len(p)-1] != i {
+					index.postings[word] = append(p, i)
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for word := range index.postings {
+		index.words = append(index.words, word)
+	}
+	sort.Strings(index.words)
+	return index, nil
+}
+
+// rebuild indexes the HDT files of the source again, after it has been
+// reloaded. The current index is kept if that fails.
+func (index *hdtSearchIndex) rebuild() error {
+	rebuilt, err := newHdtSearchIndex(index.source)
+	if err != nil {
+		return err
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.literals, index.words, index.postings = rebuilt.literals, rebuilt.words, rebuilt.postings
+	return nil
+}
+
+// match returns the indexes of the literals containing all words, where the
+// last word may also be the start of a word, so that results can be shown
+// while typing. The caller must hold index.mu.
+func (index *hdtSearchIndex) match(words []string) []int {
+	if len(words) == 0 {
+		return nil
+	}
+	var sets [][]int
+	for _, word := range words[:len(words)-1] {
+		sets = append(sets, index.postings[word])
+	}
+	last := words[len(words)-1]
+	prefixed := make(map[int]bool)
+	for i := sort.SearchStrings(index.words, last); i < len(index.words) && strings.HasPrefix(index.words[i], last); i++ {
+		for _, literal := range index.postings[index.words[i]] {
+			prefixed[literal] = true
+		}
+	}
+	var lastSet []int
+	for literal := range prefixed {
+		lastSet = append(lastSet, literal)
+	}
+	sets = append(sets, lastSet)
+
+	counts := make(map[int]int)
+	for _, set := range sets {
+		for _, literal := range set {
+			counts[literal]++
+		}
+	}
+	var matches []int
+	for literal, n := range counts {
+		if n == len(sets) {
+			matches = append(matches, literal)
+		}
+	}
+	// Shorter literals are closer matches
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := index.literals[matches[i]], index.literals[matches[j]]
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return matches
+}
+
+// Search finds literals with all the words in query in the index, and looks
+// up the resources having them as value with hdtSearch
+func (index *hdtSearchIndex) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	index.mu.RLock()
+	var literals []string
+	for _, literal := range index.match(searchWords(query)) {
+		literals = append(literals, index.literals[literal])
+	}
+	index.mu.RUnlock()
+
+	var results []SearchResult
+	for _, literal := range literals {
+		if len(results) >= limit {
+			break
+		}
+		triples, err := index.source.runHdtQuery(ctx, "? ? "+literal)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range triples {
+			if t.Subj.Type() == rdf.TermIRI && len(results) < limit {
+				results = append(results, SearchResult{IRI: t.Subj.String(), Property: t.Pred.String(), Text: t.Obj.String()})
+			}
+		}
+	}
+	return results, nil
+}
+
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Search{{if .Query}}: {{.Query}}{{end}}</title>
+	<style>
+		body { font-family: arial, helvetica, sans-serif; margin: 2em; }
+		li { margin-bottom: 0.5em; }
+		.note { color: #888; font-size: 0.85em; }
+	</style>
+</head>
+<body>
+	<form action="/search"><input type="search" name="q" value="{{.Query}}" size="40" autofocus> <input type="submit" value="Search"></form>
+{{- if .Query}}
+	{{- if .Results}}
+	<ol>
+	{{- range .Results}}
+		<li><a href="{{.IRI}}">{{.IRI}}</a><br>{{.Text}} <span class="note">{{.Property}}</span></li>
+	{{- end}}
+	</ol>
+	{{- else}}
+	<p>No resources found.</p>
+	{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// SearchHandler serves searches for resources by their literal values, at
+// /search?q=... (and optionally &limit=...), as JSON or HTML. Resources
+// under URIHost which the client may not read are left out of the results.
+type SearchHandler struct {
+	URIHost      string
+	Searcher     Searcher
+	QueryTimeout time.Duration
+	Limiter      *concurrencyLimiter
+}
+
+func (h *SearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	asHTML := prefersHTML(r)
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			http.Error(w, "Error: Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+
+	var results []SearchResult
+	if query != "" {
+		if !acquireBackend(w, r, h.Limiter) {
+			return
+		}
+		defer h.Limiter.release()
+		ctx, cancel := withQueryTimeout(r.Context(), h.QueryTimeout)
+		defer cancel()
+		// Look for more results when some may be left out
+		candidates := limit
+		if hasAccessRules(ctx) {
+			candidates = maxSearchLimit
+		}
+		found, err := h.Searcher.Search(ctx, query, candidates)
+		if err != nil {
+			writeBackendError(w, ctx, err)
+			return
+		}
+		for _, result := range found {
+			if len(results) < limit && mayReadIRI(ctx, h.URIHost, result.IRI) {
+				results = append(results, result)
+			}
+		}
+	} else if !asHTML {
+		http.Error(w, "Error: Missing search query (the q parameter)", http.StatusBadRequest)
+		return
+	}
+
+	if asHTML {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		searchTemplate.Execute(w, struct {
+			Query   string
+			Results []SearchResult
+		}{query, results})
+		return
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Query   string         `json:"query"`
+		Results []SearchResult `json:"results"`
+	}{query, results})
+}
+
+// prefersHTML returns true if the Accept header of r prefers HTML over JSON,
+// for the pages which are available in those two formats
+func prefersHTML(r *http.Request) bool {
+	ranges := parseAccept(r.Header.Get("Accept"))
+	htmlQ, _ := matchQuality(ranges, "text/html")
+	jsonQ, _ := matchQuality(ranges, "application/json")
+	return htmlQ > jsonQ
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchWords(t *testing.T) {
+	got := searchWords("Acetyl-L-carnitine, (R)-form Ärter")
+	expected := []string{"acetyl", "l", "carnitine", "r", "form", "ärter"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected words %v, got %v", expected, got)
+	}
+}
+
+func TestHdtSearchIndexMatch(t *testing.T) {
+	index, err := newHdtSearchIndex(&HdtSource{FilePath: "example_data.hdt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	literals := func(words ...string) []string {
+		var matched []string
+		for _, i := range index.match(words) {
+			matched = append(matched, index.literals[i])
+		}
+		return matched
+	}
+
+	if got := literals("ccn1c"); !reflect.DeepEqual(got, []string{`"CCN1C=NC2=C1N=CN=C2N"`}) {
+		t.Errorf("Unexpected literals matching a whole word: %v", got)
+	}
+	if got := literals("ccc", "o"); len(got) != 2 || got[0] != `"CCC(C)(C(C(=O)O)O)O"` {
+		t.Errorf("Unexpected literals matching two words: %v", got)
+	}
+	if got := literals("cc"); len(got) != 6 || got[0] != `"CC(CN)O"` {
+		t.Errorf("Expected 6 literals, shortest first, with a word starting with cc, got %v", got)
+	}
+	if got := literals("nonexistent"); len(got) != 0 {
+		t.Errorf("Expected no matches, got %v", got)
+	}
+}
+
+func TestSparqlSearchQuery(t *testing.T) {
+	var query string
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.FormValue("query")
+		w.Write([]byte(`{"head": {"vars": ["s", "p", "o"]}, "results": {"bindings": [
+			{"s": {"type": "uri", "value": "http://ex.org/Compound1"}, "p": {"type": "uri", "value": "http://www.w3.org/2000/01/rdf-schema#label"}, "o": {"type": "literal", "value": "Aspirin \"tablet\""}}
+		]}}`))
+	}))
+	defer endpoint.Close()
+
+	s := &SparqlSource{endpoint.URL, nil}
+	results, err := s.Search(context.Background(), `Aspirin "Tablet"`, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, `CONTAINS(LCASE(STR(?o)), "aspirin \"tablet\"")`) || !strings.HasSuffix(query, "LIMIT 5") {
+		t.Errorf("Unexpected search query:\n%s", query)
+	}
+	if len(results) != 1 || results[0].IRI != "http://ex.org/Compound1" || results[0].Text != `Aspirin "tablet"` {
+		t.Errorf("Unexpected search results: %v", results)
+	}
+}
+
+// staticSearcher is a Searcher returning the same results for any query
+type staticSearcher []SearchResult
+
+func (s staticSearcher) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	if len(s) > limit {
+		return s[:limit], nil
+	}
+	return s, nil
+}
+
+func TestSearchHandler(t *testing.T) {
+	h := &SearchHandler{"http://ex.org", staticSearcher{
+		{"http://ex.org/Compound1", "http://www.w3.org/2000/01/rdf-schema#label", "Aspirin"},
+		{"http://ex.org/Compound2", "http://www.w3.org/2000/01/rdf-schema#label", "Aspirin <b>"},
+	}, time.Second, nil}
+
+	tests := []struct {
+		query        string
+		accept       string
+		expectedCode int
+		expected     string
+	}{
+		{"?q=aspirin", "", http.StatusOK, `"iri":"http://ex.org/Compound2"`},
+		{"?q=aspirin&limit=1", "application/json", http.StatusOK, `"results":[{"iri":"http://ex.org/Compound1"`},
+		{"?q=aspirin", "text/html", http.StatusOK, `<a href="http://ex.org/Compound2">http://ex.org/Compound2</a><br>Aspirin &lt;b&gt;`},
+		{"", "text/html", http.StatusOK, `<input type="search" name="q" value=""`},
+		{"", "application/json", http.StatusBadRequest, "Missing search query"},
+		{"?q=aspirin&limit=x", "", http.StatusBadRequest, "Invalid limit"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/search"+test.query, nil)
+		r.Header.Set("Accept", test.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != test.expectedCode {
+			t.Errorf("%s (Accept: %s): expected status %d, got %d", test.query, test.accept, test.expectedCode, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), test.expected) {
+			t.Errorf("%s (Accept: %s): expected %s in:\n%s", test.query, test.accept, test.expected, rec.Body.String())
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/search?q=aspirin&limit=1", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	var response struct {
+		Results []SearchResult `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Results) != 1 {
+		t.Errorf("Expected one result with limit=1, got %s (%v)", rec.Body.String(), err)
+	}
+}
+
+func TestSearchHandlerAccessRules(t *testing.T) {
+	h := &SearchHandler{"http://ex.org", staticSearcher{
+		{"http://ex.org/preprint/Compound1", "http://www.w3.org/2000/01/rdf-schema#label", "Aspirin"},
+		{"http://ex.org/Compound2", "http://www.w3.org/2000/01/rdf-schema#label", "Aspirin"},
+		{"http://ex.org/Compound3", "http://www.w3.org/2000/01/rdf-schema#label", "Aspirin"},
+	}, time.Second, nil}
+	auth := &Authenticator{
+		APIKeys: map[string]string{"key-of-bob": "bob"},
+		Rules:   []AccessRule{{"/preprint/", []string{"bob"}}},
+	}
+	tests := map[string][]string{
+		"":           {"http://ex.org/Compound2", "http://ex.org/Compound3"},
+		"key-of-bob": {"http://ex.org/preprint/Compound1", "http://ex.org/Compound2"},
+	}
+	for apiKey, expected := range tests {
+		r := httptest.NewRequest("GET", "/search?q=aspirin&limit=2", nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		rec := httptest.NewRecorder()
+		withAuth(auth, h).ServeHTTP(rec, r)
+		var response struct {
+			Results []SearchResult `json:"results"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		var iris []string
+		for _, result := range response.Results {
+			iris = append(iris, result.IRI)
+		}
+		if !reflect.DeepEqual(iris, expected) {
+			t.Errorf("Key %q: expected the results %v, got %v", apiKey, expected, iris)
+		}
+	}
+}
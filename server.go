@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ServerTimeouts holds the timeouts used for the HTTP server, as well as the
+// time allowed for in-flight requests to finish when shutting down.
+type ServerTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Shutdown   time.Duration
+}
+
+// newServer creates an http.Server for addr with the given timeouts. The
+// returned cancel function cancels the context of all requests being served,
+// which in turn aborts any backend queries (SPARQL requests, hdtSearch
+// processes) that they are running.
+func newServer(addr string, handler http.Handler, timeouts ServerTimeouts) (*http.Server, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           withCancelContext(ctx, handler),
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+	}
+	return srv, cancel
+}
+
+// withCancelContext makes the request context of handler get cancelled when
+// ctx is, in addition to when the client goes away
+func withCancelContext(ctx context.Context, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCtx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-ctx.Done():
+				cancel()
+			case <-reqCtx.Done():
+			}
+		}()
+		handler.ServeHTTP(w, r.WithContext(reqCtx))
+	})
+}
+
+// serveUntilSignalled runs serve (typically srv.ListenAndServe) until it fails,
+// or until the process receives SIGINT or SIGTERM. In the latter case, the
+// server stops accepting new connections and in-flight requests are given
+// shutdownTimeout to finish, after which cancelRequests is called to abort
+// their backend queries, and the remaining connections are closed.
+func serveUntilSignalled(srv *http.Server, serve func() error, shutdownTimeout time.Duration, cancelRequests context.CancelFunc) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve()
+	}()
+
+	select {
+	case err := <-serveErr:
+		cancelRequests()
+		return err
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down (waiting up to %s for in-flight requests) ...", sig, shutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	cancelRequests()
+	if err != nil {
+		log.Printf("Could not finish all in-flight requests in time (%s), closing remaining connections", err.Error())
+		return srv.Close()
+	}
+	log.Println("Shutdown complete")
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithCancelContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	handler := withCancelContext(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			http.Error(w, "Cancelled", http.StatusServiceUnavailable)
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("Not cancelled"))
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/cplogd/Compound1", nil))
+		close(done)
+	}()
+	<-started
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Request was not cancelled together with the server context")
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the handler to see a cancelled context, got status %d", rec.Code)
+	}
+}
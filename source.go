@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+
+	"github.com/knakk/rdf"
+)
+
+// Source is a data source which urisolve can resolve URIs against
+type Source interface {
+	// Describe returns all quads with uri as subject or object. Quads in the
+	// default graph have a nil Ctx.
+	Describe(ctx context.Context, uri string) ([]rdf.Quad, error)
+}
+
+// quadKey returns a string identifying q, including its graph, for use as a
+// map key when removing duplicates
+func quadKey(q rdf.Quad) string {
+	if q.Ctx == nil {
+		return q.Triple.Serialize(rdf.NTriples)
+	}
+	return q.Serialize(rdf.NQuads)
+}
+
+// quadsToTriples returns the distinct triples in quads, regardless of graph
+func quadsToTriples(quads []rdf.Quad) []rdf.Triple {
+	seen := make(map[string]bool)
+	var triples []rdf.Triple
+	for _, q := range quads {
+		key := q.Triple.Serialize(rdf.NTriples)
+		if !seen[key] {
+			seen[key] = true
+			triples = append(triples, q.Triple)
+		}
+	}
+	return triples
+}
+
+// triplesToQuads returns quads for triples, in the default graph
+func triplesToQuads(triples []rdf.Triple) []rdf.Quad {
+	quads := make([]rdf.Quad, len(triples))
+	for i, t := range triples {
+		quads[i] = rdf.Quad{Triple: t}
+	}
+	return quads
+}
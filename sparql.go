@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// SparqlSource resolves URIs with SELECT queries against a SPARQL 1.1
+// endpoint, keeping track of which named graph each triple comes from. If
+// Graphs is not empty, only triples in those named graphs are returned.
+type SparqlSource struct {
+	EndpointUrl string
+	Graphs      []string
+}
+
+// Describe returns the quads with uri as subject or object, from the default
+// graph as well as from named graphs. Since many triple stores treat the
+// default graph as the union of all named graphs, triples which are found in
+// a named graph are not repeated for the default graph.
+func (s *SparqlSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	bindings, err := s.selectQuery(ctx, s.describeQuery(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	var quads []rdf.Quad
+	inNamedGraph := make(map[string]bool)
+	for _, b := range bindings {
+		q, err := bindingToQuad(b)
+		if err != nil {
+			return nil, err
+		}
+		if q.Ctx != nil {
+			inNamedGraph[q.Triple.Serialize(rdf.NTriples)] = true
+		}
+		quads = append(quads, q)
+	}
+
+	seen := make(map[string]bool)
+	var distinct []rdf.Quad
+	for _, q := range quads {
+		if q.Ctx == nil && inNamedGraph[q.Triple.Serialize(rdf.NTriples)] {
+			continue
+		}
+		if key := quadKey(q); !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, q)
+		}
+	}
+	return distinct, nil
+}
+
+// describeQuery returns a SELECT query for all quads with uri as subject or
+// object
+func (s *SparqlSource) describeQuery(uri string) string {
+	iri := "<" + uri + ">"
+	if len(s.Graphs) > 0 {
+		var graphs []string
+		for _, g := range s.Graphs {
+			graphs = append(graphs, "<"+g+">")
+		}
+		return `SELECT ?s ?p ?o ?g WHERE {
+  { GRAPH ?g { ` + iri + ` ?p ?o } BIND(` + iri + ` AS ?s) }
+  UNION { GRAPH ?g { ?s ?p ` + iri + ` } BIND(` + iri + ` AS ?o) }
+  VALUES ?g { ` + strings.Join(graphs, " ") + ` }
+}`
+	}
+	return `SELECT ?s ?p ?o ?g WHERE {
+  { ` + iri + ` ?p ?o . BIND(` + iri + ` AS ?s) }
+  UNION { GRAPH ?g { ` + iri + ` ?p ?o } BIND(` + iri + ` AS ?s) }
+  UNION { ?s ?p ` + iri + ` . BIND(` + iri + ` AS ?o) }
+  UNION { GRAPH ?g { ?s ?p ` + iri + ` } BIND(` + iri + ` AS ?o) }
+}`
+}
+
+// sparqlTerm is an RDF term in the SPARQL 1.1 Query Results JSON Format
+type sparqlTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Lang     string `json:"xml:lang"`
+	Datatype string `json:"datatype"`
+}
+
+// sparqlResults is a SPARQL 1.1 Query Results JSON document
+type sparqlResults struct {
+	Head struct {
+		Vars []string `json:"vars"`
+	} `json:"head"`
+	Results struct {
+		Bindings []map[string]sparqlTerm `json:"bindings"`
+	} `json:"results"`
+}
+
+// selectQuery runs a SELECT query against the endpoint, and returns the
+// variable bindings of the results
+func (s *SparqlSource) selectQuery(ctx context.Context, query string) ([]map[string]sparqlTerm, error) {
+	fmt.Println("Querying " + s.EndpointUrl + " with the following query:")
+	fmt.Println(query)
+
+	form := url.Values{"query": {query}}
+	request, err := http.NewRequest("POST", s.EndpointUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Add("Accept", "application/sparql-results+json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return nil, fmt.Errorf("SPARQL endpoint returned status %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+
+	var results sparqlResults
+	if err := json.NewDecoder(response.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("Could not parse SPARQL results (%s)", err.Error())
+	}
+	return results.Results.Bindings, nil
+}
+
+// bindingToQuad creates a quad from the ?s ?p ?o and (optional) ?g variables
+// of a query result
+func bindingToQuad(b map[string]sparqlTerm) (rdf.Quad, error) {
+	var q rdf.Quad
+	s, err := b["s"].toTerm()
+	if err != nil {
+		return q, err
+	}
+	p, err := b["p"].toTerm()
+	if err != nil {
+		return q, err
+	}
+	o, err := b["o"].toTerm()
+	if err != nil {
+		return q, err
+	}
+
+	subj, ok := s.(rdf.Subject)
+	if !ok {
+		return q, fmt.Errorf("Invalid subject in SPARQL results: %s", s.String())
+	}
+	pred, ok := p.(rdf.Predicate)
+	if !ok {
+		return q, fmt.Errorf("Invalid predicate in SPARQL results: %s", p.String())
+	}
+	q.Triple = rdf.Triple{Subj: subj, Pred: pred, Obj: o.(rdf.Object)}
+
+	if g, ok := b["g"]; ok {
+		graph, err := g.toTerm()
+		if err != nil {
+			return q, err
+		}
+		ctx, ok := graph.(rdf.Context)
+		if !ok {
+			return q, fmt.Errorf("Invalid graph in SPARQL results: %s", graph.String())
+		}
+		q.Ctx = ctx
+	}
+	return q, nil
+}
+
+// toTerm converts t to the corresponding rdf.Term
+func (t sparqlTerm) toTerm() (rdf.Term, error) {
+	switch t.Type {
+	case "uri":
+		return rdf.NewIRI(t.Value)
+	case "bnode":
+		return rdf.NewBlank(t.Value)
+	case "literal", "typed-literal":
+		if t.Lang != "" {
+			return rdf.NewLangLiteral(t.Value, t.Lang)
+		}
+		if t.Datatype != "" {
+			dt, err := rdf.NewIRI(t.Datatype)
+			if err != nil {
+				return nil, err
+			}
+			return rdf.NewTypedLiteral(t.Value, dt), nil
+		}
+		return rdf.NewLiteral(t.Value)
+	}
+	return nil, fmt.Errorf("Unknown term type in SPARQL results: %q", t.Type)
+}
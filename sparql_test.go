@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+const testSparqlResults = `{
+  "head": {"vars": ["s", "p", "o", "g"]},
+  "results": {"bindings": [
+    {"s": {"type": "uri", "value": "http://rdf.pharmb.io/cplogd/Compound1"},
+     "p": {"type": "uri", "value": "http://www.w3.org/2000/01/rdf-schema#label"},
+     "o": {"type": "literal", "value": "Compound 1", "xml:lang": "en"}},
+    {"s": {"type": "uri", "value": "http://rdf.pharmb.io/cplogd/Compound1"},
+     "p": {"type": "uri", "value": "http://rdf.pharmb.io/cplogd/logD"},
+     "o": {"type": "literal", "value": "2.5", "datatype": "http://www.w3.org/2001/XMLSchema#decimal"},
+     "g": {"type": "uri", "value": "http://rdf.pharmb.io/graph/measurements"}},
+    {"s": {"type": "uri", "value": "http://rdf.pharmb.io/cplogd/Compound1"},
+     "p": {"type": "uri", "value": "http://rdf.pharmb.io/cplogd/logD"},
+     "o": {"type": "literal", "value": "2.5", "datatype": "http://www.w3.org/2001/XMLSchema#decimal"}},
+    {"s": {"type": "bnode", "value": "b0"},
+     "p": {"type": "uri", "value": "http://purl.org/dc/terms/subject"},
+     "o": {"type": "uri", "value": "http://rdf.pharmb.io/cplogd/Compound1"},
+     "g": {"type": "uri", "value": "http://rdf.pharmb.io/graph/provenance"}}
+  ]}
+}`
+
+func TestSparqlSourceDescribe(t *testing.T) {
+	var queries []string
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		queries = append(queries, r.Form.Get("query"))
+		if r.Header.Get("Accept") != "application/sparql-results+json" {
+			t.Errorf("Expected SPARQL JSON results to be requested, got Accept: %s", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "application/sparql-results+json")
+		w.Write([]byte(testSparqlResults))
+	}))
+	defer endpoint.Close()
+
+	src := &SparqlSource{endpoint.URL, nil}
+	quads, err := src.Describe(context.Background(), "http://rdf.pharmb.io/cplogd/Compound1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The logD triple in the default graph is dropped, since it is also in a
+	// named graph
+	expected := []string{
+		"<http://rdf.pharmb.io/cplogd/Compound1> <http://www.w3.org/2000/01/rdf-schema#label> \"Compound 1\"@en  .\n",
+		"<http://rdf.pharmb.io/cplogd/Compound1> <http://rdf.pharmb.io/cplogd/logD> \"2.5\"^^<http://www.w3.org/2001/XMLSchema#decimal> <http://rdf.pharmb.io/graph/measurements> .\n",
+		"_:b0 <http://purl.org/dc/terms/subject> <http://rdf.pharmb.io/cplogd/Compound1> <http://rdf.pharmb.io/graph/provenance> .\n",
+	}
+	if len(quads) != len(expected) {
+		t.Fatalf("Expected %d quads, got %d: %v", len(expected), len(quads), quads)
+	}
+	for i, q := range quads {
+		if got := q.Serialize(rdf.NQuads); got != expected[i] {
+			t.Errorf("Expected quad %d to be:\n%sgot:\n%s", i, expected[i], got)
+		}
+	}
+
+	// With named graphs given, the query is restricted to them
+	src.Graphs = []string{"http://rdf.pharmb.io/graph/measurements"}
+	if _, err := src.Describe(context.Background(), "http://rdf.pharmb.io/cplogd/Compound1"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(queries[1], "VALUES ?g { <http://rdf.pharmb.io/graph/measurements> }") || strings.Contains(queries[1], "{ <http://rdf.pharmb.io/cplogd/Compound1> ?p ?o .") {
+		t.Errorf("Expected the query to be restricted to the given named graph, got:\n%s", queries[1])
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/knakk/rdf"
+	bolt "go.etcd.io/bbolt"
+)
+
+// storeLoadBatchSize is the number of quads written per transaction when
+// loading RDF files into a store
+const storeLoadBatchSize = 10000
+
+// Buckets of a store file. Terms have IDs, with 0 for the default graph, and
+// are stored in the form used in HDT dictionaries (see hdtTermString). Each
+// quad is a key in each of the index buckets, made of the IDs of its terms,
+// in the order of the index, followed by its graph.
+var (
+	storeTermsBucket = []byte("terms") // ID -> term
+	storeIDsBucket   = []byte("ids")   // Term -> ID
+	storeSPOBucket   = []byte("spo")
+	storePOSBucket   = []byte("pos")
+	storeOSPBucket   = []byte("osp")
+	storeMetaBucket  = []byte("meta")
+	storeQuadsKey    = []byte("quads") // Number of quads, in the meta bucket
+
+	storeBuckets = [][]byte{storeTermsBucket, storeIDsBucket, storeSPOBucket, storePOSBucket, storeOSPBucket, storeMetaBucket}
+)
+
+// StoreSource resolves URIs based on a persistent quad store, in a single
+// file written with bbolt (an embedded key/value store), where the quads are
+// indexed by subject (SPO), predicate (POS) and object (OSP). Stores are
+// created, and RDF files loaded into them, with the load subcommand. Since
+// only the parts of the file needed are read, stores start fast, and may be
+// larger than the memory available.
+type StoreSource struct {
+	Path string
+
+	db *bolt.DB
+}
+
+// openStore opens (or creates) the store file at path. A store can only be
+// opened by one process at a time.
+func openStore(path string) (*StoreSource, error) {
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open the store %s (%s)", path, err.Error())
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range storeBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &StoreSource{Path: path, db: db}, nil
+}
+
+// Close closes the store file
+func (s *StoreSource) Close() error {
+	return s.db.Close()
+}
+
+// storeID encodes a term ID as a (big endian, so that they sort) key
+func storeID(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// storeKey returns the key of a quad in an index, with the IDs of its terms
+// in the given order
+func storeKey(ids ...uint64) []byte {
+	key := make([]byte, 0, 8*len(ids))
+	for _, id := range ids {
+		key = append(key, storeID(id)...)
+	}
+	return key
+}
+
+// storeKeyIDs splits an index key into term IDs
+func storeKeyIDs(key []byte) [4]uint64 {
+	var ids [4]uint64
+	for i := range ids {
+		ids[i] = binary.BigEndian.Uint64(key[8*i:])
+	}
+	return ids
+}
+
+// storeTermID returns the ID of term, adding it to the store if create is true.
+// It returns 0 if the term is not in the store.
+func storeTermID(tx *bolt.Tx, term rdf.Term, create bool) (uint64, error) {
+	if term == nil {
+		return 0, nil
+	}
+	key := []byte(hdtTermString(term))
+	if id := tx.Bucket(storeIDsBucket).Get(key); id != nil {
+		return binary.BigEndian.Uint64(id), nil
+	}
+	if !create {
+		return 0, nil
+	}
+	terms := tx.Bucket(storeTermsBucket)
+	id, err := terms.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	if err := terms.Put(storeID(id), key); err != nil {
+		return 0, err
+	}
+	return id, tx.Bucket(storeIDsBucket).Put(key, storeID(id))
+}
+
+// storeTerm returns the term with id
+func storeTerm(tx *bolt.Tx, id uint64) (rdf.Term, error) {
+	raw := tx.Bucket(storeTermsBucket).Get(storeID(id))
+	if raw == nil {
+		return nil, fmt.Errorf("Term %d missing from the store", id)
+	}
+	return hdtTerm(string(raw))
+}
+
+// storeQuadCount returns the number of quads in the store
+func storeQuadCount(tx *bolt.Tx) int64 {
+	if n := tx.Bucket(storeMetaBucket).Get(storeQuadsKey); n != nil {
+		return int64(binary.BigEndian.Uint64(n))
+	}
+	return 0
+}
+
+// storeSetQuadCount sets the number of quads in the store
+func storeSetQuadCount(tx *bolt.Tx, n int64) error {
+	return tx.Bucket(storeMetaBucket).Put(storeQuadsKey, storeID(uint64(n)))
+}
+
+// addQuads adds quads to the store in one transaction, returning the number
+// of them which were not already in it
+func (s *StoreSource) addQuads(quads []rdf.Quad) (int, error) {
+	added := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		added, err = storeAddQuads(tx, quads)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// storeAddQuads adds the quads which are not already in the store, and
+// returns the number of them
+func storeAddQuads(tx *bolt.Tx, quads []rdf.Quad) (int, error) {
+	added := 0
+	for _, q := range quads {
+		var ids [4]uint64
+		for i, t := range []rdf.Term{q.Subj, q.Pred, q.Obj, q.Ctx} {
+			id, err := storeTermID(tx, t, true)
+			if err != nil {
+				return 0, err
+			}
+			ids[i] = id
+		}
+		if tx.Bucket(storeSPOBucket).Get(storeKey(ids[0], ids[1], ids[2], ids[3])) != nil {
+			continue
+		}
+		if err := storePutQuad(tx, ids, []byte{}); err != nil {
+			return 0, err
+		}
+		added++
+	}
+	return added, storeSetQuadCount(tx, storeQuadCount(tx)+int64(added))
+}
+
+// storePutQuad adds (or, if value is nil, deletes) the quad with the term
+// IDs in ids (in subject, predicate, object, graph order) in each index
+func storePutQuad(tx *bolt.Tx, ids [4]uint64, value []byte) error {
+	for _, put := range []struct {
+		bucket []byte
+		key    []byte
+	}{
+		{storeSPOBucket, storeKey(ids[0], ids[1], ids[2], ids[3])},
+		{storePOSBucket, storeKey(ids[1], ids[2], ids[0], ids[3])},
+		{storeOSPBucket, storeKey(ids[2], ids[0], ids[1], ids[3])},
+	} {
+		var err error
+		if value == nil {
+			err = tx.Bucket(put.bucket).Delete(put.key)
+		} else {
+			err = tx.Bucket(put.bucket).Put(put.key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeDeleteQuads deletes the quads in index which start with the IDs in
+// prefix, and for which match returns true, returning the number of them.
+// Terms are kept, even if no quads use them anymore.
+func storeDeleteQuads(tx *bolt.Tx, index []byte, prefix []uint64, match func(ids [4]uint64) bool) (int, error) {
+	var matches [][4]uint64
+	storeScan(tx, index, prefix, func(ids [4]uint64) bool {
+		if match(ids) {
+			matches = append(matches, ids)
+		}
+		return true
+	})
+	for _, ids := range matches {
+		if err := storePutQuad(tx, ids, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(matches), storeSetQuadCount(tx, storeQuadCount(tx)-int64(len(matches)))
+}
+
+// loadRDFFile adds the quads in the RDF file at path to the store, in
+// batches of storeLoadBatchSize, returning the number of new quads
+func (s *StoreSource) loadRDFFile(path string, format string, baseIRI string) (int, error) {
+	var batch []rdf.Quad
+	added := 0
+	flush := func() error {
+		n, err := s.addQuads(batch)
+		added += n
+		batch = batch[:0]
+		return err
+	}
+	_, err := readRDFFile(path, format, baseIRI, func(q rdf.Quad) error {
+		batch = append(batch, q)
+		if len(batch) < storeLoadBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return added, err
+	}
+	return added, flush()
+}
+
+// storeScan calls visit with the IDs (in subject, predicate, object, graph order)
+// of the quads in index which start with the IDs in prefix, until visit
+// returns false
+func storeScan(tx *bolt.Tx, index []byte, prefix []uint64, visit func(ids [4]uint64) bool) {
+	order := map[string][4]int{"spo": {0, 1, 2, 3}, "pos": {2, 0, 1, 3}, "osp": {1, 2, 0, 3}}[string(index)]
+	keyPrefix := storeKey(prefix...)
+	c := tx.Bucket(index).Cursor()
+	for k, _ := c.Seek(keyPrefix); k != nil && bytes.HasPrefix(k, keyPrefix); k, _ = c.Next() {
+		keyIDs := storeKeyIDs(k)
+		var ids [4]uint64
+		for i, position := range order {
+			ids[i] = keyIDs[position]
+		}
+		if !visit(ids) {
+			return
+		}
+	}
+}
+
+// quads looks up the terms of the quads in index which start with the terms
+// in prefix. It returns no quads if any of the terms is not in the store.
+func (s *StoreSource) quads(index []byte, prefix ...rdf.Term) ([]rdf.Quad, error) {
+	var quads []rdf.Quad
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var prefixIDs []uint64
+		for _, t := range prefix {
+			id, err := storeTermID(tx, t, false)
+			if err != nil || id == 0 {
+				return err
+			}
+			prefixIDs = append(prefixIDs, id)
+		}
+		var err error
+		storeScan(tx, index, prefixIDs, func(ids [4]uint64) bool {
+			var q rdf.Quad
+			q, err = storeQuad(tx, ids)
+			quads = append(quads, q)
+			return err == nil
+		})
+		return err
+	})
+	return quads, err
+}
+
+// storeQuad returns the quad with the term IDs in ids
+func storeQuad(tx *bolt.Tx, ids [4]uint64) (rdf.Quad, error) {
+	var terms [4]rdf.Term
+	for i, id := range ids {
+		if id == 0 {
+			continue
+		}
+		t, err := storeTerm(tx, id)
+		if err != nil {
+			return rdf.Quad{}, err
+		}
+		terms[i] = t
+	}
+	subj, ok := terms[0].(rdf.Subject)
+	if !ok {
+		return rdf.Quad{}, fmt.Errorf("Invalid subject in the store: %v", terms[0])
+	}
+	pred, ok := terms[1].(rdf.Predicate)
+	if !ok {
+		return rdf.Quad{}, fmt.Errorf("Invalid predicate in the store: %v", terms[1])
+	}
+	q := rdf.Quad{Triple: rdf.Triple{Subj: subj, Pred: pred, Obj: terms[2].(rdf.Object)}}
+	if terms[3] != nil {
+		q.Ctx = terms[3].(rdf.Context)
+	}
+	return q, nil
+}
+
+// Describe returns the quads with uri as subject (from the SPO index) or
+// object (from the OSP index)
+func (s *StoreSource) Describe(ctx context.Context, uri string) ([]rdf.Quad, error) {
+	iri, err := rdf.NewIRI(uri)
+	if err != nil {
+		return nil, err
+	}
+	quads, err := s.quads(storeSPOBucket, iri)
+	if err != nil {
+		return nil, err
+	}
+	asObject, err := s.quads(storeOSPBucket, iri)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range asObject {
+		if q.Subj.String() != uri || q.Subj.Type() != rdf.TermIRI {
+			quads = append(quads, q)
+		}
+	}
+	return quads, nil
+}
+
+// Labels looks up the labels of iris in the SPO index
+func (s *StoreSource) Labels(ctx context.Context, iris []string) ([]rdf.Triple, error) {
+	var labels []rdf.Triple
+	for _, uri := range iris {
+		iri, err := rdf.NewIRI(uri)
+		if err != nil {
+			continue
+		}
+		quads, err := s.quads(storeSPOBucket, iri)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range quads {
+			if q.Obj.Type() == rdf.TermLiteral && isLabelPredicate(q.Pred.String()) {
+				labels = append(labels, q.Triple)
+			}
+		}
+	}
+	return labels, nil
+}
+
+// usageCounts counts the quads in index starting with the terms in prefix,
+// for each term at position (in subject, predicate, object order)
+func (s *StoreSource) usageCounts(index []byte, prefix []rdf.Term, position int) ([]UsageCount, error) {
+	var counts []UsageCount
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var prefixIDs []uint64
+		for _, t := range prefix {
+			id, err := storeTermID(tx, t, false)
+			if err != nil || id == 0 {
+				return err
+			}
+			prefixIDs = append(prefixIDs, id)
+		}
+		quads := make(map[uint64]int64)
+		storeScan(tx, index, prefixIDs, func(ids [4]uint64) bool {
+			quads[ids[position]]++
+			return true
+		})
+		for id, n := range quads {
+			t, err := storeTerm(tx, id)
+			if err != nil {
+				return err
+			}
+			counts = append(counts, UsageCount{t.String(), n})
+		}
+		return nil
+	})
+	sortUsageCounts(counts)
+	return counts, err
+}
+
+// Classes counts the instances of each class in the POS index (where
+// instances typed in several graphs are counted more than once)
+func (s *StoreSource) Classes(ctx context.Context) ([]UsageCount, error) {
+	typeIRI, _ := rdf.NewIRI(rdfType)
+	return s.usageCounts(storePOSBucket, []rdf.Term{typeIRI}, 2)
+}
+
+// Instances lists the instances of class in the POS index
+func (s *StoreSource) Instances(ctx context.Context, class string, offset int, limit int) ([]string, error) {
+	typeIRI, _ := rdf.NewIRI(rdfType)
+	classIRI, err := rdf.NewIRI(class)
+	if err != nil {
+		return nil, err
+	}
+	quads, err := s.quads(storePOSBucket, typeIRI, classIRI)
+	if err != nil {
+		return nil, err
+	}
+	var instances []string
+	for _, t := range quadsToTriples(quads) {
+		instances = append(instances, t.Subj.String())
+	}
+	sort.Strings(instances)
+	return pageOf(instances, offset, limit), nil
+}
+
+// Properties counts the quads of each predicate in the POS index
+func (s *StoreSource) Properties(ctx context.Context) ([]UsageCount, error) {
+	return s.usageCounts(storePOSBucket, nil, 1)
+}
+
+// storeDistinctKeys counts the distinct first term IDs in index, skipping from
+// one to the next
+func storeDistinctKeys(tx *bolt.Tx, index []byte) int64 {
+	n := int64(0)
+	c := tx.Bucket(index).Cursor()
+	for k, _ := c.First(); k != nil; n++ {
+		next := binary.BigEndian.Uint64(k) + 1
+		k, _ = c.Seek(storeID(next))
+	}
+	return n
+}
+
+// DatasetStats counts the quads, and the distinct keys of the indexes. The
+// number of triples is that of quads, so triples in several graphs are
+// counted more than once.
+func (s *StoreSource) DatasetStats(ctx context.Context, uriSpace string, public func(iri string) bool) (*DatasetStats, error) {
+	stats := newDatasetStats()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stats.Triples = storeQuadCount(tx)
+		stats.DistinctSubjects = storeDistinctKeys(tx, storeSPOBucket)
+		stats.Properties = storeDistinctKeys(tx, storePOSBucket)
+		stats.DistinctObjects = storeDistinctKeys(tx, storeOSPBucket)
+		if uriSpace == "" {
+			return nil
+		}
+		// Terms are sorted, so IRIs starting with uriSpace are found in order
+		c := tx.Bucket(storeIDsBucket).Cursor()
+		for k, id := c.Seek([]byte(uriSpace)); k != nil && strings.HasPrefix(string(k), uriSpace) && len(stats.ExampleResources) < maxExampleResources; k, id = c.Next() {
+			var isSubject bool
+			storeScan(tx, storeSPOBucket, []uint64{binary.BigEndian.Uint64(id)}, func(ids [4]uint64) bool {
+				isSubject = true
+				return false
+			})
+			if isSubject && public(string(k)) {
+				stats.ExampleResources = append(stats.ExampleResources, string(k))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	classes, err := s.Classes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats.Classes = int64(len(classes))
+	return stats, nil
+}
+
+// CheckReady verifies that the store can be read
+func (s *StoreSource) CheckReady(ctx context.Context) []HealthCheck {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range storeBuckets {
+			if tx.Bucket(name) == nil {
+				return fmt.Errorf("Bucket %s missing from the store", name)
+			}
+		}
+		return nil
+	})
+	return []HealthCheck{newHealthCheck("store", err)}
+}
+
+// runLoad runs the load subcommand, which loads RDF files into a store
+func runLoad(args []string) {
+	flags := flag.NewFlagSet("load", flag.ExitOnError)
+	storePath := flags.String("store", "", "Path of the store file to load the RDF files into (created if missing)")
+	format := flags.String("format", "", "Format of the input files. Can be one of: ntriples, nquads, turtle, rdfxml. If empty, it is guessed from the file extensions (which may end with .gz)")
+	baseIRI := flags.String("base", "", "Base IRI, to resolve relative IRIs against. If empty, the file:// IRI of each file is used")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: urisolve load -store data.db [options] input-file ...")
+		fmt.Fprintln(os.Stderr, "Loads N-Triples, N-Quads, Turtle or RDF/XML files into a store, for the store source type.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *storePath == "" || flags.NArg() == 0 {
+		log.Fatal("Both -store and at least one input file are required. Use -h to view options")
+	}
+	if *format != "" {
+		if _, ok := rdfFormats[*format]; !ok {
+			log.Fatal("Unknown format " + *format + ". Use -h to view options")
+		}
+	}
+
+	store, err := openStore(*storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	for _, input := range flags.Args() {
+		start := time.Now()
+		base := *baseIRI
+		if base == "" {
+			if base, err = fileIRI(input); err != nil {
+				log.Fatal(err)
+			}
+		}
+		added, err := store.loadRDFFile(input, *format, base)
+		if err != nil {
+			log.Fatalf("Could not load %s, after adding %d quads (%s)", input, added, err.Error())
+		}
+		log.Printf("Added %d quads from %s in %s\n", added, input, time.Since(start))
+	}
+}
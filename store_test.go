@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+// openTestStore opens a new store in a temporary directory, which is removed
+// by the returned function
+func openTestStore(t *testing.T) (*StoreSource, func()) {
+	dir, err := ioutil.TempDir("", "urisolve-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := openStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStoreSource(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	added, err := s.loadRDFFile("example_data.nt", "", "")
+	if err != nil || added != 135 {
+		t.Fatalf("Expected 135 quads to be added, got %d (%v)", added, err)
+	}
+	if added, err := s.loadRDFFile("example_data.nt", "", ""); err != nil || added != 0 {
+		t.Errorf("Expected no quads to be added twice, got %d (%v)", added, err)
+	}
+
+	// Reopen the store, to check that the data is persisted
+	path := s.Path
+	s.Close()
+	if s, err = openStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	quads, err := s.Describe(context.Background(), testCompound)
+	if err != nil || len(quads) != 6 {
+		t.Errorf("Expected 6 quads about %s, got %v (%v)", testCompound, quads, err)
+	}
+	if quads, _ := s.Describe(context.Background(), "http://rdf.pharmb.io/cplogd/Missing"); len(quads) != 0 {
+		t.Errorf("Expected no quads about an unknown IRI, got %v", quads)
+	}
+	labels, err := s.Labels(context.Background(), []string{testCompound})
+	if err != nil || len(labels) != 0 {
+		t.Errorf("Expected no labels, got %v (%v)", labels, err)
+	}
+	instances, err := s.Instances(context.Background(), "http://rdf.pharmb.io/cplogd/Compound", 0, 100)
+	if err != nil || len(instances) != 10 || instances[0] != testCompound {
+		t.Errorf("Expected 10 compounds, starting with %s, got %v (%v)", testCompound, instances, err)
+	}
+	properties, err := s.Properties(context.Background())
+	if err != nil || len(properties) != 9 {
+		t.Errorf("Expected 9 properties, got %v (%v)", properties, err)
+	}
+	stats, err := s.DatasetStats(context.Background(), "http://rdf.pharmb.io/cplogd/", allPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Triples != 135 || stats.DistinctSubjects != 51 || stats.DistinctObjects != 88 || stats.Properties != 9 || stats.Classes != 9 {
+		t.Errorf("Expected the same statistics as the HDT file, got %+v", stats)
+	}
+	if len(stats.ExampleResources) != maxExampleResources {
+		t.Errorf("Expected %d example resources, got %v", maxExampleResources, stats.ExampleResources)
+	}
+	for _, check := range s.CheckReady(context.Background()) {
+		if !check.Ok {
+			t.Errorf("Expected the store to be ready, got %+v", check)
+		}
+	}
+}
+
+func TestStoreSourceGraphs(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	a, _ := rdf.NewIRI("http://example.org/a")
+	p, _ := rdf.NewIRI("http://example.org/p")
+	g, _ := rdf.NewIRI("http://example.org/g")
+	label, _ := rdf.NewLangLiteral("A", "en")
+	blank, _ := rdf.NewBlank("b1")
+	added, err := s.addQuads([]rdf.Quad{
+		{Triple: rdf.Triple{Subj: a, Pred: p, Obj: a}},
+		{Triple: rdf.Triple{Subj: a, Pred: p, Obj: label}, Ctx: g},
+		{Triple: rdf.Triple{Subj: blank, Pred: p, Obj: a}, Ctx: g},
+		{Triple: rdf.Triple{Subj: a, Pred: p, Obj: a}},
+	})
+	if err != nil || added != 3 {
+		t.Fatalf("Expected 3 quads to be added, got %d (%v)", added, err)
+	}
+	quads, err := s.Describe(context.Background(), "http://example.org/a")
+	if err != nil || len(quads) != 3 {
+		t.Fatalf("Expected 3 quads, got %v (%v)", quads, err)
+	}
+	if quads[0].Ctx != nil || quads[1].Ctx == nil || quads[1].Obj.(rdf.Literal).Lang() != "en" {
+		t.Errorf("Expected the graphs and literals to be kept, got %v", quads)
+	}
+	if quads[2].Subj.Type() != rdf.TermBlank {
+		t.Errorf("Expected the blank node to be kept, got %v", quads[2])
+	}
+}
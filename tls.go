@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader loads a TLS certificate and key from disk, and reloads them
+// whenever either of the files changes, so that renewed certificates (e.g.
+// from cert-manager mounted secrets) are picked up without a restart.
+type certReloader struct {
+	certPath      string
+	keyPath       string
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+// newCertReloader loads the certificate at certPath with the key at keyPath,
+// returning an error if they can not be loaded. The files are checked for
+// changes at most once every checkInterval.
+func newCertReloader(certPath string, keyPath string, checkInterval time.Duration) (*certReloader, error) {
+	cr := &certReloader{certPath: certPath, keyPath: keyPath, checkInterval: checkInterval}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload loads the certificate and key from disk, if they have been modified
+// since they were last loaded. On failure, the previous certificate is kept.
+func (cr *certReloader) reload() error {
+	cr.lastCheck = time.Now()
+
+	certInfo, err := os.Stat(cr.certPath)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(cr.keyPath)
+	if err != nil {
+		return err
+	}
+	if cr.cert != nil && certInfo.ModTime().Equal(cr.certMod) && keyInfo.ModTime().Equal(cr.keyMod) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certPath, cr.keyPath)
+	if err != nil {
+		return err
+	}
+	if cr.cert != nil {
+		log.Printf("Reloaded TLS certificate from %s", cr.certPath)
+	}
+	cr.cert = &cert
+	cr.certMod = certInfo.ModTime()
+	cr.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// GetCertificate returns the current certificate, and is meant to be used as
+// the GetCertificate function of a tls.Config
+func (cr *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if time.Since(cr.lastCheck) >= cr.checkInterval {
+		if err := cr.reload(); err != nil {
+			log.Printf("Could not reload TLS certificate, keeping the current one (%s)", err.Error())
+		}
+	}
+	return cr.cert, nil
+}
+
+// newTLSConfig returns a TLS configuration serving the certificates of cr,
+// with HTTP/2 enabled
+func newTLSConfig(cr *certReloader) *tls.Config {
+	return &tls.Config{
+		GetCertificate: cr.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// HTTPSRedirectHandler redirects all requests to the same URL on HTTPS, on
+// the port given by HTTPSPort
+type HTTPSRedirectHandler struct {
+	HTTPSPort string
+}
+
+func (h *HTTPSRedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hostWithoutPort, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = hostWithoutPort
+	}
+	if h.HTTPSPort != "" && h.HTTPSPort != "443" {
+		host = net.JoinHostPort(host, h.HTTPSPort)
+	}
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
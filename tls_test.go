@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a self-signed certificate for localhost with the
+// given serial number, and its key, to certPath and keyPath
+func writeSelfSignedCert(t *testing.T, certPath string, keyPath string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certPath, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+
+	writeSelfSignedCert(t, certPath, keyPath, 1)
+	certs, err := newCertReloader(certPath, keyPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve HTTPS with the reloader, and check the certificate and protocol
+	// negotiated by a client
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: newTLSConfig(certs),
+	}
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	serials := map[int64]string{1: "initial", 2: "reloaded"}
+	for _, serial := range []int64{1, 2} {
+		if serial == 2 {
+			writeSelfSignedCert(t, certPath, keyPath, serial)
+			future := time.Now().Add(time.Minute)
+			os.Chtimes(certPath, future, future)
+			os.Chtimes(keyPath, future, future)
+		}
+
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := conn.ConnectionState()
+		conn.Close()
+
+		if got := state.PeerCertificates[0].SerialNumber.Int64(); got != serial {
+			t.Errorf("Expected the %s certificate (serial %d), got serial %d", serials[serial], serial, got)
+		}
+		if state.NegotiatedProtocol != "h2" {
+			t.Errorf("Expected HTTP/2 to be negotiated, got %q", state.NegotiatedProtocol)
+		}
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	redirects := map[string]string{
+		"443":  "https://rdf.pharmb.io/cplogd/Compound1?a=b",
+		"8443": "https://rdf.pharmb.io:8443/cplogd/Compound1?a=b",
+	}
+	for httpsPort, expectedLocation := range redirects {
+		h := &HTTPSRedirectHandler{httpsPort}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://rdf.pharmb.io:8080/cplogd/Compound1?a=b", nil))
+		if rec.Code != http.StatusMovedPermanently {
+			t.Errorf("Expected status %d, got %d", http.StatusMovedPermanently, rec.Code)
+		}
+		if location := rec.Header().Get("Location"); location != expectedLocation {
+			t.Errorf("Expected redirect to %s, got %s", expectedLocation, location)
+		}
+	}
+}
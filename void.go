@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+const (
+	voidNamespace = "http://rdfs.org/ns/void#"
+	rdfsLabel     = "http://www.w3.org/2000/01/rdf-schema#label"
+	rdfsSeeAlso   = "http://www.w3.org/2000/01/rdf-schema#seeAlso"
+	foafTopic     = "http://xmlns.com/foaf/0.1/primaryTopic"
+	xsdInteger    = "http://www.w3.org/2001/XMLSchema#integer"
+
+	// maxExampleResources is the number of example resources listed
+	maxExampleResources = 5
+	// maxExampleCandidates is the number of resources that example
+	// resources are picked among, when they can't be filtered by the source
+	maxExampleCandidates = 100
+)
+
+// DatasetStats are statistics about a whole dataset, for describing it with
+// VoID. Counts which are not known are -1.
+type DatasetStats struct {
+	Triples          int64
+	DistinctSubjects int64
+	DistinctObjects  int64
+	Properties       int64
+	Classes          int64
+	ExampleResources []string
+}
+
+// newDatasetStats returns DatasetStats with all counts unknown
+func newDatasetStats() *DatasetStats {
+	return &DatasetStats{-1, -1, -1, -1, -1, nil}
+}
+
+// DatasetDescriber is implemented by sources which can compute statistics
+// about their whole dataset
+type DatasetDescriber interface {
+	// DatasetStats computes statistics about the dataset. Example resources
+	// are picked among those starting with uriSpace, for which public
+	// returns true.
+	DatasetStats(ctx context.Context, uriSpace string, public func(iri string) bool) (*DatasetStats, error)
+}
+
+// DatasetStats reads the number of triples from the HDT header, the number
+// of distinct subjects, objects and properties from the size of the
+// dictionary sections, and picks example resources among the subjects in
+// the dictionary. Classes are counted from the rdf:type triples, which are
+// read once for each version of the files (see types).
+//
+// With several HDT files, the number of triples is the sum of those in each
+// file (which may count triples in several files more than once), and the
+// numbers of distinct subjects and objects are left unknown.
+func (s *HdtSource) DatasetStats(ctx context.Context, uriSpace string, public func(iri string) bool) (*DatasetStats, error) {
+	stats := newDatasetStats()
+	paths := s.paths()
+	predicates := make(map[string]bool)
+	for _, path := range paths {
+		header, err := readHdtHeader(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range header {
+			if t.Pred.String() == voidNamespace+"triples" {
+				if n, err := strconv.ParseInt(t.Obj.String(), 10, 64); err == nil {
+					if stats.Triples < 0 {
+						stats.Triples = 0
+					}
+					stats.Triples += n
+				}
+			}
+		}
+
+		counts, err := readHdtDictionary(path, func(section hdtSection, term string) {
+			if (section == hdtShared || section == hdtSubjects) && len(stats.ExampleResources) < maxExampleResources && strings.HasPrefix(term, uriSpace) && public(term) {
+				stats.ExampleResources = append(stats.ExampleResources, term)
+			}
+			if section == hdtPredicates {
+				predicates[term] = true
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 1 {
+			stats.DistinctSubjects = counts.distinctSubjects()
+			stats.DistinctObjects = counts.distinctObjects()
+		}
+	}
+	stats.Properties = int64(len(predicates))
+
+	types, err := s.types(ctx)
+	if err != nil {
+		log.Println("Could not count the classes in the HDT files: " + err.Error())
+		return stats, nil
+	}
+	stats.Classes = int64(len(types.classes))
+	return stats, nil
+}
+
+// DatasetStats computes the statistics with aggregate queries. Since these
+// can be too heavy for large datasets, the statistics of failed queries are
+// left unknown. Example resources are picked among the first
+// maxExampleCandidates subjects in uriSpace.
+func (s *SparqlSource) DatasetStats(ctx context.Context, uriSpace string, public func(iri string) bool) (*DatasetStats, error) {
+	stats := newDatasetStats()
+	count := func(b map[string]sparqlTerm, variable string) int64 {
+		n, err := strconv.ParseInt(b[variable].Value, 10, 64)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+
+	bindings, err := s.selectQuery(ctx, `SELECT (COUNT(*) AS ?triples) (COUNT(DISTINCT ?s) AS ?subjects) (COUNT(DISTINCT ?p) AS ?properties) (COUNT(DISTINCT ?o) AS ?objects) WHERE {
+  `+s.graphPattern("?s ?p ?o")+`
+}`)
+	if err != nil {
+		log.Println("Could not count the triples at the SPARQL endpoint: " + err.Error())
+	} else if len(bindings) > 0 {
+		stats.Triples = count(bindings[0], "triples")
+		stats.DistinctSubjects = count(bindings[0], "subjects")
+		stats.Properties = count(bindings[0], "properties")
+		stats.DistinctObjects = count(bindings[0], "objects")
+	}
+
+	bindings, err = s.selectQuery(ctx, `SELECT (COUNT(DISTINCT ?c) AS ?classes) WHERE {
+  `+s.graphPattern("?s <"+rdfType+"> ?c")+`
+}`)
+	if err != nil {
+		log.Println("Could not count the classes at the SPARQL endpoint: " + err.Error())
+	} else if len(bindings) > 0 {
+		stats.Classes = count(bindings[0], "classes")
+	}
+
+	bindings, err = s.selectQuery(ctx, `SELECT DISTINCT ?s WHERE {
+  `+s.graphPattern("?s ?p ?o")+`
+  FILTER(STRSTARTS(STR(?s), "`+strings.Replace(uriSpace, `"`, `\"`, -1)+`"))
+} LIMIT `+strconv.Itoa(maxExampleCandidates))
+	if err != nil {
+		log.Println("Could not find example resources at the SPARQL endpoint: " + err.Error())
+	}
+	for _, b := range bindings {
+		if b["s"].Type == "uri" && public(b["s"].Value) && len(stats.ExampleResources) < maxExampleResources {
+			stats.ExampleResources = append(stats.ExampleResources, b["s"].Value)
+		}
+	}
+	return stats, nil
+}
+
+// graphPattern returns a graph pattern matching triplePattern in the
+// default graph, or in s.Graphs if set
+func (s *SparqlSource) graphPattern(triplePattern string) string {
+	if len(s.Graphs) == 0 {
+		return triplePattern
+	}
+	return "GRAPH ?g { " + triplePattern + " }\n  VALUES ?g { <" + strings.Join(s.Graphs, "> <") + "> }"
+}
+
+// VoIDHandler serves a VoID description of the dataset of Source, computed
+// when first requested. The RDF serialization is negotiated with the Accept
+// header; for HTML, HomePageContent is served instead, if set. Only resources
+// which anyone may read are given as example resources (see publicIRI).
+type VoIDHandler struct {
+	URIHost         string
+	Source          DatasetDescriber
+	SparqlEndpoint  string // Public SPARQL endpoint for the dataset, if any
+	TPFEndpoint     string // Triple Pattern Fragments endpoint, if any
+	HomePageContent string
+	QueryTimeout    time.Duration
+	Limiter         *concurrencyLimiter
+	Output          OutputOptions
+
+	mu    sync.Mutex
+	stats *DatasetStats
+}
+
+// datasetIRI returns the IRI of the dataset in the VoID description
+func (h *VoIDHandler) datasetIRI() string {
+	return h.URIHost + "/.well-known/void#dataset"
+}
+
+func (h *VoIDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	format, mediaType, params := negotiateFormat(r.Header.Get("Accept"))
+	if format == nil {
+		http.Error(w, "Error: None of the requested formats are supported. Supported formats are: "+strings.Join(supportedMediaTypes(), ", "), http.StatusNotAcceptable)
+		return
+	}
+	if h.HomePageContent != "" && mediaType == "text/html" {
+		w.Write([]byte(h.HomePageContent))
+		return
+	}
+
+	stats, ok := h.datasetStats(w, r)
+	if !ok {
+		return
+	}
+	quads := h.voidQuads(stats)
+
+	w.Header().Set("Content-Type", mediaType)
+	opts := &writeOptions{OutputOptions: h.Output, Resource: h.datasetIRI(), Params: params}
+	opts.Labels = func(iris []string) map[string]string {
+		return selectLabels(quadsToTriples(quads), r.Header.Get("Accept-Language"))
+	}
+	if err := format.Write(w, quads, opts); err != nil {
+		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// datasetStats returns the dataset statistics, computing them if they have
+// not been already. If they can not be computed, an error is written to w,
+// and false returned.
+func (h *VoIDHandler) datasetStats(w http.ResponseWriter, r *http.Request) (*DatasetStats, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stats != nil {
+		return h.stats, true
+	}
+
+	if !acquireBackend(w, r, h.Limiter) {
+		return nil, false
+	}
+	defer h.Limiter.release()
+	ctx, cancel := withQueryTimeout(r.Context(), h.QueryTimeout)
+	defer cancel()
+	stats, err := h.Source.DatasetStats(ctx, h.URIHost+"/", func(iri string) bool {
+		return publicIRI(r.Context(), h.URIHost, iri)
+	})
+	if err != nil {
+		writeBackendError(w, ctx, err)
+		return nil, false
+	}
+	h.stats = stats
+	return stats, true
+}
+
+// reset forgets the dataset statistics, so that they are computed again when
+// next requested, e.g. after the data source has been reloaded
+func (h *VoIDHandler) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stats = nil
+}
+
+// voidQuads returns the VoID description of the dataset
+func (h *VoIDHandler) voidQuads(stats *DatasetStats) []rdf.Quad {
+	var quads []rdf.Quad
+	add := func(subj string, pred string, obj rdf.Object) {
+		s, _ := rdf.NewIRI(subj)
+		p, _ := rdf.NewIRI(pred)
+		quads = append(quads, rdf.Quad{Triple: rdf.Triple{Subj: s, Pred: p, Obj: obj}})
+	}
+	iri := func(s string) rdf.Object {
+		i, _ := rdf.NewIRI(s)
+		return i
+	}
+	literal := func(s string) rdf.Object {
+		l, _ := rdf.NewLiteral(s)
+		return l
+	}
+	integer, _ := rdf.NewIRI(xsdInteger)
+
+	doc := h.URIHost + "/.well-known/void"
+	dataset := h.datasetIRI()
+	add(doc, rdfType, iri(voidNamespace+"DatasetDescription"))
+	add(doc, foafTopic, iri(dataset))
+	add(dataset, rdfType, iri(voidNamespace+"Dataset"))
+	add(dataset, rdfsLabel, literal("Linked data at "+h.URIHost))
+	add(dataset, voidNamespace+"uriSpace", literal(h.URIHost+"/"))
+	counts := []struct {
+		property string
+		count    int64
+	}{
+		{"triples", stats.Triples},
+		{"distinctSubjects", stats.DistinctSubjects},
+		{"distinctObjects", stats.DistinctObjects},
+		{"properties", stats.Properties},
+		{"classes", stats.Classes},
+	}
+	for _, c := range counts {
+		if c.count >= 0 {
+			add(dataset, voidNamespace+c.property, rdf.NewTypedLiteral(strconv.FormatInt(c.count, 10), integer))
+		}
+	}
+	if h.SparqlEndpoint != "" {
+		add(dataset, voidNamespace+"sparqlEndpoint", iri(h.SparqlEndpoint))
+	}
+	if h.TPFEndpoint != "" {
+		add(dataset, rdfsSeeAlso, iri(h.TPFEndpoint))
+	}
+	for _, example := range stats.ExampleResources {
+		add(dataset, voidNamespace+"exampleResource", iri(example))
+	}
+	return quads
+}
+
+// withDatasetDescription serves the root path with description, and all
+// other paths with resources
+func withDatasetDescription(description http.Handler, resources http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			description.ServeHTTP(w, r)
+			return
+		}
+		resources.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticStats is a DatasetDescriber returning fixed statistics
+type staticStats struct {
+	stats *DatasetStats
+	calls int
+}
+
+func (s *staticStats) DatasetStats(ctx context.Context, uriSpace string, public func(iri string) bool) (*DatasetStats, error) {
+	s.calls++
+	stats := *s.stats
+	stats.ExampleResources = nil
+	for _, example := range s.stats.ExampleResources {
+		if public(example) {
+			stats.ExampleResources = append(stats.ExampleResources, example)
+		}
+	}
+	return &stats, nil
+}
+
+// allPublic is a filter for example resources accepting all of them
+func allPublic(iri string) bool {
+	return true
+}
+
+func TestHdtDatasetStats(t *testing.T) {
+	s := &HdtSource{FilePath: "example_data.hdt"}
+	stats, err := s.DatasetStats(context.Background(), "http://rdf.pharmb.io/cplogd/Compound", allPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Triples != 135 || stats.DistinctSubjects != 51 || stats.DistinctObjects != 88 || stats.Properties != 9 {
+		t.Errorf("Unexpected statistics: %+v", stats)
+	}
+	if len(stats.ExampleResources) != maxExampleResources || stats.ExampleResources[1] != "http://rdf.pharmb.io/cplogd/Compound1" {
+		t.Errorf("Unexpected example resources: %v", stats.ExampleResources)
+	}
+
+	stats, err = s.DatasetStats(context.Background(), "http://rdf.pharmb.io/cplogd/Compound", func(iri string) bool {
+		return iri != "http://rdf.pharmb.io/cplogd/Compound1"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, example := range stats.ExampleResources {
+		if example == "http://rdf.pharmb.io/cplogd/Compound1" {
+			t.Errorf("Expected only public example resources, got %v", stats.ExampleResources)
+		}
+	}
+	if len(stats.ExampleResources) != maxExampleResources {
+		t.Errorf("Expected other example resources instead, got %v", stats.ExampleResources)
+	}
+}
+
+func TestHdtDatasetStatsSeveralFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urisolve-hdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestHdtFile(t, filepath.Join(dir, "a.hdt"), 0)
+	writeTestHdtFile(t, filepath.Join(dir, "b.hdt"), 0)
+
+	s := &HdtSource{FilePath: filepath.Join(dir, "*.hdt")}
+	stats, err := s.DatasetStats(context.Background(), "http://rdf.pharmb.io/cplogd/Compound", allPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Triples != 270 || stats.DistinctSubjects != -1 || stats.DistinctObjects != -1 || stats.Properties != 9 {
+		t.Errorf("Unexpected statistics: %+v", stats)
+	}
+}
+
+func TestVoIDHandler(t *testing.T) {
+	source := &staticStats{stats: &DatasetStats{135, 51, 88, 9, -1, []string{"http://rdf.pharmb.io/cplogd/Compound1"}}}
+	h := &VoIDHandler{
+		URIHost:        "http://rdf.pharmb.io",
+		Source:         source,
+		SparqlEndpoint: "http://sparql.pharmb.io/sparql",
+		QueryTimeout:   time.Second,
+		Output:         OutputOptions{Prefixes: defaultPrefixes},
+	}
+	handler := withDatasetDescription(h, http.NotFoundHandler())
+
+	for _, path := range []string{"/", "/.well-known/void"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "text/turtle")
+		rec := httptest.NewRecorder()
+		if path == "/" {
+			handler.ServeHTTP(rec, r)
+		} else {
+			h.ServeHTTP(rec, r)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", path, rec.Code)
+		}
+		body := rec.Body.String()
+		expected := []string{
+			"<http://rdf.pharmb.io/.well-known/void#dataset> a void:Dataset",
+			"void:triples 135",
+			"void:distinctSubjects 51",
+			"void:sparqlEndpoint <http://sparql.pharmb.io/sparql>",
+			"void:exampleResource <http://rdf.pharmb.io/cplogd/Compound1>",
+			`void:uriSpace "http://rdf.pharmb.io/"`,
+		}
+		for _, s := range expected {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: expected %s in:\n%s", path, s, body)
+			}
+		}
+		if strings.Contains(body, "void:classes") {
+			t.Errorf("%s: expected no unknown class count in:\n%s", path, body)
+		}
+	}
+	if source.calls != 1 {
+		t.Errorf("Expected the statistics to be computed once, got %d times", source.calls)
+	}
+
+	h.HomePageContent = "Welcome"
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Body.String() != "Welcome" {
+		t.Errorf("Expected the custom home page for HTML, got:\n%s", rec.Body.String())
+	}
+}
+
+func TestVoIDHandlerPublicExamples(t *testing.T) {
+	source := &staticStats{stats: &DatasetStats{135, 51, 88, 9, -1, []string{"http://rdf.pharmb.io/cplogd/Compound1", "http://rdf.pharmb.io/preprint/Compound2"}}}
+	h := &VoIDHandler{URIHost: "http://rdf.pharmb.io", Source: source, QueryTimeout: time.Second}
+	auth := &Authenticator{
+		APIKeys: map[string]string{"key-of-bob": "bob"},
+		Rules:   []AccessRule{{"/preprint/", []string{"bob"}}},
+	}
+	r := httptest.NewRequest("GET", "/.well-known/void", nil)
+	r.Header.Set("Accept", "application/n-triples")
+	r.Header.Set("X-API-Key", "key-of-bob")
+	rec := httptest.NewRecorder()
+	withAuth(auth, h).ServeHTTP(rec, r)
+	if !strings.Contains(rec.Body.String(), "Compound1") || strings.Contains(rec.Body.String(), "Compound2") {
+		t.Errorf("Expected only public example resources, also for clients which may read others, got:\n%s", rec.Body.String())
+	}
+}